@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
 	"github.com/Amirali-Amirifar/kv/pkg/kvClient"
 	"os"
 	"regexp"
@@ -82,6 +83,24 @@ func executeCommand(client *kvClient.Client, cmd string, args []string) error {
 		fmt.Println("OK")
 		return nil
 
+	case "USE":
+		if len(args) != 1 {
+			return fmt.Errorf("USE requires exactly one keyspace: USE \"keyspace\"")
+		}
+
+		client.Keyspace = args[0]
+		fmt.Println("OK")
+		return nil
+
+	case "CONSISTENCY":
+		if len(args) != 1 {
+			return fmt.Errorf("CONSISTENCY requires exactly one level: CONSISTENCY strong|bounded|eventual")
+		}
+
+		client.Consistency = api.ReadConsistency(strings.ToLower(args[0]))
+		fmt.Println("OK")
+		return nil
+
 	case "QUIT", "EXIT":
 		fmt.Println("Goodbye!")
 		os.Exit(0)
@@ -102,6 +121,8 @@ func printHelp() {
 	fmt.Println("  SET \"key\" \"value\"  - Set a key-value pair")
 	fmt.Println("  GET \"key\"           - Get value for a key")
 	fmt.Println("  DEL \"key\"           - Delete a key")
+	fmt.Println("  USE \"keyspace\"      - Send the following commands to a keyspace")
+	fmt.Println("  CONSISTENCY level    - Read from the master (strong), or also followers (bounded, eventual)")
 	fmt.Println("  HELP                 - Show this help message")
 	fmt.Println("  QUIT/EXIT            - Exit the client")
 	fmt.Println()
@@ -109,6 +130,8 @@ func printHelp() {
 	fmt.Println("  SET \"mykey\" \"myvalue\"")
 	fmt.Println("  GET \"mykey\"")
 	fmt.Println("  DEL \"mykey\"")
+	fmt.Println("  USE \"team-a\"")
+	fmt.Println("  CONSISTENCY eventual")
 }
 
 func main() {
@@ -1,31 +1,75 @@
 package main
 
 import (
-	"log"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/Amirali-Amirifar/kv/internal/config"
 	"github.com/Amirali-Amirifar/kv/pkg/kvLoadbalancer"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
 )
 
-func main() {
-	// Load configuration
-
+func runKvLoadbalancer(configPath string) {
+	log.Info("Starting kvLoadbalancer")
 	var cfg config.KvLoadBalancerConfig
+	config.LoadConfig(configPath, &cfg)
 
-	config.LoadConfig("config/loadbalancer_config.yaml", &cfg)
-
-	// Initialize the loadbalancer service
 	svc := kvLoadbalancer.NewLoadBalancerService(&cfg)
 
-	svc.Serve()
+	// Reload the rate limits on SIGHUP
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go func() {
+		for range reloadChan {
+			var reloaded config.KvLoadBalancerConfig
+			if err := config.ReloadConfig(configPath, &reloaded); err != nil {
+				log.WithError(err).Warn("Failed to reload config, keeping the current one")
+				continue
+			}
+			svc.ReloadRateLimits(reloaded.RateLimits)
+		}
+	}()
 
-	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
 
-	log.Println("Shutting down server...")
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- svc.Serve()
+	}()
+
+	select {
+	case err := <-errChan:
+		log.Fatalf("Error serving kvLoadbalancer: %v", err)
+	case sig := <-sigChan:
+		log.WithField("signal", sig).Info("Shutting down, draining requests in flight")
+	}
+
+	if err := svc.Shutdown(); err != nil {
+		log.WithError(err).Error("Shut down before every request finished")
+		os.Exit(1)
+	}
+	log.Info("Exiting")
+}
+
+func main() {
+	var configPath string
+
+	rootCmd := &cobra.Command{
+		Use:   "kvLoadbalancer",
+		Short: "Stateless router in front of the kv nodes",
+		Run: func(cmd *cobra.Command, args []string) {
+			runKvLoadbalancer(configPath)
+		},
+	}
+
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "./config/loadbalancer_config.yaml", "Path to config file")
+
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 }
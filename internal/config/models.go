@@ -6,28 +6,217 @@ type AddressConfig struct {
 }
 
 type ClusterConfig struct {
-	Partitions int `mapstructure:"partitions"`
-	Replicas   int `mapstructure:"replicas"`
+	Partitions       int    `mapstructure:"partitions"`
+	Replicas         int    `mapstructure:"replicas"`
+	CatchUpTimeoutMs int    `mapstructure:"catch_up_timeout_ms"`
+	VirtualNodes     int    `mapstructure:"virtual_nodes"`
+	Partitioning     string `mapstructure:"partitioning"`
+}
+
+// RangeConfig tunes automatic split and merge of shards in range partitioning mode.
+// A zero threshold disables the corresponding check.
+type RangeConfig struct {
+	CheckIntervalMs   int     `mapstructure:"check_interval_ms"`
+	SplitMaxKeys      int     `mapstructure:"split_max_keys"`
+	SplitMaxOpsPerSec float64 `mapstructure:"split_max_ops_per_sec"`
+	MergeMaxKeys      int     `mapstructure:"merge_max_keys"`
+	MergeMaxOpsPerSec float64 `mapstructure:"merge_max_ops_per_sec"`
 }
 
 type DiscoveryConfig struct {
 	HeartbeatIntervalMs int `mapstructure:"heartbeat_interval_ms"`
 	FailureTimeoutMs    int `mapstructure:"failure_timeout_ms"`
+	// SuspectPhi and FailurePhi are the phi-accrual thresholds at which a node
+	// is marked SUSPECT and FAILED.
+	SuspectPhi float64 `mapstructure:"suspect_phi"`
+	FailurePhi float64 `mapstructure:"failure_phi"`
+}
+
+// BalancerConfig tunes the background leader balancer. It only moves
+// leadership to followers that have caught up with their master. It is off
+// unless enabled, each move stops the shard's writes until the target applied
+// the last of them.
+type BalancerConfig struct {
+	Enabled          bool `mapstructure:"enabled"`
+	DryRun           bool `mapstructure:"dry_run"`
+	IntervalMs       int  `mapstructure:"interval_ms"`
+	MaxMovesPerRound int  `mapstructure:"max_moves_per_round"`
+	ShardCooldownMs  int  `mapstructure:"shard_cooldown_ms"`
+	// LoadImbalance is how many times the average write load the busiest host
+	// must carry before leadership is moved for load alone.
+	LoadImbalance float64 `mapstructure:"load_imbalance"`
+}
+
+// HotspotsConfig decides when a shard is reported as hot: its request rate must
+// be LoadFactor times the cluster average and at least MinOpsPerSec.
+type HotspotsConfig struct {
+	LoadFactor   float64 `mapstructure:"load_factor"`
+	MinOpsPerSec float64 `mapstructure:"min_ops_per_sec"`
+}
+
+// EventsConfig sizes the controller's cluster event journal.
+type EventsConfig struct {
+	Retention int `mapstructure:"retention"`
 }
 
 type KvControllerConfig struct {
 	Address   AddressConfig   `mapstructure:"address"`
 	Cluster   ClusterConfig   `mapstructure:"cluster"`
 	Discovery DiscoveryConfig `mapstructure:"discovery"`
+	Ranges    RangeConfig     `mapstructure:"ranges"`
+	Balancer  BalancerConfig  `mapstructure:"balancer"`
+	Events    EventsConfig    `mapstructure:"events"`
+	Hotspots  HotspotsConfig  `mapstructure:"hotspots"`
+}
+
+// TopologyConfig labels where a node runs, the controller spreads the
+// replicas of a shard across distinct zones, racks and hosts.
+type TopologyConfig struct {
+	Zone string `mapstructure:"zone"`
+	Rack string `mapstructure:"rack"`
+	Host string `mapstructure:"host"`
+}
+
+// HotKeysConfig sizes the sketch a node uses to find its most requested keys.
+// SampleRate is the share of requests counted, between 0 and 1.
+type HotKeysConfig struct {
+	Capacity   int     `mapstructure:"capacity"`
+	SampleRate float64 `mapstructure:"sample_rate"`
 }
 
 type KvNodeConfig struct {
-	Address     AddressConfig `mapstructure:"address"`
-	Controller  AddressConfig `mapstructure:"controller"`
-	HTTPTimeout int           `mapstructure:"http_timeout_ms"`
+	Address             AddressConfig  `mapstructure:"address"`
+	Controller          AddressConfig  `mapstructure:"controller"`
+	HTTPTimeout         int            `mapstructure:"http_timeout_ms"`
+	HeartbeatIntervalMs int            `mapstructure:"heartbeat_interval_ms"`
+	Topology            TopologyConfig `mapstructure:"topology"`
+	HotKeys             HotKeysConfig  `mapstructure:"hot_keys"`
 }
 
 type KvLoadBalancerConfig struct {
+	// ID tells the instances of a fleet apart, it defaults to the address
+	// with the hostname in place of an unspecified host.
+	ID         string        `mapstructure:"id"`
 	Address    AddressConfig `mapstructure:"address"`
 	Controller AddressConfig `mapstructure:"controller"`
+	// HeartbeatIntervalMs is how often the load balancer registers with the controller.
+	HeartbeatIntervalMs int         `mapstructure:"heartbeat_interval_ms"`
+	Drain               DrainConfig `mapstructure:"drain"`
+	// TopologyWatchTimeoutMs is how long one topology long-poll may wait for a
+	// change, TopologyPollIntervalMs how often the topology is polled while watching fails.
+	TopologyWatchTimeoutMs int               `mapstructure:"topology_watch_timeout_ms"`
+	TopologyPollIntervalMs int               `mapstructure:"topology_poll_interval_ms"`
+	Retry                  RetryConfig       `mapstructure:"retry"`
+	Transport              TransportConfig   `mapstructure:"transport"`
+	Breaker                BreakerConfig     `mapstructure:"breaker"`
+	ReadRouting            ReadRoutingConfig `mapstructure:"read_routing"`
+	Hedging                HedgingConfig     `mapstructure:"hedging"`
+	RateLimits             RateLimitConfig   `mapstructure:"rate_limits"`
+	Cache                  CacheConfig       `mapstructure:"cache"`
+	Coalescing             CoalescingConfig  `mapstructure:"coalescing"`
+}
+
+// DrainConfig is how a load balancer shuts down. It reports not ready for
+// DelayMs while still serving so upstream health checks move traffic away,
+// then waits up to TimeoutMs for the requests in flight.
+type DrainConfig struct {
+	DelayMs   int `mapstructure:"delay_ms"`
+	TimeoutMs int `mapstructure:"timeout_ms"`
+}
+
+// CoalescingConfig turns on sharing one request to the nodes between
+// concurrent identical reads.
+type CoalescingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// CacheConfig sizes the load balancer's read cache. Entries live at most TTLMs
+// and are evicted when their key is written, masters' write streams are polled
+// every InvalidationPollMs.
+type CacheConfig struct {
+	Enabled            bool `mapstructure:"enabled"`
+	MaxEntries         int  `mapstructure:"max_entries"`
+	TTLMs              int  `mapstructure:"ttl_ms"`
+	InvalidationPollMs int  `mapstructure:"invalidation_poll_ms"`
+}
+
+// RateLimitConfig sets the load balancer's admission control. Clients, told
+// apart by KeyBy (api_key, ip or keyspace), each get a token bucket with the
+// Default limit unless Clients sets theirs. MaxInFlight caps the requests
+// served at once across all clients, zero is unlimited, and a request waits up
+// to QueueTimeoutMs for a free slot before it is shed.
+type RateLimitConfig struct {
+	KeyBy          string        `mapstructure:"key_by"`
+	Default        ClientLimit   `mapstructure:"default"`
+	Clients        []ClientLimit `mapstructure:"clients"`
+	MaxInFlight    int           `mapstructure:"max_in_flight"`
+	QueueTimeoutMs int           `mapstructure:"queue_timeout_ms"`
+}
+
+// ClientLimit is the rate limit of a client in requests per second, a zero
+// Rate is unlimited. ID is unused in the default limit.
+type ClientLimit struct {
+	ID    string  `mapstructure:"id"`
+	Rate  float64 `mapstructure:"rate"`
+	Burst float64 `mapstructure:"burst"`
+}
+
+// HedgingConfig sets when reads are duplicated to a second replica: after the
+// Percentile of the last WindowSize read latencies, at least MinDelayMs, with
+// hedges capped at BudgetPercent of the reads.
+type HedgingConfig struct {
+	Enabled       bool    `mapstructure:"enabled"`
+	Percentile    float64 `mapstructure:"percentile"`
+	MinDelayMs    int     `mapstructure:"min_delay_ms"`
+	BudgetPercent float64 `mapstructure:"budget_percent"`
+	WindowSize    int     `mapstructure:"window_size"`
+}
+
+// ReadRoutingConfig sets how reads pick a member of a shard. Strategy is p2c
+// (power of two choices) or least_outstanding, DefaultConsistency applies to
+// reads that do not ask for one and is bounded if unset, MaxFollowerLag bounds
+// bounded reads and LatencyDecayMs is how fast the latency of an idle node is
+// forgotten. Lags are as of the last heartbeats, published in power of two
+// buckets whose ceiling is held against MaxFollowerLag, so 2^n-1 is the exact
+// bound.
+type ReadRoutingConfig struct {
+	Strategy           string `mapstructure:"strategy"`
+	DefaultConsistency string `mapstructure:"default_consistency"`
+	MaxFollowerLag     int64  `mapstructure:"max_follower_lag"`
+	LatencyDecayMs     int    `mapstructure:"latency_decay_ms"`
+}
+
+// BreakerConfig sets when the load balancer's circuit breaker to a node opens:
+// once the last WindowSize requests, at least MinRequests of them, fail at
+// ErrorRate or their LatencyPercentile reaches LatencyThresholdMs. An open
+// breaker lets HalfOpenProbes requests through after OpenMs. Breaker states are
+// reported to the controller every ReportIntervalMs.
+type BreakerConfig struct {
+	WindowSize         int     `mapstructure:"window_size"`
+	MinRequests        int     `mapstructure:"min_requests"`
+	ErrorRate          float64 `mapstructure:"error_rate"`
+	LatencyPercentile  float64 `mapstructure:"latency_percentile"`
+	LatencyThresholdMs int     `mapstructure:"latency_threshold_ms"`
+	OpenMs             int     `mapstructure:"open_ms"`
+	HalfOpenProbes     int     `mapstructure:"half_open_probes"`
+	ReportIntervalMs   int     `mapstructure:"report_interval_ms"`
+}
+
+// TransportConfig tunes the load balancer's connection pool to each node.
+// RequestTimeoutMs bounds a request to a node unless the client's deadline is sooner.
+type TransportConfig struct {
+	MaxConnsPerNode     int `mapstructure:"max_conns_per_node"`
+	MaxIdleConnsPerNode int `mapstructure:"max_idle_conns_per_node"`
+	IdleConnTimeoutMs   int `mapstructure:"idle_conn_timeout_ms"`
+	DialTimeoutMs       int `mapstructure:"dial_timeout_ms"`
+	KeepAliveMs         int `mapstructure:"keep_alive_ms"`
+	RequestTimeoutMs    int `mapstructure:"request_timeout_ms"`
+}
+
+// RetryConfig sets how the load balancer retries failed operations: up to
+// MaxAttempts tries with an exponential backoff from BaseBackoffMs to MaxBackoffMs.
+type RetryConfig struct {
+	MaxAttempts   int `mapstructure:"max_attempts"`
+	BaseBackoffMs int `mapstructure:"base_backoff_ms"`
+	MaxBackoffMs  int `mapstructure:"max_backoff_ms"`
 }
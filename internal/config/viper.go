@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/spf13/viper"
@@ -20,3 +21,19 @@ func LoadConfig(configPath string, out interface{}) {
 		log.Fatalf("error unmarshalling config: %v", err)
 	}
 }
+
+// ReloadConfig reads configPath into out again, returning errors instead of
+// exiting so a running service can keep its current config.
+func ReloadConfig(configPath string, out interface{}) error {
+	v := viper.New()
+	v.SetConfigFile(configPath)
+	v.AutomaticEnv()
+
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("error reading config: %v", err)
+	}
+	if err := v.Unmarshal(out); err != nil {
+		return fmt.Errorf("error unmarshalling config: %v", err)
+	}
+	return nil
+}
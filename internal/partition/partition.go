@@ -0,0 +1,48 @@
+package partition
+
+import "fmt"
+
+// Mode selects how keys are spread over shards.
+type Mode string
+
+const (
+	// ModeHash places keys on a consistent hash ring.
+	ModeHash Mode = "hash"
+	// ModeRange gives every shard a contiguous range of keys.
+	ModeRange Mode = "range"
+)
+
+// ParseMode validates a partitioning mode from the config, empty means hash.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModeHash:
+		return ModeHash, nil
+	case ModeRange:
+		return ModeRange, nil
+	default:
+		return "", fmt.Errorf("unknown partitioning mode %q", s)
+	}
+}
+
+// Map is the versioned description of how keys are spread over shards.
+type Map struct {
+	Version      int64   `json:"version"`
+	Mode         Mode    `json:"mode"`
+	VirtualNodes int     `json:"virtual_nodes,omitempty"`
+	Shards       []int   `json:"shards"`
+	Ranges       []Range `json:"ranges,omitempty"`
+}
+
+// Locator finds the shard owning a key.
+type Locator interface {
+	Locate(key string) (int, bool)
+	Version() int64
+}
+
+// NewLocator builds the lookup structure matching the mode of m.
+func NewLocator(m Map) Locator {
+	if m.Mode == ModeRange {
+		return NewRangeTable(m)
+	}
+	return NewRing(m)
+}
@@ -0,0 +1,68 @@
+package partition
+
+import "sort"
+
+// Range is the half-open key range [Start, End) owned by a shard. An empty End
+// means the range is unbounded above.
+type Range struct {
+	ShardKey int    `json:"shard_key"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+}
+
+// Contains reports whether key falls inside the range.
+func (r Range) Contains(key string) bool {
+	return key >= r.Start && (r.End == "" || key < r.End)
+}
+
+// EvenRanges cuts the keyspace into one range per shard by splitting on the
+// first byte of the key. Split points stay within ASCII so they survive JSON
+// encoding, hot ranges are split further at runtime.
+func EvenRanges(shards []int) []Range {
+	ranges := make([]Range, len(shards))
+	for i, shard := range shards {
+		ranges[i].ShardKey = shard
+		if i > 0 {
+			ranges[i].Start = string([]byte{byte(i * 128 / len(shards))})
+			ranges[i-1].End = ranges[i].Start
+		}
+	}
+	return ranges
+}
+
+// RangeTable locates keys in a sorted list of ranges.
+type RangeTable struct {
+	version int64
+	ranges  []Range
+}
+
+// NewRangeTable builds the table described by m.
+func NewRangeTable(m Map) *RangeTable {
+	ranges := make([]Range, len(m.Ranges))
+	copy(ranges, m.Ranges)
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+
+	return &RangeTable{
+		version: m.Version,
+		ranges:  ranges,
+	}
+}
+
+// Version returns the version of the partition map the table was built from.
+func (t *RangeTable) Version() int64 {
+	return t.version
+}
+
+// Locate returns the shard whose range contains key, false if no range does.
+func (t *RangeTable) Locate(key string) (int, bool) {
+	// Find the last range starting at or before key
+	i := sort.Search(len(t.ranges), func(i int) bool {
+		return t.ranges[i].Start > key
+	}) - 1
+	if i < 0 || !t.ranges[i].Contains(key) {
+		return 0, false
+	}
+	return t.ranges[i].ShardKey, true
+}
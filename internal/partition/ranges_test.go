@@ -0,0 +1,65 @@
+package partition
+
+import "testing"
+
+func TestRangeContains(t *testing.T) {
+	tests := []struct {
+		r    Range
+		key  string
+		want bool
+	}{
+		{Range{Start: "b", End: "d"}, "b", true},
+		{Range{Start: "b", End: "d"}, "c", true},
+		{Range{Start: "b", End: "d"}, "d", false},
+		{Range{Start: "b", End: "d"}, "a", false},
+		{Range{Start: "b"}, "zzz", true},
+		{Range{}, "", true},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Contains(tt.key); got != tt.want {
+			t.Errorf("%+v.Contains(%q) = %v, want %v", tt.r, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestEvenRangesCoverTheKeyspace(t *testing.T) {
+	ranges := EvenRanges([]int{0, 1, 2, 3})
+	if ranges[0].Start != "" || ranges[len(ranges)-1].End != "" {
+		t.Fatalf("ranges %+v leave the ends of the keyspace uncovered", ranges)
+	}
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i-1].End != ranges[i].Start || ranges[i].Start <= ranges[i-1].Start {
+			t.Fatalf("ranges %d and %d are not adjacent: %+v", i-1, i, ranges)
+		}
+	}
+}
+
+func TestRangeTableLocatesAcrossSplitsAndMerges(t *testing.T) {
+	table := NewRangeTable(Map{Mode: ModeRange, Ranges: []Range{
+		{ShardKey: 1, Start: "m"},
+		{ShardKey: 0, Start: "", End: "m"},
+	}})
+	for key, want := range map[string]int{"": 0, "a": 0, "lz": 0, "m": 1, "zz": 1} {
+		if got, ok := table.Locate(key); !ok || got != want {
+			t.Errorf("Locate(%q) = %d, %v, want %d", key, got, ok, want)
+		}
+	}
+
+	// Shard 1 split at "t", the upper half moved to shard 2
+	split := NewRangeTable(Map{Mode: ModeRange, Ranges: []Range{
+		{ShardKey: 0, Start: "", End: "m"},
+		{ShardKey: 1, Start: "m", End: "t"},
+		{ShardKey: 2, Start: "t"},
+	}})
+	for key, want := range map[string]int{"a": 0, "m": 1, "sz": 1, "t": 2, "zz": 2} {
+		if got, ok := split.Locate(key); !ok || got != want {
+			t.Errorf("after the split Locate(%q) = %d, %v, want %d", key, got, ok, want)
+		}
+	}
+
+	// A gap left by a range being handed over has no owner
+	gap := NewRangeTable(Map{Mode: ModeRange, Ranges: []Range{{ShardKey: 0, Start: "", End: "m"}}})
+	if _, ok := gap.Locate("x"); ok {
+		t.Error("a key outside every range was located")
+	}
+}
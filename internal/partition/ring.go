@@ -0,0 +1,91 @@
+// Package partition maps keys to shards. The controller owns the partition
+// map and publishes it, every router rebuilds the same lookup from it so all
+// of them agree on where a key lives.
+package partition
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// DefaultVirtualNodes is used when the controller config does not set virtual_nodes.
+const DefaultVirtualNodes = 128
+
+type point struct {
+	hash  uint64
+	shard int
+}
+
+// Ring is a consistent hash ring with VirtualNodes points per shard. Adding or
+// removing a shard only moves the keys that fall between its points and
+// their predecessors.
+type Ring struct {
+	version int64
+	points  []point
+}
+
+// NewRing builds the ring described by m.
+func NewRing(m Map) *Ring {
+	vnodes := m.VirtualNodes
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+
+	points := make([]point, 0, len(m.Shards)*vnodes)
+	for _, shard := range m.Shards {
+		for i := 0; i < vnodes; i++ {
+			points = append(points, point{
+				hash:  hashKey(fmt.Sprintf("shard-%d#%d", shard, i)),
+				shard: shard,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].shard < points[j].shard
+		}
+		return points[i].hash < points[j].hash
+	})
+
+	return &Ring{
+		version: m.Version,
+		points:  points,
+	}
+}
+
+// Version returns the version of the partition map the ring was built from.
+func (r *Ring) Version() int64 {
+	return r.version
+}
+
+// Locate returns the shard owning key, false if the ring has no shards.
+func (r *Ring) Locate(key string) (int, bool) {
+	if len(r.points) == 0 {
+		return 0, false
+	}
+
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].shard, true
+}
+
+// hashKey hashes s with FNV-1a and a final avalanche step, plain FNV clusters
+// the points of similar virtual node names.
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,73 @@
+package partition
+
+import (
+	"fmt"
+	"testing"
+)
+
+const testKeys = 20000
+
+func locateAll(t *testing.T, locator Locator) []int {
+	t.Helper()
+	owners := make([]int, testKeys)
+	for i := range owners {
+		shard, ok := locator.Locate(fmt.Sprintf("key-%d", i))
+		if !ok {
+			t.Fatalf("key-%d has no owner", i)
+		}
+		owners[i] = shard
+	}
+	return owners
+}
+
+func TestRingAddingAShardOnlyMovesKeysToIt(t *testing.T) {
+	before := locateAll(t, NewRing(Map{Shards: []int{0, 1, 2, 3}}))
+	after := locateAll(t, NewRing(Map{Shards: []int{0, 1, 2, 3, 4}}))
+
+	moved := 0
+	for i := range before {
+		if before[i] == after[i] {
+			continue
+		}
+		if after[i] != 4 {
+			t.Fatalf("key-%d moved from shard %d to %d, not to the new shard", i, before[i], after[i])
+		}
+		moved++
+	}
+	// The new shard takes its fair share, a fifth of the keys
+	if share := float64(moved) / testKeys; share < 0.12 || share > 0.28 {
+		t.Fatalf("%.2f of the keys moved, want about 0.2", share)
+	}
+}
+
+func TestRingRemovingAShardOnlyMovesItsKeys(t *testing.T) {
+	before := locateAll(t, NewRing(Map{Shards: []int{0, 1, 2, 3}}))
+	after := locateAll(t, NewRing(Map{Shards: []int{0, 1, 3}}))
+
+	for i := range before {
+		if before[i] != 2 && before[i] != after[i] {
+			t.Fatalf("key-%d moved from shard %d to %d although its shard stayed", i, before[i], after[i])
+		}
+		if after[i] == 2 {
+			t.Fatalf("key-%d is still on the removed shard", i)
+		}
+	}
+}
+
+func TestRingSpreadsKeysEvenly(t *testing.T) {
+	counts := make(map[int]int)
+	for _, shard := range locateAll(t, NewRing(Map{Shards: []int{0, 1, 2, 3}})) {
+		counts[shard]++
+	}
+	for shard := 0; shard < 4; shard++ {
+		if share := float64(counts[shard]) / testKeys; share < 0.15 || share > 0.35 {
+			t.Fatalf("shard %d owns %.2f of the keys, want about 0.25", shard, share)
+		}
+	}
+}
+
+func TestRingWithoutShards(t *testing.T) {
+	if _, ok := NewRing(Map{}).Locate("key"); ok {
+		t.Fatal("an empty ring located a key")
+	}
+}
@@ -0,0 +1,66 @@
+// Package ratelimit holds the token bucket shared by the services that throttle requests.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket refilled at rate tokens per second and holding up to
+// burst tokens. A zero rate never limits.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket. A burst below one is raised to one token, or
+// to the rate when that is higher.
+func NewBucket(rate, burst float64) *Bucket {
+	b := &Bucket{last: time.Now()}
+	b.SetRate(rate, burst)
+	b.tokens = b.burst
+	return b
+}
+
+// SetRate changes the refill rate and the capacity, the tokens already held are kept.
+func (b *Bucket) SetRate(rate, burst float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if burst < 1 {
+		burst = max(rate, 1)
+	}
+	b.refill(time.Now())
+	b.rate = rate
+	b.burst = burst
+	b.tokens = min(b.tokens, burst)
+}
+
+// Allow takes one token. When none is left it reports how long until one is.
+func (b *Bucket) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate <= 0 {
+		return true, 0
+	}
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / b.rate
+	return false, time.Duration(math.Ceil(wait*1000)) * time.Millisecond
+}
+
+// refill must be called with the lock held.
+func (b *Bucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
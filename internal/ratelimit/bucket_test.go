@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// advance moves the bucket's clock back by d, as if d had passed.
+func advance(b *Bucket, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last = b.last.Add(-d)
+}
+
+func TestBucketAllowsBurstThenRefills(t *testing.T) {
+	b := NewBucket(10, 3)
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.Allow(); !ok {
+			t.Fatalf("request %d of the burst was rejected", i)
+		}
+	}
+	ok, wait := b.Allow()
+	if ok {
+		t.Fatal("a request past the burst was allowed")
+	}
+	if wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("told to wait %v, want at most one token's refill of 100ms", wait)
+	}
+
+	advance(b, 200*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Allow(); !ok {
+			t.Fatalf("request %d after refilling two tokens was rejected", i)
+		}
+	}
+	if ok, _ := b.Allow(); ok {
+		t.Fatal("more tokens were taken than refilled")
+	}
+}
+
+func TestBucketRefillIsCappedAtBurst(t *testing.T) {
+	b := NewBucket(100, 2)
+	advance(b, time.Hour)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := b.Allow(); ok {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("allowed %d requests after a long idle time, want the burst of 2", allowed)
+	}
+}
+
+func TestBucketZeroRateNeverLimits(t *testing.T) {
+	b := NewBucket(0, 0)
+	for i := 0; i < 1000; i++ {
+		if ok, _ := b.Allow(); !ok {
+			t.Fatalf("request %d was limited without a rate", i)
+		}
+	}
+}
+
+func TestBucketSetRateKeepsTokens(t *testing.T) {
+	b := NewBucket(1, 5)
+	b.Allow()
+	b.Allow()
+	b.SetRate(1, 10)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := b.Allow(); ok {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("allowed %d requests after raising the burst, want the 3 tokens left", allowed)
+	}
+
+	b.SetRate(1, 0.5)
+	if b.burst != 1 {
+		t.Fatalf("burst is %v, want it raised to one token", b.burst)
+	}
+}
@@ -3,23 +3,647 @@
 
 package api
 
+import (
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// DefaultKeyspace holds the keys of requests that name no keyspace, it always
+// exists and cannot be dropped.
+const DefaultKeyspace = "default"
+
 type GetRequest struct {
-	Key string `json:"key"`
+	Keyspace string `json:"keyspace,omitempty"`
+	Key      string `json:"key"`
+	// Consistency and NoCache are only read by load balancers, an empty
+	// consistency uses their default and NoCache skips their read cache
+	Consistency ReadConsistency `json:"consistency,omitempty"`
+	NoCache     bool            `json:"no_cache,omitempty"`
 }
 
+// ReadConsistency is which members of a shard may serve a read. Strong reads
+// are served by the master, bounded ones also by followers lagging at most the
+// configured number of WAL records, eventual ones by any serving member.
+type ReadConsistency string
+
+const (
+	ConsistencyStrong   ReadConsistency = "strong"
+	ConsistencyBounded  ReadConsistency = "bounded"
+	ConsistencyEventual ReadConsistency = "eventual"
+)
+
 type GetResponse struct {
 	Value string `json:"value"`
 }
 
 type SetRequest struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Keyspace string `json:"keyspace,omitempty"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
 }
 
 type SetResponse struct{}
 
 type DelRequest struct {
-	Key string `json:"key"`
+	Keyspace string `json:"keyspace,omitempty"`
+	Key      string `json:"key"`
 }
 
 type DelResponse struct{}
+
+// NodeStats describes the load of the shard copy held by a node.
+type NodeStats struct {
+	Keys      int     `json:"keys"`
+	Bytes     int64   `json:"bytes"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	// HotKeys are the most requested keys, estimated from a sample of the requests.
+	HotKeys []KeyLoad `json:"hot_keys,omitempty"`
+	// Keyspaces breaks Keys and Bytes down by keyspace.
+	Keyspaces map[string]KeyspaceUsage `json:"keyspaces,omitempty"`
+}
+
+// KeyLoad is the estimated request rate of a key. Error bounds how much the
+// estimate may overcount.
+type KeyLoad struct {
+	Key       string  `json:"key"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	Error     float64 `json:"error"`
+}
+
+// RangeMedianResponse is the median key of a key range and the number of keys in it.
+type RangeMedianResponse struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// RangeExportResponse carries every key-value pair of a key range by keyspace,
+// it is also the body of an import into another shard's master.
+type RangeExportResponse struct {
+	Data map[string]map[string]string `json:"data"`
+}
+
+// RangeDropRequest asks a master to delete every key of the range [Start, End).
+type RangeDropRequest struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// RangeMigrationRequest tells a master that the keys of [Start, End) are
+// served by the master of another shard while it hands them over, or that the
+// hand-over was cancelled. Clients asking for them are sent there with ASK.
+// Freeze marks the copy that comes first: the master rejects writes to the
+// keys but keeps serving their reads. Import is sent to the receiving master
+// instead, ShardKey naming the shard the keys come from: it serves requests
+// following an ASK for them until its topology assigns it the range.
+type RangeMigrationRequest struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	ShardKey int    `json:"shard_key"`
+	NodeID   int    `json:"node_id"`
+	Address  string `json:"address"`
+	Freeze   bool   `json:"freeze,omitempty"`
+	Import   bool   `json:"import,omitempty"`
+	Cancel   bool   `json:"cancel,omitempty"`
+}
+
+// HeartbeatRequest is pushed periodically by every node to the controller.
+type HeartbeatRequest struct {
+	NodeID  int                   `json:"node_id"`
+	LastSeq int64                 `json:"last_seq"`
+	Role    cluster.StoreNodeType `json:"role"`
+	Epoch   int64                 `json:"epoch"`
+	Load    NodeStats             `json:"load"`
+	// SettingsVersion is the version of the runtime settings the node runs.
+	SettingsVersion int64 `json:"settings_version"`
+}
+
+// HeartbeatResponse is the controller's view of the node sending the heartbeat.
+type HeartbeatResponse struct {
+	Status cluster.NodeStatus `json:"status"`
+	Epoch  int64              `json:"epoch"`
+	// Rejoin is set when the node was declared failed or lost its role while
+	// it was unreachable, the node must follow it before serving again.
+	Rejoin *RejoinInfo `json:"rejoin,omitempty"`
+	// KeyspacesVersion is the version of the keyspace catalog, a node that
+	// holds an older one fetches it again.
+	KeyspacesVersion int64 `json:"keyspaces_version"`
+	// RemoteUsage is what each keyspace stores in the other shards of the
+	// cluster, the node adds its own usage to it when enforcing quotas.
+	RemoteUsage map[string]KeyspaceUsage `json:"remote_usage,omitempty"`
+	// SettingsVersion is the version of the runtime settings, a node running
+	// an older one fetches them again.
+	SettingsVersion int64 `json:"settings_version"`
+}
+
+// RejoinInfo tells a returning node its current role in the shard. A node
+// whose WAL goes past ForkSeq holds writes the current master never saw and
+// must discard them.
+type RejoinInfo struct {
+	Role          cluster.StoreNodeType `json:"role"`
+	LeaderID      int                   `json:"leader_id"`
+	LeaderAddress string                `json:"leader_address"`
+	Epoch         int64                 `json:"epoch"`
+	ForkSeq       int64                 `json:"fork_seq"`
+}
+
+// StateUpdate changes the role of a node in its shard. LeaderAddress is the
+// host:port of the leader, the controller sends it along so a node never has
+// to call back into the controller while the controller waits on it. Like on
+// a rejoin, a follower whose WAL goes past ForkSeq discards its data.
+type StateUpdate struct {
+	State         cluster.StoreNodeType `json:"state"`
+	LeaderID      int                   `json:"leader_id"`
+	LeaderAddress string                `json:"leader_address"`
+	Epoch         int64                 `json:"epoch"`
+	ForkSeq       int64                 `json:"fork_seq"`
+}
+
+// FenceRequest stops a master from accepting writes during a leadership
+// transfer, or lets it accept them again when the transfer is called off.
+type FenceRequest struct {
+	Fenced bool `json:"fenced"`
+}
+
+// ShardAssignment moves a node to another shard, a negative ShardKey returns
+// it to the spare pool.
+type ShardAssignment struct {
+	ShardKey      int                   `json:"shard_key"`
+	State         cluster.StoreNodeType `json:"state"`
+	LeaderID      int                   `json:"leader_id"`
+	LeaderAddress string                `json:"leader_address"`
+}
+
+// BalancerHost is the leadership and write load carried by one host.
+type BalancerHost struct {
+	Host      string  `json:"host"`
+	Masters   int     `json:"masters"`
+	WriteLoad float64 `json:"write_load"`
+}
+
+// BalancerMove is a leadership transfer made, or in dry-run mode planned, by the leader balancer.
+type BalancerMove struct {
+	ShardKey int       `json:"shard_key"`
+	From     int       `json:"from"`
+	To       int       `json:"to"`
+	Reason   string    `json:"reason"`
+	DryRun   bool      `json:"dry_run"`
+	Time     time.Time `json:"time"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// BalancerStatus reports the state of the leader balancer.
+type BalancerStatus struct {
+	Paused  bool           `json:"paused"`
+	DryRun  bool           `json:"dry_run"`
+	LastRun time.Time      `json:"last_run"`
+	Hosts   []BalancerHost `json:"hosts"`
+	Moves   []BalancerMove `json:"moves"`
+}
+
+// BalancerSettings changes the leader balancer at runtime, nil fields are left as they are.
+type BalancerSettings struct {
+	Paused *bool `json:"paused"`
+	DryRun *bool `json:"dry_run"`
+}
+
+// Topology is a versioned snapshot of everything a router needs to place a key
+// and reach the nodes of its shard. The version grows with every change.
+type Topology struct {
+	// Incarnation identifies the controller process that built the topology.
+	// Versions start over when the controller restarts, they only compare
+	// within one incarnation.
+	Incarnation string          `json:"incarnation"`
+	Version     int64           `json:"version"`
+	Partitions  partition.Map   `json:"partitions"`
+	Shards      []ShardTopology `json:"shards"`
+	Keyspaces   []Keyspace      `json:"keyspaces"`
+	// SettingsVersion tells routers when to fetch the runtime settings again.
+	SettingsVersion int64 `json:"settings_version"`
+}
+
+// ShardTopology lists the members of one shard.
+type ShardTopology struct {
+	ShardKey  int                `json:"shard_key"`
+	Epoch     int64              `json:"epoch"`
+	Replicas  int                `json:"replicas"`
+	Master    *cluster.NodeInfo  `json:"master"`
+	Followers []cluster.NodeInfo `json:"followers"`
+}
+
+// EventType names what happened in a cluster event.
+type EventType string
+
+const (
+	EventNodeRegistered     EventType = "node_registered"
+	EventNodeSuspected      EventType = "node_suspected"
+	EventNodeFailed         EventType = "node_failed"
+	EventNodeRecovered      EventType = "node_recovered"
+	EventNodeActive         EventType = "node_active"
+	EventNodeAdded          EventType = "node_added"
+	EventNodeDecommissioned EventType = "node_decommissioned"
+	EventMaintenanceStarted EventType = "maintenance_started"
+	EventMaintenanceEnded   EventType = "maintenance_ended"
+	EventLeaderChanged      EventType = "leader_changed"
+	EventReplicasChanged    EventType = "replicas_changed"
+	EventShardSplit         EventType = "shard_split"
+	EventShardsMerged       EventType = "shards_merged"
+	EventKeyspaceCreated    EventType = "keyspace_created"
+	EventKeyspaceDropped    EventType = "keyspace_dropped"
+)
+
+// Event is an entry of the controller's cluster event journal. NodeID and
+// ShardKey are -1 when the event is not about a single node or shard.
+type Event struct {
+	ID       int64             `json:"id"`
+	Time     time.Time         `json:"time"`
+	Type     EventType         `json:"type"`
+	NodeID   int               `json:"node_id"`
+	ShardKey int               `json:"shard_key"`
+	Actor    string            `json:"actor"`
+	Reason   string            `json:"reason,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// EventFilter selects events from the journal. Zero values match everything,
+// except NodeID and ShardKey which match everything when negative.
+type EventFilter struct {
+	Types    []EventType
+	NodeID   int
+	ShardKey int
+	// AfterID only matches events newer than the given event ID, clients resume a stream with it
+	AfterID int64
+	Since   time.Time
+	Limit   int
+}
+
+// HealthStatus is the verdict of a health report. Green means every shard has
+// a master and all its replicas in sync, yellow that shards are serving with
+// fewer in-sync replicas than configured, red that a shard cannot serve.
+type HealthStatus string
+
+const (
+	HealthGreen  HealthStatus = "green"
+	HealthYellow HealthStatus = "yellow"
+	HealthRed    HealthStatus = "red"
+)
+
+// ShardHealth is the health of one shard. MasterID is the master slot, which
+// may be failed, and -1 when the shard has none. HasMaster is true only for a serving master.
+type ShardHealth struct {
+	ShardKey  int          `json:"shard_key"`
+	Status    HealthStatus `json:"status"`
+	Epoch     int64        `json:"epoch"`
+	HasMaster bool         `json:"has_master"`
+	MasterID  int          `json:"master_id"`
+	InSync    int          `json:"in_sync"`
+	Replicas  int          `json:"replicas"`
+	MaxLag    int64        `json:"max_lag"`
+	Issues    []string     `json:"issues,omitempty"`
+}
+
+// NodeHealth is the health of one node slot. LastHeartbeat is nil for nodes
+// the controller has not heard from since it started.
+type NodeHealth struct {
+	ID            int                   `json:"id"`
+	ShardKey      int                   `json:"shard_key"`
+	Status        cluster.NodeStatus    `json:"status"`
+	Role          cluster.StoreNodeType `json:"role"`
+	LastSeq       int64                 `json:"last_seq"`
+	Lag           int64                 `json:"lag"`
+	LastHeartbeat *time.Time            `json:"last_heartbeat"`
+	Phi           float64               `json:"phi"`
+	// OpenBreakers are the load balancers whose circuit breaker to the node is
+	// not closed
+	OpenBreakers []string `json:"open_breakers,omitempty"`
+}
+
+// ClusterHealth is the controller's aggregated health report.
+type ClusterHealth struct {
+	Status   HealthStatus  `json:"status"`
+	Time     time.Time     `json:"time"`
+	Shards   []ShardHealth `json:"shards"`
+	Nodes    []NodeHealth  `json:"nodes"`
+	Warnings []string      `json:"warnings"`
+}
+
+// ReplicaChange reports how the controller brought a shard to a new replication
+// factor. Added are spare nodes that now bootstrap from the master, Slots are
+// new empty slots for nodes to register into and Removed the retired followers.
+type ReplicaChange struct {
+	ShardKey int   `json:"shard_key"`
+	From     int   `json:"from"`
+	To       int   `json:"to"`
+	Added    []int `json:"added"`
+	Slots    []int `json:"slots"`
+	Removed  []int `json:"removed"`
+}
+
+// ShardLoad is the request rate of a shard summed over its members. Hot shards
+// carry more than the configured factor of the cluster average and come with
+// a recommendation.
+type ShardLoad struct {
+	ShardKey       int       `json:"shard_key"`
+	MasterID       int       `json:"master_id"`
+	OpsPerSec      float64   `json:"ops_per_sec"`
+	Keys           int       `json:"keys"`
+	Hot            bool      `json:"hot"`
+	TopKeys        []KeyLoad `json:"top_keys"`
+	Recommendation string    `json:"recommendation,omitempty"`
+	// SplitKey is where splitting would halve the sampled load, range partitioning only.
+	SplitKey string `json:"split_key,omitempty"`
+}
+
+// HotKey is a frequently requested key and the shard it lives in.
+type HotKey struct {
+	KeyLoad
+	ShardKey int `json:"shard_key"`
+}
+
+// HotspotReport lists the busiest shards and keys of the cluster.
+type HotspotReport struct {
+	AverageOpsPerSec float64     `json:"average_ops_per_sec"`
+	LoadFactor       float64     `json:"load_factor"`
+	Shards           []ShardLoad `json:"shards"`
+	Keys             []HotKey    `json:"keys"`
+}
+
+// KeyspaceQuota limits one keyspace across the whole cluster, zero fields are unlimited.
+type KeyspaceQuota struct {
+	MaxKeys      int     `json:"max_keys"`
+	MaxBytes     int64   `json:"max_bytes"`
+	MaxOpsPerSec float64 `json:"max_ops_per_sec"`
+}
+
+// KeyspaceUsage is the number of keys of a keyspace and the bytes taken by
+// their keys and values.
+type KeyspaceUsage struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Keyspace is a namespace whose keys are isolated from those of other
+// keyspaces. Usage is only filled in by the admin API.
+type Keyspace struct {
+	Name    string         `json:"name"`
+	Quota   KeyspaceQuota  `json:"quota"`
+	Created time.Time      `json:"created"`
+	Usage   *KeyspaceUsage `json:"usage,omitempty"`
+}
+
+// KeyspaceCatalog is the versioned list of keyspaces the controller hands to nodes.
+type KeyspaceCatalog struct {
+	Version   int64      `json:"version"`
+	Keyspaces []Keyspace `json:"keyspaces"`
+}
+
+// RuntimeSettings are the settings components apply without a restart. Nil
+// fields leave the value from the component's own config file in place.
+type RuntimeSettings struct {
+	LogLevel               *string  `json:"log_level,omitempty"`
+	HTTPTimeoutMs          *int     `json:"http_timeout_ms,omitempty"`
+	HeartbeatIntervalMs    *int     `json:"heartbeat_interval_ms,omitempty"`
+	HotKeySampleRate       *float64 `json:"hot_key_sample_rate,omitempty"`
+	TopologyPollIntervalMs *int     `json:"topology_poll_interval_ms,omitempty"`
+}
+
+// Merge returns s with the fields set in override replaced.
+func (s RuntimeSettings) Merge(override RuntimeSettings) RuntimeSettings {
+	if override.LogLevel != nil {
+		s.LogLevel = override.LogLevel
+	}
+	if override.HTTPTimeoutMs != nil {
+		s.HTTPTimeoutMs = override.HTTPTimeoutMs
+	}
+	if override.HeartbeatIntervalMs != nil {
+		s.HeartbeatIntervalMs = override.HeartbeatIntervalMs
+	}
+	if override.HotKeySampleRate != nil {
+		s.HotKeySampleRate = override.HotKeySampleRate
+	}
+	if override.TopologyPollIntervalMs != nil {
+		s.TopologyPollIntervalMs = override.TopologyPollIntervalMs
+	}
+	return s
+}
+
+// SettingsDocument is the versioned runtime settings stored on the controller:
+// cluster-wide settings and per-node overrides by node ID.
+type SettingsDocument struct {
+	Version       int64                   `json:"version"`
+	Updated       time.Time               `json:"updated"`
+	Settings      RuntimeSettings         `json:"settings"`
+	NodeOverrides map[int]RuntimeSettings `json:"node_overrides"`
+}
+
+// AppliedSettings are the settings in effect for one component, overrides included.
+type AppliedSettings struct {
+	Version  int64           `json:"version"`
+	Settings RuntimeSettings `json:"settings"`
+}
+
+// SettingsAck is sent by a load balancer once it applied a settings version.
+type SettingsAck struct {
+	ID      string `json:"id"`
+	Version int64  `json:"version"`
+}
+
+// ComponentSettings is the settings version a component last reported running.
+type ComponentSettings struct {
+	Component string    `json:"component"`
+	ID        string    `json:"id"`
+	Version   int64     `json:"version"`
+	Current   bool      `json:"current"`
+	Reported  time.Time `json:"reported"`
+}
+
+// SettingsStatus lists the settings version every component runs.
+type SettingsStatus struct {
+	Version    int64               `json:"version"`
+	Components []ComponentSettings `json:"components"`
+}
+
+// NodePoolStats are the load balancer's connection pool stats for one node.
+// AvgLatencyMs is a moving average weighted towards recent requests.
+type NodePoolStats struct {
+	NodeID       int          `json:"node_id"`
+	Address      string       `json:"address"`
+	MaxConns     int          `json:"max_conns"`
+	InFlight     int64        `json:"in_flight"`
+	Requests     int64        `json:"requests"`
+	Failures     int64        `json:"failures"`
+	AvgLatencyMs float64      `json:"avg_latency_ms"`
+	MaxLatencyMs float64      `json:"max_latency_ms"`
+	Breaker      BreakerState `json:"breaker"`
+}
+
+// BreakerState is the state of a load balancer's circuit breaker to a node.
+// Open breakers short-circuit requests, half-open ones let a few probes through.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// NodeBreaker is the circuit breaker of a load balancer to one node, ErrorRate
+// and LatencyMs cover the requests in its window.
+type NodeBreaker struct {
+	NodeID    int          `json:"node_id"`
+	Address   string       `json:"address"`
+	State     BreakerState `json:"state"`
+	Since     time.Time    `json:"since"`
+	ErrorRate float64      `json:"error_rate"`
+	LatencyMs float64      `json:"latency_ms"`
+}
+
+// BreakerReport is sent by a load balancer to report its circuit breakers.
+type BreakerReport struct {
+	LoadBalancer string        `json:"load_balancer"`
+	Breakers     []NodeBreaker `json:"breakers"`
+}
+
+// HedgingStats are the load balancer's hedged read counters. Budget is the
+// number of hedges it may send right now.
+type HedgingStats struct {
+	Enabled   bool    `json:"enabled"`
+	Reads     int64   `json:"reads"`
+	Hedged    int64   `json:"hedged"`
+	HedgesWon int64   `json:"hedges_won"`
+	DelayMs   float64 `json:"delay_ms"`
+	Budget    float64 `json:"budget"`
+}
+
+// AdmissionStats are the load balancer's admission control counters. RateLimited
+// counts requests rejected by a client's rate limit, Shed those rejected because
+// MaxInFlight requests were already being served. A zero MaxInFlight is unlimited.
+type AdmissionStats struct {
+	KeyBy       string `json:"key_by"`
+	Clients     int    `json:"clients"`
+	InFlight    int    `json:"in_flight"`
+	MaxInFlight int    `json:"max_in_flight"`
+	RateLimited int64  `json:"rate_limited"`
+	Shed        int64  `json:"shed"`
+}
+
+// CacheStats are the load balancer's read cache counters. Evictions are
+// entries dropped for room, Invalidations those dropped because the key was written.
+type CacheStats struct {
+	Enabled       bool    `json:"enabled"`
+	Entries       int     `json:"entries"`
+	MaxEntries    int     `json:"max_entries"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRatio      float64 `json:"hit_ratio"`
+	Evictions     int64   `json:"evictions"`
+	Invalidations int64   `json:"invalidations"`
+}
+
+// CoalescingStats are the load balancer's read coalescing counters. Fetches are
+// the requests sent to the nodes for Reads, Collapsed the reads that shared
+// the request of an identical read instead.
+type CoalescingStats struct {
+	Enabled        bool    `json:"enabled"`
+	Reads          int64   `json:"reads"`
+	Fetches        int64   `json:"fetches"`
+	Collapsed      int64   `json:"collapsed"`
+	CollapsedRatio float64 `json:"collapsed_ratio"`
+	InFlight       int     `json:"in_flight"`
+}
+
+// Headers of the redirect protocol between clients and nodes.
+const (
+	// AskingHeader marks a request that follows an ASK redirect, the node
+	// serves it although its topology does not assign it the key yet.
+	AskingHeader = "X-Kv-Asking"
+	// TopologyVersionHeader carries the topology version a client routed the
+	// request by. Nodes knowing an older topology serve it instead of redirecting.
+	TopologyVersionHeader = "X-Kv-Topology-Version"
+	// TopologyIncarnationHeader carries the controller incarnation of that
+	// version, versions of different incarnations are not compared.
+	TopologyIncarnationHeader = "X-Kv-Topology-Incarnation"
+)
+
+// RedirectKind tells how long a redirect holds, in the style of Redis Cluster.
+type RedirectKind string
+
+const (
+	// RedirectMoved means the key belongs to another shard now, clients
+	// should refresh their routing table.
+	RedirectMoved RedirectKind = "MOVED"
+	// RedirectAsk means the key is being handed over, only this request
+	// goes to the other shard, with the AskingHeader set.
+	RedirectAsk RedirectKind = "ASK"
+)
+
+// Redirect is the shard and address serving a key a node does not own. It is
+// answered with status 421 Misdirected Request. The address is empty when the
+// shard has no master.
+type Redirect struct {
+	Kind            RedirectKind `json:"kind"`
+	ShardKey        int          `json:"shard_key"`
+	NodeID          int          `json:"node_id"`
+	Address         string       `json:"address"`
+	TopologyVersion int64        `json:"topology_version"`
+}
+
+// RoutingTable is what a smart client needs to send requests straight to the
+// nodes. It is tagged with its version, clients revalidate it with If-None-Match.
+type RoutingTable struct {
+	Incarnation string        `json:"incarnation"`
+	Version     int64         `json:"version"`
+	Partitions  partition.Map `json:"partitions"`
+	Shards      []ShardRoute  `json:"shards"`
+}
+
+// ShardRoute is where to send the writes and reads of one shard. Replicas are
+// the followers that serve reads.
+type ShardRoute struct {
+	ShardKey int         `json:"shard_key"`
+	Epoch    int64       `json:"epoch"`
+	Master   *RouteNode  `json:"master"`
+	Replicas []RouteNode `json:"replicas"`
+}
+
+// RouteNode is a node of a routing table.
+type RouteNode struct {
+	ID      int    `json:"id"`
+	Address string `json:"address"`
+}
+
+// LoadBalancerState is where a load balancer is in its life.
+type LoadBalancerState string
+
+const (
+	// LoadBalancerStarting has not synced the topology yet and reports not ready.
+	LoadBalancerStarting LoadBalancerState = "starting"
+	LoadBalancerReady    LoadBalancerState = "ready"
+	// LoadBalancerDraining reports not ready and finishes the requests in flight.
+	LoadBalancerDraining LoadBalancerState = "draining"
+)
+
+// LoadBalancerHeartbeat is pushed periodically by every load balancer, it
+// registers the load balancer with the controller.
+type LoadBalancerHeartbeat struct {
+	ID              string            `json:"id"`
+	Address         string            `json:"address"`
+	State           LoadBalancerState `json:"state"`
+	TopologyVersion int64             `json:"topology_version"`
+	SettingsVersion int64             `json:"settings_version"`
+	Started         time.Time         `json:"started"`
+}
+
+// LoadBalancerDeregistration takes a load balancer that shut down out of the fleet.
+type LoadBalancerDeregistration struct {
+	ID string `json:"id"`
+}
+
+// LoadBalancerInfo is a load balancer of the fleet as the controller last
+// heard of it. Stale ones missed their recent heartbeats.
+type LoadBalancerInfo struct {
+	LoadBalancerHeartbeat
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Stale         bool      `json:"stale"`
+}
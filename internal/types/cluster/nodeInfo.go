@@ -1,14 +1,23 @@
 package cluster
 
-import "net"
+import (
+	"math/bits"
+	"net"
+	"strconv"
+)
 
 type NodeInfo struct {
-	ID            int
-	ShardKey      int
-	Status        NodeStatus
-	Address       net.TCPAddr
-	LeaderID      int
-	StoreNodeType StoreNodeType
+	ID            int           `json:"id"`
+	ShardKey      int           `json:"shard_key"`
+	Status        NodeStatus    `json:"status"`
+	Address       net.TCPAddr   `json:"address"`
+	LeaderID      int           `json:"leader_id"`
+	StoreNodeType StoreNodeType `json:"node_type"`
+	Topology      Topology      `json:"topology"`
+	// LastSeq is the last WAL sequence the node reported in a heartbeat and
+	// Lag how far that is behind its master, a SYNCING node becomes ACTIVE at zero lag.
+	LastSeq int64 `json:"last_seq"`
+	Lag     int64 `json:"lag"`
 }
 
 func (n *NodeInfo) GetID() int {
@@ -19,6 +28,24 @@ func (n *NodeInfo) GetAddress() (string, int) {
 	return n.Address.IP.String(), n.Address.Port
 }
 
+// HostPort returns the address of the node as host:port.
+func (n *NodeInfo) HostPort() string {
+	return net.JoinHostPort(n.Address.IP.String(), strconv.Itoa(n.Address.Port))
+}
+
+// LagBucket returns the power of two bucket a replication lag falls in, 0 for
+// no lag. The controller publishes the lag of a follower whenever it moves to
+// another bucket, not on every change.
+func LagBucket(lag int64) int {
+	return bits.Len64(uint64(max(lag, 0)))
+}
+
+// LagCeiling returns the highest lag in the bucket of lag, which the actual
+// lag of a follower stays below until a new topology is published.
+func LagCeiling(lag int64) int64 {
+	return int64(1)<<LagBucket(lag) - 1
+}
+
 func (n *NodeInfo) GetStatus() NodeStatus {
 	return n.Status
 }
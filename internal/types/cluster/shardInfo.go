@@ -4,6 +4,18 @@ type ShardInfo struct {
 	ShardKey  int
 	Master    *NodeInfo
 	Followers []*NodeInfo
+	// StartKey and EndKey bound the keys owned by the shard in range
+	// partitioning mode, an empty EndKey is unbounded.
+	StartKey string
+	EndKey   string
+	// Replicas is the number of copies of the shard, the master included, the
+	// controller maintains.
+	Replicas int
+	// Epoch is incremented every time the shard gets a new master.
+	Epoch int64
+	// ForkSeq is the WAL sequence of the master when the current epoch began,
+	// records past it on other members were never acknowledged by this master.
+	ForkSeq int64
 }
 
 func (s *ShardInfo) GetMaster() *NodeInfo {
@@ -17,3 +29,12 @@ func (s *ShardInfo) GetFollowers() []*NodeInfo {
 	}
 	return followers
 }
+
+// Members returns the master followed by all followers of the shard.
+func (s *ShardInfo) Members() []*NodeInfo {
+	members := make([]*NodeInfo, 0, len(s.Followers)+1)
+	if s.Master != nil {
+		members = append(members, s.Master)
+	}
+	return append(members, s.Followers...)
+}
@@ -0,0 +1,60 @@
+package cluster
+
+// Topology locates a node in the failure domain hierarchy zone > rack > host.
+// Empty labels are unknown and never considered shared.
+type Topology struct {
+	Zone string `json:"zone,omitempty"`
+	Rack string `json:"rack,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+// FailureDomain is the narrowest domain two nodes have in common.
+type FailureDomain int
+
+const (
+	DomainNone FailureDomain = iota
+	DomainZone
+	DomainRack
+	DomainHost
+)
+
+func (d FailureDomain) String() string {
+	switch d {
+	case DomainZone:
+		return "zone"
+	case DomainRack:
+		return "rack"
+	case DomainHost:
+		return "host"
+	default:
+		return "none"
+	}
+}
+
+// SharedDomain returns the narrowest failure domain t and o have in common.
+func (t Topology) SharedDomain(o Topology) FailureDomain {
+	switch {
+	case t.Host != "" && t.Host == o.Host:
+		return DomainHost
+	case t.Rack != "" && t.Zone == o.Zone && t.Rack == o.Rack:
+		return DomainRack
+	case t.Zone != "" && t.Zone == o.Zone:
+		return DomainZone
+	default:
+		return DomainNone
+	}
+}
+
+// Label names the failure domain d of the topology, e.g. "rack zone-a/rack-1".
+func (t Topology) Label(d FailureDomain) string {
+	switch d {
+	case DomainHost:
+		return "host " + t.Host
+	case DomainRack:
+		return "rack " + t.Zone + "/" + t.Rack
+	case DomainZone:
+		return "zone " + t.Zone
+	default:
+		return ""
+	}
+}
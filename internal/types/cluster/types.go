@@ -8,6 +8,15 @@ const (
 	NodeStatusFailed       NodeStatus = "FAILED"
 	NodeStatusUnregistered NodeStatus = "UNREGISTERED"
 	NodeStatusSyncing      NodeStatus = "SYNCING"
+	// NodeStatusSuspect is set when heartbeats are late but the node is not yet considered failed.
+	NodeStatusSuspect NodeStatus = "SUSPECT"
+	// NodeStatusMaintenance is set while an operator takes a node down on purpose. Failure
+	// handling is suppressed and the node has to catch up again before it serves.
+	NodeStatusMaintenance NodeStatus = "MAINTENANCE"
+	// NodeStatusDecommissioning is set while leadership and data are moved off a node that is being removed.
+	NodeStatusDecommissioning NodeStatus = "DECOMMISSIONING"
+	// NodeStatusDecommissioned marks a released slot; it is never handed out to registering nodes again.
+	NodeStatusDecommissioned NodeStatus = "DECOMMISSIONED"
 )
 
 type StoreNodeType string
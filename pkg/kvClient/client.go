@@ -13,6 +13,12 @@ import (
 type Client struct {
 	BaseURL string
 	HTTP    *http.Client
+	// Keyspace is sent with every request, empty is the default keyspace
+	Keyspace string
+	// Consistency is sent with every read, empty is the load balancer's default
+	Consistency api.ReadConsistency
+	// NoCache makes reads skip the load balancer's cache
+	NoCache bool
 }
 
 // NewClient creates a new KV database client
@@ -37,7 +43,7 @@ func (c *Client) Connect() (string, error) {
 
 // Set a new key
 func (c *Client) Set(key, value string) error {
-	req := api.SetRequest{Key: key, Value: value}
+	req := api.SetRequest{Keyspace: c.Keyspace, Key: key, Value: value}
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %v", err)
@@ -59,7 +65,7 @@ func (c *Client) Set(key, value string) error {
 
 // Get the value of a key
 func (c *Client) Get(key string) (string, error) {
-	req := api.GetRequest{Key: key}
+	req := api.GetRequest{Keyspace: c.Keyspace, Key: key, Consistency: c.Consistency, NoCache: c.NoCache}
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request: %v", err)
@@ -86,7 +92,7 @@ func (c *Client) Get(key string) (string, error) {
 
 // Del delete a key
 func (c *Client) Del(key string) error {
-	req := api.DelRequest{Key: key}
+	req := api.DelRequest{Keyspace: c.Keyspace, Key: key}
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %v", err)
@@ -1,12 +1,18 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
 	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
 	"github.com/Amirali-Amirifar/kv/pkg/kvController/interfaces"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -22,22 +28,153 @@ func NewRouteHandler(controller interfaces.KvControllerInterface) *KvRouteHandle
 	}
 }
 
-// HealthHandler Returns status of all nodes.
+// HealthHandler returns the aggregated health of every shard and node with an
+// overall green, yellow or red verdict. It answers 200 whatever the verdict,
+// supervisors should use the liveness and readiness endpoints.
 func (k *KvRouteHandler) HealthHandler(ctx *gin.Context) {
-	//TODO implement me
-	panic("implement me")
+	ctx.JSON(http.StatusOK, k.controller.GetHealth())
 }
 
-// AddNodeHandler Adds a node to a designated partition
+// LivenessHandler answers as long as the controller process is serving requests
+func (k *KvRouteHandler) LivenessHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// ReadinessHandler answers 200 once every shard has a serving master and 503 otherwise.
+// Nodes register through /internal regardless, so it must not gate their traffic.
+func (k *KvRouteHandler) ReadinessHandler(ctx *gin.Context) {
+	ready, reason := k.controller.Ready()
+	if !ready {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "reason": reason})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// AddNodeHandler Adds a node slot to a designated partition, a node claims it by registering.
 func (k *KvRouteHandler) AddNodeHandler(ctx *gin.Context) {
-	//TODO implement me
-	panic("implement me")
+	var req AddNodeRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+
+	if req.Spare && req.ShardKey != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "a spare node cannot belong to a shard"})
+		return
+	}
+
+	shardKey := -1
+	if req.ShardKey != nil {
+		if *req.ShardKey < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid shard key"})
+			return
+		}
+		shardKey = *req.ShardKey
+	}
+
+	var node *cluster.NodeInfo
+	var err error
+	if req.Spare {
+		node, err = k.controller.AddSpareNode()
+	} else {
+		node, err = k.controller.AddNode(shardKey)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, AddNodeResponse{
+		ID:            node.ID,
+		ShardKey:      node.ShardKey,
+		Status:        node.Status,
+		StoreNodeType: node.StoreNodeType,
+		LeaderID:      node.LeaderID,
+	})
 }
 
-// RemoveNodeHandler Removes a node from a designated partition
+// RemoveNodeHandler Gracefully decommissions a node and releases its slot
 func (k *KvRouteHandler) RemoveNodeHandler(ctx *gin.Context) {
-	//TODO implement me
-	panic("implement me")
+	nodeID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	if err := k.controller.DecommissionNode(nodeID); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case strings.Contains(err.Error(), "invalid node ID"):
+			status = http.StatusNotFound
+		case strings.Contains(err.Error(), "already") || strings.Contains(err.Error(), "below") || strings.Contains(err.Error(), "still the master"):
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, RemoveNodeResponse{
+		Message: "node decommissioned successfully",
+		NodeID:  nodeID,
+	})
+}
+
+// EnterMaintenanceHandler puts a node into maintenance, moving leadership off it first
+func (k *KvRouteHandler) EnterMaintenanceHandler(ctx *gin.Context) {
+	nodeID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	if err := k.controller.EnterMaintenance(nodeID); err != nil {
+		k.maintenanceError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "node is in maintenance",
+		"node_id": nodeID,
+		"status":  cluster.NodeStatusMaintenance,
+	})
+}
+
+// ExitMaintenanceHandler takes a node out of maintenance, it serves again once caught up
+func (k *KvRouteHandler) ExitMaintenanceHandler(ctx *gin.Context) {
+	nodeID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	if err := k.controller.ExitMaintenance(nodeID); err != nil {
+		k.maintenanceError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "node left maintenance and is catching up",
+		"node_id": nodeID,
+		"status":  cluster.NodeStatusSyncing,
+	})
+}
+
+func (k *KvRouteHandler) maintenanceError(ctx *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case strings.Contains(err.Error(), "invalid node ID"):
+		status = http.StatusNotFound
+	case strings.Contains(err.Error(), "maintenance") || strings.Contains(err.Error(), "can take over"):
+		status = http.StatusConflict
+	}
+	ctx.JSON(status, gin.H{"error": err.Error()})
 }
 
 // IncreasePartitionsHandler Adds a new partition
@@ -88,6 +225,44 @@ func (k *KvRouteHandler) MovePartitionHandler(ctx *gin.Context) {
 	panic("implement me")
 }
 
+// SetPartitionReplicasHandler changes the replication factor of a single shard
+func (k *KvRouteHandler) SetPartitionReplicasHandler(ctx *gin.Context) {
+	shardID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || shardID < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid shard ID"})
+		return
+	}
+	k.setReplicas(ctx, shardID)
+}
+
+// SetReplicasHandler changes the replication factor of every shard and of shards created later
+func (k *KvRouteHandler) SetReplicasHandler(ctx *gin.Context) {
+	k.setReplicas(ctx, -1)
+}
+
+func (k *KvRouteHandler) setReplicas(ctx *gin.Context, shardID int) {
+	var req SetReplicasRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	changes, err := k.controller.SetReplicas(shardID, req.Replicas)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case strings.Contains(err.Error(), "not found"):
+			status = http.StatusNotFound
+		case strings.Contains(err.Error(), "at least"):
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{"error": err.Error(), "shards": changes})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"shards": changes})
+}
+
 func (k *KvRouteHandler) NodeRegisterHandler(ctx *gin.Context) {
 	req := &NodeRegisterHandlerRequest{}
 	if err := ctx.ShouldBindJSON(req); err != nil {
@@ -96,7 +271,7 @@ func (k *KvRouteHandler) NodeRegisterHandler(ctx *gin.Context) {
 		return
 	}
 
-	nodeInfo, err := k.controller.RegisterNode(req.Ip, req.Port)
+	nodeInfo, err := k.controller.RegisterNode(req.Ip, req.Port, req.Topology)
 	if err != nil {
 		logrus.WithError(err).Errorf("Failed to register node %s:%d", req.Ip, req.Port)
 		ctx.Status(http.StatusConflict)
@@ -110,6 +285,9 @@ func (k *KvRouteHandler) NodeRegisterHandler(ctx *gin.Context) {
 		StoreNodeType: nodeInfo.StoreNodeType,
 		LeaderID:      nodeInfo.LeaderID,
 	}
+	if shardInfo, ok := k.controller.GetNodeManager().GetShardInfo(nodeInfo.ShardKey); ok {
+		response.Epoch = shardInfo.Epoch
+	}
 
 	// If this is a follower, get the master's address
 	if nodeInfo.StoreNodeType == cluster.NodeTypeFollower {
@@ -126,6 +304,27 @@ func (k *KvRouteHandler) NodeRegisterHandler(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
+// NodeHeartbeatHandler records a heartbeat pushed by a node
+func (k *KvRouteHandler) NodeHeartbeatHandler(ctx *gin.Context) {
+	var req apiTypes.HeartbeatRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := k.controller.Heartbeat(req)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid node ID") {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
 // GetNodeInfoHandler returns information about a specific node
 func (k *KvRouteHandler) GetNodeInfoHandler(ctx *gin.Context) {
 	nodeID, err := strconv.Atoi(ctx.Param("id"))
@@ -152,6 +351,7 @@ func (k *KvRouteHandler) GetNodeInfoHandler(ctx *gin.Context) {
 func (k *KvRouteHandler) GetClusterHandler(ctx *gin.Context) {
 	nodes := k.controller.GetClusterDetails()
 	shardMap := make(map[int][]gin.H)
+	spares := make([]gin.H, 0)
 	for _, node := range nodes {
 		nodeInfo := gin.H{
 			"id":        node.ID,
@@ -159,14 +359,384 @@ func (k *KvRouteHandler) GetClusterHandler(ctx *gin.Context) {
 			"status":    node.Status,
 			"node_type": node.StoreNodeType,
 			"leader_id": node.LeaderID,
+			"last_seq":  node.LastSeq,
+			"lag":       node.Lag,
+			"topology":  node.Topology,
 			"address": gin.H{
 				"ip":   node.Address.IP.String(),
 				"port": node.Address.Port,
 			},
 		}
+		if shardInfo, ok := k.controller.GetNodeManager().GetShardInfo(node.ShardKey); ok {
+			nodeInfo["epoch"] = shardInfo.Epoch
+		}
+		if node.ShardKey < 0 {
+			spares = append(spares, nodeInfo)
+			continue
+		}
 		shardMap[node.ShardKey] = append(shardMap[node.ShardKey], nodeInfo)
 	}
 	ctx.JSON(http.StatusOK, gin.H{
-		"shards": shardMap,
+		"shards":   shardMap,
+		"spares":   spares,
+		"warnings": k.controller.GetPlacementWarnings(),
+	})
+}
+
+// GetPartitionMapHandler returns the versioned partition map routers use to place keys
+func (k *KvRouteHandler) GetPartitionMapHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, k.controller.GetPartitionMap())
+}
+
+// GetHotspotsHandler reports the request rate of every shard, flags the hot
+// ones and lists the most requested keys, limit of them (default 10).
+func (k *KvRouteHandler) GetHotspotsHandler(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	ctx.JSON(http.StatusOK, k.controller.GetHotspots(limit))
+}
+
+// GetBalancerHandler reports the leader balancer's state and recent moves
+func (k *KvRouteHandler) GetBalancerHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, k.controller.GetBalancerStatus())
+}
+
+// UpdateBalancerHandler pauses or resumes the leader balancer, or toggles its dry-run mode
+func (k *KvRouteHandler) UpdateBalancerHandler(ctx *gin.Context) {
+	var req apiTypes.BalancerSettings
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, k.controller.UpdateBalancer(req))
+}
+
+// GetTopologyHandler returns the current versioned topology
+func (k *KvRouteHandler) GetTopologyHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, k.controller.GetTopology())
+}
+
+// WatchTopologyHandler long-polls for a topology newer than the version query
+// parameter. It answers 304 Not Modified when nothing changed within timeout_ms,
+// a watcher whose incarnation parameter names an earlier controller gets the
+// current topology right away.
+func (k *KvRouteHandler) WatchTopologyHandler(ctx *gin.Context) {
+	version, err := strconv.ParseInt(ctx.DefaultQuery("version", "0"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	timeoutMs, err := strconv.Atoi(ctx.DefaultQuery("timeout_ms", "30000"))
+	if err != nil || timeoutMs <= 0 || timeoutMs > 60000 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "timeout_ms must be between 1 and 60000"})
+		return
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx.Request.Context(), time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+
+	topology, changed := k.controller.WatchTopology(watchCtx, ctx.Query("incarnation"), version)
+	if !changed {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.JSON(http.StatusOK, topology)
+}
+
+// parseEventFilter reads the event filter from the query string: type (comma
+// separated), node, shard, after_id, since (RFC 3339) and limit.
+func parseEventFilter(ctx *gin.Context) (apiTypes.EventFilter, error) {
+	filter := apiTypes.EventFilter{NodeID: -1, ShardKey: -1}
+	if types := ctx.Query("type"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			filter.Types = append(filter.Types, apiTypes.EventType(strings.TrimSpace(t)))
+		}
+	}
+
+	var err error
+	if node := ctx.Query("node"); node != "" {
+		if filter.NodeID, err = strconv.Atoi(node); err != nil || filter.NodeID < 0 {
+			return filter, fmt.Errorf("invalid node")
+		}
+	}
+	if shard := ctx.Query("shard"); shard != "" {
+		if filter.ShardKey, err = strconv.Atoi(shard); err != nil || filter.ShardKey < 0 {
+			return filter, fmt.Errorf("invalid shard")
+		}
+	}
+	if afterID := ctx.Query("after_id"); afterID != "" {
+		if filter.AfterID, err = strconv.ParseInt(afterID, 10, 64); err != nil {
+			return filter, fmt.Errorf("invalid after_id")
+		}
+	}
+	if since := ctx.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("invalid since, expected RFC 3339")
+		}
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit")
+		}
+	}
+	return filter, nil
+}
+
+// GetEventsHandler returns the retained cluster events that match the query filter, oldest first
+func (k *KvRouteHandler) GetEventsHandler(ctx *gin.Context) {
+	filter, err := parseEventFilter(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"events": k.controller.GetEvents(filter)})
+}
+
+// StreamEventsHandler streams the matching retained events followed by new
+// ones as server-sent events. The SSE id is the event ID, a reconnecting
+// client resumes with the Last-Event-ID header or after_id.
+func (k *KvRouteHandler) StreamEventsHandler(ctx *gin.Context) {
+	filter, err := parseEventFilter(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if lastID := ctx.GetHeader("Last-Event-ID"); lastID != "" {
+		if filter.AfterID, err = strconv.ParseInt(lastID, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"})
+			return
+		}
+	}
+
+	backlog, events, cancel := k.controller.SubscribeEvents(filter)
+	defer cancel()
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	for _, event := range backlog {
+		ctx.Render(-1, sse.Event{Id: strconv.FormatInt(event.ID, 10), Event: "event", Data: event})
+	}
+	ctx.Writer.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for falling behind, the client reconnects with Last-Event-ID
+				return false
+			}
+			ctx.Render(-1, sse.Event{Id: strconv.FormatInt(event.ID, 10), Event: "event", Data: event})
+			return true
+		case <-keepAlive.C:
+			_, _ = io.WriteString(w, ": keep-alive\n\n")
+			return true
+		}
 	})
 }
+
+// CreateKeyspaceHandler creates a keyspace with its quota
+func (k *KvRouteHandler) CreateKeyspaceHandler(ctx *gin.Context) {
+	var req CreateKeyspaceRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	keyspace, err := k.controller.CreateKeyspace(req.Name, req.Quota)
+	if err != nil {
+		k.keyspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, keyspace)
+}
+
+// ListKeyspacesHandler lists the keyspaces with their quotas and usage
+func (k *KvRouteHandler) ListKeyspacesHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"keyspaces": k.controller.GetKeyspaces()})
+}
+
+// GetKeyspaceHandler returns one keyspace with its quota and usage
+func (k *KvRouteHandler) GetKeyspaceHandler(ctx *gin.Context) {
+	keyspace, err := k.controller.GetKeyspace(ctx.Param("name"))
+	if err != nil {
+		k.keyspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, keyspace)
+}
+
+// DropKeyspaceHandler drops a keyspace and every key stored in it
+func (k *KvRouteHandler) DropKeyspaceHandler(ctx *gin.Context) {
+	name := ctx.Param("name")
+	if err := k.controller.DropKeyspace(name); err != nil {
+		k.keyspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "keyspace dropped", "keyspace": name})
+}
+
+// GetKeyspaceCatalogHandler returns the versioned keyspace catalog nodes enforce
+func (k *KvRouteHandler) GetKeyspaceCatalogHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, k.controller.GetKeyspaceCatalog())
+}
+
+func (k *KvRouteHandler) keyspaceError(ctx *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case strings.Contains(err.Error(), "not found"):
+		status = http.StatusNotFound
+	case strings.Contains(err.Error(), "already exists"):
+		status = http.StatusConflict
+	case strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "cannot"):
+		status = http.StatusBadRequest
+	}
+	ctx.JSON(status, gin.H{"error": err.Error()})
+}
+
+// GetSettingsHandler returns the runtime settings document with its node overrides
+func (k *KvRouteHandler) GetSettingsHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, k.controller.GetSettings())
+}
+
+// SetSettingsHandler replaces the cluster-wide runtime settings, omitted
+// settings fall back to each component's config file
+func (k *KvRouteHandler) SetSettingsHandler(ctx *gin.Context) {
+	var req apiTypes.RuntimeSettings
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	document, err := k.controller.SetClusterSettings(req)
+	if err != nil {
+		k.settingsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, document)
+}
+
+// SetNodeSettingsHandler replaces the runtime settings overridden on one node
+func (k *KvRouteHandler) SetNodeSettingsHandler(ctx *gin.Context) {
+	nodeID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+	var req apiTypes.RuntimeSettings
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	document, err := k.controller.SetNodeSettings(nodeID, req)
+	if err != nil {
+		k.settingsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, document)
+}
+
+// ClearNodeSettingsHandler removes the overrides of a node, it follows the cluster-wide settings again
+func (k *KvRouteHandler) ClearNodeSettingsHandler(ctx *gin.Context) {
+	nodeID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	document, err := k.controller.ClearNodeSettings(nodeID)
+	if err != nil {
+		k.settingsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, document)
+}
+
+// GetSettingsStatusHandler reports the settings version each node and load balancer runs
+func (k *KvRouteHandler) GetSettingsStatusHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, k.controller.GetSettingsStatus())
+}
+
+// GetAppliedSettingsHandler returns the settings in effect on the node given by
+// the node query parameter, or the cluster-wide settings without it
+func (k *KvRouteHandler) GetAppliedSettingsHandler(ctx *gin.Context) {
+	nodeID, err := strconv.Atoi(ctx.DefaultQuery("node", "-1"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+	ctx.JSON(http.StatusOK, k.controller.GetAppliedSettings(nodeID))
+}
+
+// AckSettingsHandler records the settings version a load balancer applied
+func (k *KvRouteHandler) AckSettingsHandler(ctx *gin.Context) {
+	var req apiTypes.SettingsAck
+	if err := ctx.ShouldBindJSON(&req); err != nil || req.ID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	k.controller.AckSettings(req)
+	ctx.Status(http.StatusOK)
+}
+
+// BreakerReportHandler records the circuit breakers a load balancer reports
+func (k *KvRouteHandler) BreakerReportHandler(ctx *gin.Context) {
+	var req apiTypes.BreakerReport
+	if err := ctx.ShouldBindJSON(&req); err != nil || req.LoadBalancer == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	k.controller.RecordBreakers(req)
+	ctx.Status(http.StatusOK)
+}
+
+// LoadBalancerHeartbeatHandler registers a load balancer or keeps it registered
+func (k *KvRouteHandler) LoadBalancerHeartbeatHandler(ctx *gin.Context) {
+	var req apiTypes.LoadBalancerHeartbeat
+	if err := ctx.ShouldBindJSON(&req); err != nil || req.ID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	k.controller.LoadBalancerHeartbeat(req)
+	ctx.Status(http.StatusOK)
+}
+
+// DeregisterLoadBalancerHandler takes a load balancer that shut down out of the fleet
+func (k *KvRouteHandler) DeregisterLoadBalancerHandler(ctx *gin.Context) {
+	var req apiTypes.LoadBalancerDeregistration
+	if err := ctx.ShouldBindJSON(&req); err != nil || req.ID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	k.controller.DeregisterLoadBalancer(req.ID)
+	ctx.Status(http.StatusOK)
+}
+
+// ListLoadBalancersHandler lists the registered load balancers
+func (k *KvRouteHandler) ListLoadBalancersHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"loadbalancers": k.controller.GetLoadBalancers()})
+}
+
+func (k *KvRouteHandler) settingsError(ctx *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case strings.Contains(err.Error(), "invalid node ID") || strings.Contains(err.Error(), "not found"):
+		status = http.StatusNotFound
+	case strings.Contains(err.Error(), "invalid"):
+		status = http.StatusBadRequest
+	}
+	ctx.JSON(status, gin.H{"error": err.Error()})
+}
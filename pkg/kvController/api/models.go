@@ -1,12 +1,14 @@
 package api
 
 import (
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
 	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
 )
 
 type NodeRegisterHandlerRequest struct {
-	Ip   string `json:"ip"`
-	Port int    `json:"port"`
+	Ip       string           `json:"ip"`
+	Port     int              `json:"port"`
+	Topology cluster.Topology `json:"topology"`
 }
 
 type NodeRegisterHandlerResponse struct {
@@ -15,6 +17,7 @@ type NodeRegisterHandlerResponse struct {
 	Status        cluster.NodeStatus    `json:"status"`
 	StoreNodeType cluster.StoreNodeType `json:"store_node_type"`
 	LeaderID      int                   `json:"leader_id"`
+	Epoch         int64                 `json:"epoch"`
 	LeaderAddress struct {
 		IP   string `json:"ip"`
 		Port int    `json:"port"`
@@ -31,3 +34,34 @@ type ChangeLeaderResponse struct {
 	OldLeader int    `json:"old_leader"`
 	NewLeader int    `json:"new_leader"`
 }
+
+type SetReplicasRequest struct {
+	// Replicas is the number of copies of a shard, the master included.
+	Replicas int `json:"replicas"`
+}
+
+type AddNodeRequest struct {
+	// ShardKey is the shard to provision the slot in, the least populated shard is used when omitted.
+	ShardKey *int `json:"shard_key"`
+	// Spare provisions a slot outside of any shard, used to host shards created by range splits.
+	Spare bool `json:"spare"`
+}
+
+type AddNodeResponse struct {
+	ID            int                   `json:"id"`
+	ShardKey      int                   `json:"shard_key"`
+	Status        cluster.NodeStatus    `json:"status"`
+	StoreNodeType cluster.StoreNodeType `json:"store_node_type"`
+	LeaderID      int                   `json:"leader_id"`
+}
+
+type RemoveNodeResponse struct {
+	Message string `json:"message"`
+	NodeID  int    `json:"node_id"`
+}
+
+// CreateKeyspaceRequest names a new keyspace and its quota, zero limits are unlimited.
+type CreateKeyspaceRequest struct {
+	Name  string                 `json:"name" binding:"required"`
+	Quota apiTypes.KeyspaceQuota `json:"quota"`
+}
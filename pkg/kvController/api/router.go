@@ -12,19 +12,55 @@ import (
 // ControllerRouteHandler interface to decouple route definitions from implementation
 type ControllerRouteHandler interface {
 	HealthHandler(ctx *gin.Context)
+	LivenessHandler(ctx *gin.Context)
+	ReadinessHandler(ctx *gin.Context)
 
 	AddNodeHandler(ctx *gin.Context)
 	RemoveNodeHandler(ctx *gin.Context)
+	EnterMaintenanceHandler(ctx *gin.Context)
+	ExitMaintenanceHandler(ctx *gin.Context)
 
 	IncreasePartitionsHandler(ctx *gin.Context)
 	DecreasePartitionsHandler(ctx *gin.Context)
 
 	ChangePartitionLeaderHandler(ctx *gin.Context)
 	MovePartitionHandler(ctx *gin.Context)
+	SetPartitionReplicasHandler(ctx *gin.Context)
+	SetReplicasHandler(ctx *gin.Context)
 
 	NodeRegisterHandler(ctx *gin.Context)
+	NodeHeartbeatHandler(ctx *gin.Context)
+	GetTopologyHandler(ctx *gin.Context)
+	WatchTopologyHandler(ctx *gin.Context)
 	GetNodeInfoHandler(ctx *gin.Context)
 	GetClusterHandler(ctx *gin.Context)
+	GetPartitionMapHandler(ctx *gin.Context)
+	GetHotspotsHandler(ctx *gin.Context)
+
+	CreateKeyspaceHandler(ctx *gin.Context)
+	ListKeyspacesHandler(ctx *gin.Context)
+	GetKeyspaceHandler(ctx *gin.Context)
+	DropKeyspaceHandler(ctx *gin.Context)
+	GetKeyspaceCatalogHandler(ctx *gin.Context)
+
+	GetSettingsHandler(ctx *gin.Context)
+	SetSettingsHandler(ctx *gin.Context)
+	SetNodeSettingsHandler(ctx *gin.Context)
+	ClearNodeSettingsHandler(ctx *gin.Context)
+	GetSettingsStatusHandler(ctx *gin.Context)
+	GetAppliedSettingsHandler(ctx *gin.Context)
+	AckSettingsHandler(ctx *gin.Context)
+	BreakerReportHandler(ctx *gin.Context)
+
+	LoadBalancerHeartbeatHandler(ctx *gin.Context)
+	DeregisterLoadBalancerHandler(ctx *gin.Context)
+	ListLoadBalancersHandler(ctx *gin.Context)
+
+	GetBalancerHandler(ctx *gin.Context)
+	UpdateBalancerHandler(ctx *gin.Context)
+
+	GetEventsHandler(ctx *gin.Context)
+	StreamEventsHandler(ctx *gin.Context)
 }
 
 // SetupRouter initializes Gin router with routes bound to provided handlers
@@ -44,24 +80,65 @@ func SetupRouter(h ControllerRouteHandler) *gin.Engine {
 		ctx.String(http.StatusOK, "KvController API is running")
 	})
 	router.GET("/health", h.HealthHandler)
+	router.GET("/health/live", h.LivenessHandler)
+	router.GET("/health/ready", h.ReadinessHandler)
 	admin := router.Group("/admin")
 	{
 		// Node management
 		admin.POST("/nodes", h.AddNodeHandler)
 		admin.DELETE("/nodes/:id", h.RemoveNodeHandler)
 		admin.GET("/nodes/:id", h.GetNodeInfoHandler)
+		admin.POST("/nodes/:id/maintenance", h.EnterMaintenanceHandler)
+		admin.DELETE("/nodes/:id/maintenance", h.ExitMaintenanceHandler)
 
 		// Partition management
 		admin.POST("/partitions/increase", h.IncreasePartitionsHandler)
 		admin.POST("/partitions/decrease", h.DecreasePartitionsHandler)
 		admin.POST("/partitions/:id/leader", h.ChangePartitionLeaderHandler)
 		admin.POST("/partitions/:id/move", h.MovePartitionHandler)
+		admin.PUT("/partitions/:id/replicas", h.SetPartitionReplicasHandler)
+		admin.PUT("/replicas", h.SetReplicasHandler)
+		admin.GET("/partitions", h.GetPartitionMapHandler)
 		admin.GET("/cluster", h.GetClusterHandler)
+		admin.GET("/hotspots", h.GetHotspotsHandler)
+
+		// Leader balancer
+		admin.GET("/balancer", h.GetBalancerHandler)
+		admin.PUT("/balancer", h.UpdateBalancerHandler)
+
+		// Cluster event journal
+		admin.GET("/events", h.GetEventsHandler)
+		admin.GET("/events/stream", h.StreamEventsHandler)
+
+		// Keyspaces
+		admin.POST("/keyspaces", h.CreateKeyspaceHandler)
+		admin.GET("/keyspaces", h.ListKeyspacesHandler)
+		admin.GET("/keyspaces/:name", h.GetKeyspaceHandler)
+		admin.DELETE("/keyspaces/:name", h.DropKeyspaceHandler)
+
+		// Runtime settings
+		admin.GET("/settings", h.GetSettingsHandler)
+		admin.PUT("/settings", h.SetSettingsHandler)
+		admin.GET("/settings/status", h.GetSettingsStatusHandler)
+		admin.PUT("/settings/nodes/:id", h.SetNodeSettingsHandler)
+		admin.DELETE("/settings/nodes/:id", h.ClearNodeSettingsHandler)
+
+		// Load balancer fleet
+		admin.GET("/loadbalancers", h.ListLoadBalancersHandler)
 	}
 
 	internal := router.Group("/internal")
 	{
 		internal.POST("/nodes/register", h.NodeRegisterHandler)
+		internal.POST("/nodes/heartbeat", h.NodeHeartbeatHandler)
+		internal.GET("/topology", h.GetTopologyHandler)
+		internal.GET("/topology/watch", h.WatchTopologyHandler)
+		internal.GET("/keyspaces", h.GetKeyspaceCatalogHandler)
+		internal.GET("/settings", h.GetAppliedSettingsHandler)
+		internal.POST("/settings/ack", h.AckSettingsHandler)
+		internal.POST("/breakers", h.BreakerReportHandler)
+		internal.POST("/loadbalancers/heartbeat", h.LoadBalancerHeartbeatHandler)
+		internal.POST("/loadbalancers/deregister", h.DeregisterLoadBalancerHandler)
 	}
 	log.Println("Controller router setup complete, new nodes can connect via /internal/nodes/register")
 
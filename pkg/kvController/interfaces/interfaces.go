@@ -1,19 +1,57 @@
 package interfaces
 
 import (
+	"context"
+
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
 	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
 )
 
 type NodeManagerInterface interface {
 	GetShardInfo(shardID int) (*cluster.ShardInfo, bool)
 	GetNodeInfo(nodeID int) (cluster.NodeInfo, error)
-	RegisterNode(address string, port int) (*cluster.NodeInfo, error)
+	RegisterNode(address string, port int, topology cluster.Topology) (*cluster.NodeInfo, error)
 	UpdateShardMaster(shardID int, masterID int) error
 }
 
 type KvControllerInterface interface {
-	RegisterNode(address string, port int) (*cluster.NodeInfo, error)
+	RegisterNode(address string, port int, topology cluster.Topology) (*cluster.NodeInfo, error)
+	Heartbeat(req api.HeartbeatRequest) (api.HeartbeatResponse, error)
 	ChangePartitionLeader(shardID int, nodeID int) error
+	SetReplicas(shardKey int, replicas int) ([]api.ReplicaChange, error)
+	AddNode(shardKey int) (*cluster.NodeInfo, error)
+	AddSpareNode() (*cluster.NodeInfo, error)
+	DecommissionNode(nodeID int) error
+	EnterMaintenance(nodeID int) error
+	ExitMaintenance(nodeID int) error
 	GetNodeManager() NodeManagerInterface
-	GetClusterDetails() []*cluster.NodeInfo
+	GetClusterDetails() []cluster.NodeInfo
+	GetPlacementWarnings() []string
+	GetHealth() api.ClusterHealth
+	Ready() (bool, string)
+	GetHotspots(limit int) api.HotspotReport
+	CreateKeyspace(name string, quota api.KeyspaceQuota) (api.Keyspace, error)
+	DropKeyspace(name string) error
+	GetKeyspaces() []api.Keyspace
+	GetKeyspace(name string) (api.Keyspace, error)
+	GetKeyspaceCatalog() api.KeyspaceCatalog
+	GetSettings() api.SettingsDocument
+	SetClusterSettings(settings api.RuntimeSettings) (api.SettingsDocument, error)
+	SetNodeSettings(nodeID int, settings api.RuntimeSettings) (api.SettingsDocument, error)
+	ClearNodeSettings(nodeID int) (api.SettingsDocument, error)
+	GetSettingsStatus() api.SettingsStatus
+	GetAppliedSettings(nodeID int) api.AppliedSettings
+	AckSettings(ack api.SettingsAck)
+	RecordBreakers(report api.BreakerReport)
+	LoadBalancerHeartbeat(hb api.LoadBalancerHeartbeat)
+	DeregisterLoadBalancer(id string)
+	GetLoadBalancers() []api.LoadBalancerInfo
+	GetPartitionMap() partition.Map
+	GetTopology() api.Topology
+	WatchTopology(ctx context.Context, incarnation string, version int64) (api.Topology, bool)
+	GetBalancerStatus() api.BalancerStatus
+	UpdateBalancer(settings api.BalancerSettings) api.BalancerStatus
+	GetEvents(filter api.EventFilter) []api.Event
+	SubscribeEvents(filter api.EventFilter) ([]api.Event, <-chan api.Event, func())
 }
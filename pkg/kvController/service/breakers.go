@@ -0,0 +1,68 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/sirupsen/logrus"
+)
+
+// breakerReportTTL is how long the circuit breakers reported by a load
+// balancer count, a load balancer that stopped reporting is likely gone.
+const breakerReportTTL = 30 * time.Second
+
+// breakerReport is the latest circuit breaker report of a load balancer.
+type breakerReport struct {
+	report   api.BreakerReport
+	received time.Time
+}
+
+// RecordBreakers stores the circuit breakers a load balancer reported.
+func (hm *HealthManager) RecordBreakers(report api.BreakerReport) {
+	hm.breakerMu.Lock()
+	previous := hm.breakers[report.LoadBalancer].report
+	hm.breakers[report.LoadBalancer] = breakerReport{report: report, received: time.Now()}
+	hm.breakerMu.Unlock()
+
+	states := make(map[int]api.BreakerState, len(previous.Breakers))
+	for _, b := range previous.Breakers {
+		states[b.NodeID] = b.State
+	}
+	for _, b := range report.Breakers {
+		if b.State == api.BreakerOpen && states[b.NodeID] != api.BreakerOpen {
+			logrus.WithFields(logrus.Fields{
+				"loadBalancer": report.LoadBalancer,
+				"node":         b.NodeID,
+				"errorRate":    b.ErrorRate,
+				"latencyMs":    b.LatencyMs,
+			}).Warn("Load balancer opened its circuit breaker to a node")
+		}
+	}
+}
+
+// openBreakers returns the load balancers whose circuit breaker to a node is
+// not closed.
+func (hm *HealthManager) openBreakers(nodeID int) []string {
+	hm.breakerMu.Lock()
+	defer hm.breakerMu.Unlock()
+
+	var loadBalancers []string
+	for id, r := range hm.breakers {
+		if time.Since(r.received) > breakerReportTTL {
+			continue
+		}
+		for _, b := range r.report.Breakers {
+			if b.NodeID == nodeID && b.State != api.BreakerClosed {
+				loadBalancers = append(loadBalancers, id)
+				break
+			}
+		}
+	}
+	sort.Strings(loadBalancers)
+	return loadBalancers
+}
+
+func (c *KvController) RecordBreakers(report api.BreakerReport) {
+	c.HealthManager.RecordBreakers(report)
+}
@@ -1,11 +1,16 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"strings"
 
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
 	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
 
 	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/partition"
 	"github.com/Amirali-Amirifar/kv/pkg/kvController/api"
 	"github.com/Amirali-Amirifar/kv/pkg/kvController/interfaces"
 	"github.com/gin-gonic/gin"
@@ -13,10 +18,15 @@ import (
 )
 
 type KvController struct {
-	Router        *gin.Engine
-	Config        *config.KvControllerConfig
-	NodeManager   *NodeManager
-	HealthManager *HealthManager
+	Router         *gin.Engine
+	Config         *config.KvControllerConfig
+	NodeManager    *NodeManager
+	HealthManager  *HealthManager
+	RangeManager   *RangeManager
+	LeaderBalancer *LeaderBalancer
+	Hotspots       *HotspotDetector
+	Settings       *SettingsManager
+	LoadBalancers  *LoadBalancerFleet
 }
 
 func NewKvController(cfg *config.KvControllerConfig) *KvController {
@@ -31,6 +41,24 @@ func NewKvController(cfg *config.KvControllerConfig) *KvController {
 
 	// Initialize HealthManager
 	controller.HealthManager = NewHealthManager(controller.NodeManager, cfg)
+	controller.NodeManager.healthManager = controller.HealthManager
+
+	// Initialize RangeManager
+	controller.RangeManager = NewRangeManager(controller.NodeManager, cfg)
+
+	// Initialize LeaderBalancer
+	controller.LeaderBalancer = NewLeaderBalancer(controller.NodeManager, controller.HealthManager, func(shardID, nodeID int, reason string) error {
+		return controller.changePartitionLeader(shardID, nodeID, actorBalancer, reason)
+	}, cfg)
+
+	// Initialize SettingsManager
+	controller.Settings = NewSettingsManager(controller.NodeManager)
+
+	// Initialize LoadBalancerFleet
+	controller.LoadBalancers = NewLoadBalancerFleet()
+
+	// Initialize HotspotDetector
+	controller.Hotspots = NewHotspotDetector(controller.NodeManager, controller.HealthManager, cfg)
 
 	handler := api.NewRouteHandler(controller)
 	router := api.SetupRouter(handler)
@@ -43,20 +71,42 @@ func NewKvController(cfg *config.KvControllerConfig) *KvController {
 func (c *KvController) Start() error {
 	addr := c.Config.Address.Host + ":" + fmt.Sprint(c.Config.Address.Port)
 	logrus.Infof("Starting KvController on %s", addr)
+	c.HealthManager.Start()
+	c.LeaderBalancer.Start()
+	if c.NodeManager.mode == partition.ModeRange {
+		c.RangeManager.Start()
+	}
 	return c.Router.Run(addr)
 }
 
-func (c *KvController) RegisterNode(address string, port int) (node *cluster.NodeInfo, err error) {
-	node, err = c.NodeManager.RegisterNode(address, port)
+func (c *KvController) RegisterNode(address string, port int, topology cluster.Topology) (node *cluster.NodeInfo, err error) {
+	node, err = c.NodeManager.RegisterNode(address, port, topology)
+	if err == nil {
+		c.HealthManager.trackNode(node.ID)
+	}
 	return
 }
 
+func (c *KvController) Heartbeat(req apiTypes.HeartbeatRequest) (apiTypes.HeartbeatResponse, error) {
+	resp, err := c.HealthManager.RecordHeartbeat(req)
+	if err == nil {
+		c.Settings.report(componentNode, strconv.Itoa(req.NodeID), req.SettingsVersion)
+	}
+	return resp, err
+}
+
 func (c *KvController) CheckNodesHealth() {
 	c.HealthManager.checkNodes()
 }
 
 func (c *KvController) ChangePartitionLeader(shardID, targetNodeID int) error {
-	shardInfo, exists := c.NodeManager.GetShardInfo(shardID)
+	return c.changePartitionLeader(shardID, targetNodeID, actorAdmin, "requested")
+}
+
+// changePartitionLeader moves leadership to an active follower, actor and
+// reason are recorded in the event log.
+func (c *KvController) changePartitionLeader(shardID, targetNodeID int, actor, reason string) error {
+	shardInfo, exists := c.NodeManager.shardSnapshot(shardID)
 	if !exists {
 		return fmt.Errorf("shard %d not found", shardID)
 	}
@@ -77,25 +127,68 @@ func (c *KvController) ChangePartitionLeader(shardID, targetNodeID int) error {
 		return fmt.Errorf("invalid or inactive target node")
 	}
 
-	oldLeaderID := shardInfo.GetMaster().GetID()
+	return c.transferLeadership(shardID, targetNodeID, actor, reason)
+}
+
+// transferLeadership makes targetNodeID the master of the shard and points
+// the remaining members at it. The old master stops accepting writes first and
+// the target is only promoted once it applied all of them, so no acknowledged
+// write is lost. The transfer is called off, and the old master resumes, if
+// the target does not catch up or cannot be told it leads.
+func (c *KvController) transferLeadership(shardID, targetNodeID int, actor, reason string) error {
+	shardInfo, exists := c.NodeManager.shardSnapshot(shardID)
+	if !exists {
+		return fmt.Errorf("shard %d not found", shardID)
+	}
+	if shardInfo.Master == nil {
+		return fmt.Errorf("shard %d has no master", shardID)
+	}
+
+	targetNode, err := c.NodeManager.GetNodeInfo(targetNodeID)
+	if err != nil {
+		return err
+	}
+
+	oldLeader := *shardInfo.Master
+	oldLeaderID := oldLeader.ID
+
+	forkSeq, err := c.HealthManager.fenceNode(&oldLeader, true)
+	if err != nil {
+		return fmt.Errorf("failed to stop writes on master %d: %v", oldLeaderID, err)
+	}
+	unfence := func() {
+		if _, err := c.HealthManager.fenceNode(&oldLeader, false); err != nil {
+			logrus.WithError(err).WithField("node", oldLeaderID).Error("Failed to resume writes on master after a failed leadership transfer")
+		}
+	}
+	if _, err := c.HealthManager.waitForCatchUp([]*cluster.NodeInfo{&targetNode}, forkSeq, 1); err != nil {
+		unfence()
+		return fmt.Errorf("node %d did not catch up with master %d: %v", targetNodeID, oldLeaderID, err)
+	}
 
 	// Update master
-	if err := c.NodeManager.UpdateShardMaster(shardID, targetNodeID); err != nil {
+	change, err := c.NodeManager.promote(shardID, shardInfo.Epoch, targetNodeID, forkSeq, true)
+	if err != nil {
+		unfence()
 		return err
 	}
+	epoch := change.newEpoch()
 
-	if err := c.HealthManager.notifyNewLeader(&targetNode); err != nil {
+	if err := c.HealthManager.notifyNewLeader(&targetNode, epoch, forkSeq); err != nil {
+		if c.NodeManager.revertMasterChange(change) {
+			unfence()
+		}
 		return fmt.Errorf("failed to notify new leader: %v", err)
 	}
 
-	var followers []*cluster.NodeInfo
-	for _, f := range shardInfo.GetFollowers() {
-		if node, err := c.NodeManager.GetNodeInfo(f.GetID()); err == nil {
-			followers = append(followers, &node)
+	followers := []*cluster.NodeInfo{&oldLeader}
+	for _, f := range shardInfo.Followers {
+		if f.ID != targetNodeID {
+			followers = append(followers, f)
 		}
 	}
 
-	if err := c.HealthManager.notifyFollowers(followers, &targetNode); err != nil {
+	if err := c.HealthManager.notifyFollowers(followers, &targetNode, epoch, forkSeq); err != nil {
 		logrus.WithError(err).Warn("Failed to notify some followers about leader change")
 	}
 
@@ -103,21 +196,311 @@ func (c *KvController) ChangePartitionLeader(shardID, targetNodeID int) error {
 		"shard_id":   shardID,
 		"old_leader": oldLeaderID,
 		"new_leader": targetNodeID,
+		"epoch":      epoch,
 	}).Info("Shard leader changed successfully")
+	c.NodeManager.events.Record(apiTypes.Event{
+		Type:     apiTypes.EventLeaderChanged,
+		NodeID:   targetNodeID,
+		ShardKey: shardID,
+		Actor:    actor,
+		Reason:   reason,
+		Details: map[string]string{
+			"from":     fmt.Sprint(oldLeaderID),
+			"to":       fmt.Sprint(targetNodeID),
+			"epoch":    fmt.Sprint(epoch),
+			"fork_seq": fmt.Sprint(forkSeq),
+		},
+	})
 
 	return nil
 }
 
-func (c *KvController) GetClusterDetails() []*cluster.NodeInfo {
-	// Get all nodes from NodeManager
-	allNodes := c.NodeManager.Nodes
-	nodes := make([]*cluster.NodeInfo, len(allNodes))
-	for i, node := range allNodes {
-		nodes[i] = node
+// AddNode pre-provisions an empty slot in a shard for a new node to register into.
+// A negative shardKey places the slot in the shard with the fewest nodes.
+func (c *KvController) AddNode(shardKey int) (*cluster.NodeInfo, error) {
+	node, err := c.NodeManager.AddNodeSlot(shardKey)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"node_id":   node.ID,
+		"shard_key": node.ShardKey,
+		"node_type": node.StoreNodeType,
+	}).Info("Provisioned node slot")
+	c.NodeManager.events.Record(apiTypes.Event{
+		Type:     apiTypes.EventNodeAdded,
+		NodeID:   node.ID,
+		ShardKey: node.ShardKey,
+		Actor:    actorAdmin,
+		Details:  map[string]string{"role": string(node.StoreNodeType)},
+	})
+
+	return node, nil
+}
+
+// AddSpareNode pre-provisions a slot outside of any shard, spare nodes host
+// the new shards created by range splits.
+func (c *KvController) AddSpareNode() (*cluster.NodeInfo, error) {
+	node := c.NodeManager.AddSpareSlot()
+	logrus.WithField("node_id", node.ID).Info("Provisioned spare node slot")
+	c.NodeManager.events.Record(apiTypes.Event{
+		Type:     apiTypes.EventNodeAdded,
+		NodeID:   node.ID,
+		ShardKey: -1,
+		Actor:    actorAdmin,
+		Reason:   "spare",
+	})
+	return node, nil
+}
+
+// DecommissionNode gracefully removes a node from the cluster. Leadership is
+// moved to a caught-up follower, the remaining replicas are given time to
+// catch up, and only then is the node told to stop serving and its slot released.
+func (c *KvController) DecommissionNode(nodeID int) error {
+	node, err := c.NodeManager.GetNodeInfo(nodeID)
+	if err != nil {
+		return err
+	}
+	if node.Status == cluster.NodeStatusDecommissioning || node.Status == cluster.NodeStatusDecommissioned {
+		return fmt.Errorf("node %d is already %s", nodeID, strings.ToLower(string(node.Status)))
+	}
+	if err := c.NodeManager.CanRemoveNode(nodeID); err != nil {
+		return err
+	}
+
+	// Empty and failed slots hold no data worth moving.
+	if !isServing(node.Status) {
+		return c.NodeManager.ReleaseNode(nodeID)
+	}
+	if node.ShardKey < 0 {
+		if err := c.HealthManager.stopNode(&node); err != nil {
+			logrus.WithError(err).WithField("node", nodeID).Warn("Failed to tell decommissioned node to stop serving")
+		}
+		return c.NodeManager.ReleaseNode(nodeID)
+	}
+
+	if err := c.NodeManager.SetNodeStatus(nodeID, cluster.NodeStatusDecommissioning); err != nil {
+		return err
+	}
+	if err := c.drainNode(node); err != nil {
+		_ = c.NodeManager.SetNodeStatus(nodeID, node.Status)
+		return fmt.Errorf("failed to decommission node %d: %v", nodeID, err)
+	}
+
+	if err := c.HealthManager.stopNode(&node); err != nil {
+		logrus.WithError(err).WithField("node", nodeID).Warn("Failed to tell decommissioned node to stop serving")
+	}
+	if err := c.NodeManager.ReleaseNode(nodeID); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"node_id":   nodeID,
+		"shard_key": node.ShardKey,
+	}).Info("Node decommissioned")
+
+	return nil
+}
+
+// drainNode moves leadership away from the node if it is a master, then waits
+// until every other registered replica of its shard has caught up with the master.
+func (c *KvController) drainNode(node cluster.NodeInfo) error {
+	shardInfo, exists := c.NodeManager.GetShardInfo(node.ShardKey)
+	if !exists {
+		return fmt.Errorf("shard %d not found", node.ShardKey)
+	}
+
+	replicas := servingReplicas(shardInfo, node.ID)
+	if node.StoreNodeType == cluster.NodeTypeMaster {
+		newMaster, err := c.handOffLeadership(node, replicas, "decommission")
+		if err != nil {
+			return err
+		}
+
+		// The new master no longer needs to catch up with itself.
+		others := make([]*cluster.NodeInfo, 0, len(replicas))
+		for _, r := range replicas {
+			if r.ID != newMaster.ID {
+				others = append(others, r)
+			}
+		}
+		replicas = others
+	}
+
+	master := shardInfo.GetMaster()
+	seq, err := c.HealthManager.getNodeLastSeq(master)
+	if err != nil {
+		return fmt.Errorf("failed to get last sequence number of master %d: %v", master.ID, err)
+	}
+	_, err = c.HealthManager.waitForCatchUp(replicas, seq, len(replicas))
+	return err
+}
+
+// servingReplicas returns the followers of a shard, other than nodeID, that hold an up to date copy.
+func servingReplicas(shardInfo *cluster.ShardInfo, nodeID int) []*cluster.NodeInfo {
+	var replicas []*cluster.NodeInfo
+	for _, f := range shardInfo.GetFollowers() {
+		if f.ID != nodeID && isServing(f.GetStatus()) {
+			replicas = append(replicas, f)
+		}
+	}
+	return replicas
+}
+
+// handOffLeadership waits until one of the replicas caught up with the master
+// node and makes it the new master of the shard.
+func (c *KvController) handOffLeadership(node cluster.NodeInfo, replicas []*cluster.NodeInfo, reason string) (*cluster.NodeInfo, error) {
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("no follower of shard %d can take over from node %d", node.ShardKey, node.ID)
+	}
+	seq, err := c.HealthManager.getNodeLastSeq(&node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last sequence number of node %d: %v", node.ID, err)
+	}
+	caughtUp, err := c.HealthManager.waitForCatchUp(replicas, seq, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.transferLeadership(node.ShardKey, caughtUp[0].ID, actorAdmin, reason); err != nil {
+		return nil, err
+	}
+	return caughtUp[0], nil
+}
+
+// EnterMaintenance takes a node out of service on purpose. Leadership is moved
+// to a caught-up follower first, then missed heartbeats of the node are no
+// longer treated as a failure.
+func (c *KvController) EnterMaintenance(nodeID int) error {
+	node, err := c.NodeManager.enterMaintenance(nodeID)
+	if err != nil {
+		return err
+	}
+
+	if node.StoreNodeType == cluster.NodeTypeMaster && isServing(node.Status) {
+		shardInfo, exists := c.NodeManager.GetShardInfo(node.ShardKey)
+		if !exists {
+			_ = c.NodeManager.SetNodeStatus(nodeID, node.Status)
+			return fmt.Errorf("shard %d not found", node.ShardKey)
+		}
+		if _, err := c.handOffLeadership(node, servingReplicas(shardInfo, nodeID), "maintenance"); err != nil {
+			_ = c.NodeManager.SetNodeStatus(nodeID, node.Status)
+			return fmt.Errorf("failed to move leadership off node %d: %v", nodeID, err)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"node_id":   nodeID,
+		"shard_key": node.ShardKey,
+	}).Info("Node entered maintenance")
+	c.NodeManager.events.Record(apiTypes.Event{
+		Type:     apiTypes.EventMaintenanceStarted,
+		NodeID:   nodeID,
+		ShardKey: node.ShardKey,
+		Actor:    actorAdmin,
+		Details:  map[string]string{"status": string(node.Status), "role": string(node.StoreNodeType)},
+	})
+	return nil
+}
+
+// ExitMaintenance returns a node to service. It rejoins its shard as SYNCING
+// and becomes ACTIVE once it caught up with its master. A node that cannot be
+// told its role stays in maintenance.
+func (c *KvController) ExitMaintenance(nodeID int) error {
+	node, rejoin, err := c.NodeManager.exitMaintenance(nodeID)
+	if err != nil {
+		return err
+	}
+
+	if node.ShardKey >= 0 {
+		update := apiTypes.StateUpdate{
+			State:         rejoin.Role,
+			LeaderID:      rejoin.LeaderID,
+			LeaderAddress: rejoin.LeaderAddress,
+			Epoch:         rejoin.Epoch,
+			ForkSeq:       rejoin.ForkSeq,
+		}
+		if err := c.HealthManager.updateNodeState(&node, update); err != nil {
+			_ = c.NodeManager.SetNodeStatus(nodeID, cluster.NodeStatusMaintenance)
+			return fmt.Errorf("failed to tell node %d about its role: %v", nodeID, err)
+		}
+	}
+	c.HealthManager.trackNode(nodeID)
+
+	logrus.WithFields(logrus.Fields{
+		"node_id":   nodeID,
+		"shard_key": node.ShardKey,
+		"role":      rejoin.Role,
+	}).Info("Node left maintenance, waiting for it to catch up")
+	c.NodeManager.events.Record(apiTypes.Event{
+		Type:     apiTypes.EventMaintenanceEnded,
+		NodeID:   nodeID,
+		ShardKey: node.ShardKey,
+		Actor:    actorAdmin,
+		Details:  map[string]string{"role": string(rejoin.Role), "epoch": fmt.Sprint(rejoin.Epoch)},
+	})
+	return nil
+}
+
+func (c *KvController) GetClusterDetails() []cluster.NodeInfo {
+	// Copy the nodes under the lock, heartbeats keep changing them
+	c.NodeManager.mutex.Lock()
+	defer c.NodeManager.mutex.Unlock()
+
+	nodes := make([]cluster.NodeInfo, 0, len(c.NodeManager.Nodes))
+	for _, node := range c.NodeManager.Nodes {
+		if node.Status == cluster.NodeStatusDecommissioned {
+			continue
+		}
+		nodes = append(nodes, *node)
 	}
 	return nodes
 }
 
+func (c *KvController) GetTopology() apiTypes.Topology {
+	return c.NodeManager.Topology()
+}
+
+func (c *KvController) WatchTopology(ctx context.Context, incarnation string, version int64) (apiTypes.Topology, bool) {
+	return c.NodeManager.WatchTopology(ctx, incarnation, version)
+}
+
+func (c *KvController) GetBalancerStatus() apiTypes.BalancerStatus {
+	return c.LeaderBalancer.Status()
+}
+
+func (c *KvController) UpdateBalancer(settings apiTypes.BalancerSettings) apiTypes.BalancerStatus {
+	return c.LeaderBalancer.Update(settings)
+}
+
+func (c *KvController) GetEvents(filter apiTypes.EventFilter) []apiTypes.Event {
+	return c.NodeManager.events.Query(filter)
+}
+
+func (c *KvController) SubscribeEvents(filter apiTypes.EventFilter) ([]apiTypes.Event, <-chan apiTypes.Event, func()) {
+	return c.NodeManager.events.Subscribe(filter)
+}
+
+func (c *KvController) GetHealth() apiTypes.ClusterHealth {
+	return c.HealthManager.Report()
+}
+
+func (c *KvController) Ready() (bool, string) {
+	return c.HealthManager.Ready()
+}
+
+func (c *KvController) GetHotspots(limit int) apiTypes.HotspotReport {
+	return c.Hotspots.Report(limit)
+}
+
+func (c *KvController) GetPlacementWarnings() []string {
+	return c.NodeManager.PlacementWarnings()
+}
+
+func (c *KvController) GetPartitionMap() partition.Map {
+	return c.NodeManager.GetPartitionMap()
+}
+
 func (c *KvController) GetNodeManager() interfaces.NodeManagerInterface {
 	return c.NodeManager
 }
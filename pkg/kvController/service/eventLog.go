@@ -0,0 +1,141 @@
+package service
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+)
+
+// defaultEventRetention is the number of events kept when none is configured.
+const defaultEventRetention = 1000
+
+// eventSubscriberBuffer is how many events a stream subscriber may fall behind
+// before it is dropped.
+const eventSubscriberBuffer = 64
+
+// Actors of cluster events.
+const (
+	actorAdmin      = "admin"
+	actorController = "controller"
+	actorBalancer   = "balancer"
+	actorNode       = "node"
+)
+
+// EventLog is the controller's journal of cluster events. It keeps the most
+// recent events in a ring buffer and fans new ones out to stream subscribers.
+type EventLog struct {
+	mu          sync.Mutex
+	events      []api.Event
+	next        int
+	full        bool
+	lastID      int64
+	subscribers map[chan api.Event]api.EventFilter
+}
+
+func NewEventLog(retention int) *EventLog {
+	if retention <= 0 {
+		retention = defaultEventRetention
+	}
+	return &EventLog{
+		events:      make([]api.Event, retention),
+		subscribers: make(map[chan api.Event]api.EventFilter),
+	}
+}
+
+// Record appends an event to the journal and publishes it to subscribers.
+func (el *EventLog) Record(event api.Event) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	el.lastID++
+	event.ID = el.lastID
+	event.Time = time.Now()
+
+	el.events[el.next] = event
+	el.next = (el.next + 1) % len(el.events)
+	if el.next == 0 {
+		el.full = true
+	}
+
+	for ch, filter := range el.subscribers {
+		if !eventMatches(filter, event) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			// A subscriber that cannot keep up is dropped, it resumes from its last event ID
+			delete(el.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Query returns the retained events that match filter, oldest first. With a
+// limit only the newest matching events are returned.
+func (el *EventLog) Query(filter api.EventFilter) []api.Event {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	return el.query(filter)
+}
+
+// query must be called with the lock held.
+func (el *EventLog) query(filter api.EventFilter) []api.Event {
+	retained := el.events[:el.next]
+	if el.full {
+		retained = append(slices.Clone(el.events[el.next:]), retained...)
+	}
+
+	matched := make([]api.Event, 0)
+	for _, event := range retained {
+		if eventMatches(filter, event) {
+			matched = append(matched, event)
+		}
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[len(matched)-filter.Limit:]
+	}
+	return matched
+}
+
+// Subscribe returns the retained events that match filter and a channel that
+// receives the matching events recorded from now on. The channel is closed
+// when cancel is called or the subscriber falls too far behind.
+func (el *EventLog) Subscribe(filter api.EventFilter) ([]api.Event, <-chan api.Event, func()) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	backlog := el.query(filter)
+	ch := make(chan api.Event, eventSubscriberBuffer)
+	filter.Limit = 0
+	el.subscribers[ch] = filter
+
+	cancel := func() {
+		el.mu.Lock()
+		defer el.mu.Unlock()
+		if _, ok := el.subscribers[ch]; ok {
+			delete(el.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel
+}
+
+// eventMatches reports whether event passes filter, the limit is not applied.
+func eventMatches(filter api.EventFilter, event api.Event) bool {
+	if len(filter.Types) > 0 && !slices.Contains(filter.Types, event.Type) {
+		return false
+	}
+	if filter.NodeID >= 0 && event.NodeID != filter.NodeID {
+		return false
+	}
+	if filter.ShardKey >= 0 && event.ShardKey != filter.ShardKey {
+		return false
+	}
+	if event.ID <= filter.AfterID {
+		return false
+	}
+	return filter.Since.IsZero() || !event.Time.Before(filter.Since)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// phiWindowSize is the number of heartbeat intervals the detector remembers.
+const phiWindowSize = 100
+
+// phiAccrualDetector implements the phi-accrual failure detector. Instead of a
+// fixed timeout it learns the distribution of heartbeat intervals and reports
+// phi, the -log10 probability that a heartbeat this late is still coming.
+// A phi of 8 means a one in 10^8 chance of a false positive.
+type phiAccrualDetector struct {
+	mu        sync.Mutex
+	intervals []float64 // milliseconds, ring buffer
+	next      int
+	last      time.Time
+	// minStdDev keeps phi from exploding when heartbeats are very regular.
+	minStdDev float64
+}
+
+// newPhiAccrualDetector seeds the detector with the expected interval so phi is
+// meaningful from the first heartbeat on.
+func newPhiAccrualDetector(expected time.Duration, now time.Time) *phiAccrualDetector {
+	ms := float64(expected.Milliseconds())
+	return &phiAccrualDetector{
+		intervals: []float64{ms, ms},
+		last:      now,
+		minStdDev: ms / 2,
+	}
+}
+
+func (d *phiAccrualDetector) heartbeat(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	interval := float64(now.Sub(d.last).Milliseconds())
+	d.last = now
+	if len(d.intervals) < phiWindowSize {
+		d.intervals = append(d.intervals, interval)
+		return
+	}
+	d.intervals[d.next] = interval
+	d.next = (d.next + 1) % phiWindowSize
+}
+
+func (d *phiAccrualDetector) lastHeartbeat() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.last
+}
+
+func (d *phiAccrualDetector) phi(now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var sum, sumSq float64
+	for _, v := range d.intervals {
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(len(d.intervals))
+	mean := sum / n
+	stdDev := math.Max(math.Sqrt(math.Max(sumSq/n-mean*mean, 0)), d.minStdDev)
+
+	// Logistic approximation of the normal CDF, as used by Akka and Cassandra
+	elapsed := float64(now.Sub(d.last).Milliseconds())
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhiGrowsWithTheTimeSinceTheLastHeartbeat(t *testing.T) {
+	start := time.Unix(0, 0)
+	d := newPhiAccrualDetector(time.Second, start)
+	now := start
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Second)
+		d.heartbeat(now)
+	}
+
+	if phi := d.phi(now.Add(time.Second)); phi > 1 {
+		t.Fatalf("phi is %.2f a heartbeat interval after the last one, want it below 1", phi)
+	}
+	previous := 0.0
+	for _, late := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 5 * time.Second} {
+		phi := d.phi(now.Add(late))
+		if phi <= previous {
+			t.Fatalf("phi %.2f at %v is not above %.2f", phi, late, previous)
+		}
+		previous = phi
+	}
+	if previous < 8 {
+		t.Fatalf("phi is %.2f five intervals late, want the node suspected", previous)
+	}
+}
+
+func TestPhiLearnsIrregularHeartbeats(t *testing.T) {
+	start := time.Unix(0, 0)
+	regular := newPhiAccrualDetector(time.Second, start)
+	jittery := newPhiAccrualDetector(time.Second, start)
+	now := start
+	for i := 0; i < 50; i++ {
+		now = now.Add(time.Second)
+		regular.heartbeat(now)
+	}
+	now = start
+	for i := 0; i < 50; i++ {
+		// Alternates between a fifth and almost two intervals
+		now = now.Add(time.Duration(200+1600*(i%2)) * time.Millisecond)
+		jittery.heartbeat(now)
+	}
+
+	late := 2500 * time.Millisecond
+	if r, j := regular.phi(regular.lastHeartbeat().Add(late)), jittery.phi(jittery.lastHeartbeat().Add(late)); j >= r {
+		t.Fatalf("phi of the jittery node %.2f is not below the regular one's %.2f", j, r)
+	}
+}
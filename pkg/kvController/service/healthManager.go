@@ -6,25 +6,60 @@ import (
 	"fmt"
 	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
 	"github.com/sirupsen/logrus"
 )
 
+// nodeHeartbeat is the latest heartbeat of a node and the detector fed by it.
+type nodeHeartbeat struct {
+	detector *phiAccrualDetector
+	last     api.HeartbeatRequest
+}
+
 type HealthManager struct {
-	nodeManager *NodeManager
-	interval    time.Duration
-	timeout     time.Duration
-	stopChan    chan struct{}
+	nodeManager    *NodeManager
+	interval       time.Duration
+	timeout        time.Duration
+	catchUpTimeout time.Duration
+	suspectPhi     float64
+	failurePhi     float64
+	heartbeats     map[int]*nodeHeartbeat
+	heartbeatMu    sync.Mutex
+	// breakers are the circuit breaker reports of the load balancers by ID
+	breakers  map[string]breakerReport
+	breakerMu sync.Mutex
+	stopChan  chan struct{}
 }
 
 func NewHealthManager(nodeManager *NodeManager, cfg *config.KvControllerConfig) *HealthManager {
+	catchUpTimeout := time.Duration(cfg.Cluster.CatchUpTimeoutMs) * time.Millisecond
+	if catchUpTimeout <= 0 {
+		catchUpTimeout = 30 * time.Second
+	}
+	suspectPhi := cfg.Discovery.SuspectPhi
+	if suspectPhi <= 0 {
+		suspectPhi = 5
+	}
+	failurePhi := cfg.Discovery.FailurePhi
+	if failurePhi <= 0 {
+		failurePhi = 10
+	}
+
 	return &HealthManager{
-		nodeManager: nodeManager,
-		interval:    time.Duration(cfg.Discovery.HeartbeatIntervalMs) * time.Millisecond,
-		timeout:     time.Duration(cfg.Discovery.FailureTimeoutMs) * time.Millisecond,
-		stopChan:    make(chan struct{}),
+		nodeManager:    nodeManager,
+		interval:       time.Duration(cfg.Discovery.HeartbeatIntervalMs) * time.Millisecond,
+		timeout:        time.Duration(cfg.Discovery.FailureTimeoutMs) * time.Millisecond,
+		catchUpTimeout: catchUpTimeout,
+		suspectPhi:     suspectPhi,
+		failurePhi:     failurePhi,
+		heartbeats:     make(map[int]*nodeHeartbeat),
+		breakers:       make(map[string]breakerReport),
+		stopChan:       make(chan struct{}),
 	}
 }
 
@@ -50,122 +85,231 @@ func (hm *HealthManager) healthCheckLoop() {
 	}
 }
 
+// checkNodes moves nodes whose heartbeats are overdue to SUSPECT and, once the
+// failure detector is confident enough, to FAILED. Shards whose failed master
+// was not replaced yet get another election.
 func (hm *HealthManager) checkNodes() {
-	nodes := hm.nodeManager.GetActiveNodes()
-	for _, node := range nodes {
-		if err := hm.checkNode(node); err != nil {
-			logrus.WithError(err).WithField("node", node.ID).Warn("Node health check failed")
-			hm.handleNodeFailure(node)
+	// Elections that found no follower able to take over are tried again
+	for _, shardKey := range hm.nodeManager.failedMasters() {
+		hm.electNewLeader(shardKey, "master failed")
+	}
+
+	now := time.Now()
+	for _, node := range hm.nodeManager.GetMonitoredNodes() {
+		phi := hm.phi(node.ID, now)
+		switch {
+		case phi >= hm.failurePhi:
+			logrus.WithFields(logrus.Fields{
+				"node": node.ID,
+				"phi":  phi,
+			}).Warn("Node failed, heartbeats stopped")
+			hm.handleNodeFailure(node, phi)
+		case phi >= hm.suspectPhi && node.Status == cluster.NodeStatusActive:
+			logrus.WithFields(logrus.Fields{
+				"node": node.ID,
+				"phi":  phi,
+			}).Warn("Node suspected, heartbeats are late")
+			if err := hm.nodeManager.SetNodeStatus(node.ID, cluster.NodeStatusSuspect); err != nil {
+				logrus.WithError(err).WithField("node", node.ID).Error("Failed to mark node as suspect")
+				continue
+			}
+			hm.nodeManager.events.Record(api.Event{
+				Type:     api.EventNodeSuspected,
+				NodeID:   node.ID,
+				ShardKey: node.ShardKey,
+				Actor:    actorController,
+				Reason:   "heartbeats are late",
+				Details:  map[string]string{"phi": fmt.Sprintf("%.1f", phi)},
+			})
 		}
 	}
 }
 
-func (hm *HealthManager) checkNode(node cluster.NodeInfo) error {
-	client := &http.Client{Timeout: hm.timeout}
-	resp, err := client.Get(fmt.Sprintf("http://%s:%d/health", node.Address.IP.String(), node.Address.Port))
+// phi returns the suspicion level of a node, nodes that were never heard of
+// start being tracked now.
+func (hm *HealthManager) phi(nodeID int, now time.Time) float64 {
+	hm.heartbeatMu.Lock()
+	hb, ok := hm.heartbeats[nodeID]
+	if !ok {
+		hb = &nodeHeartbeat{detector: newPhiAccrualDetector(hm.interval, now)}
+		hm.heartbeats[nodeID] = hb
+	}
+	hm.heartbeatMu.Unlock()
+
+	return hb.detector.phi(now)
+}
+
+// trackNode restarts failure detection for a node, called when it (re)registers.
+func (hm *HealthManager) trackNode(nodeID int) {
+	hm.heartbeatMu.Lock()
+	defer hm.heartbeatMu.Unlock()
+	hm.heartbeats[nodeID] = &nodeHeartbeat{detector: newPhiAccrualDetector(hm.interval, time.Now())}
+}
+
+// RecordHeartbeat feeds a heartbeat into the node's failure detector and
+// returns the controller's view of the node.
+func (hm *HealthManager) RecordHeartbeat(req api.HeartbeatRequest) (api.HeartbeatResponse, error) {
+	resp, err := hm.nodeManager.heartbeatReceived(req)
 	if err != nil {
-		return err
+		return api.HeartbeatResponse{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("node returned non-200 status: %d", resp.StatusCode)
+	now := time.Now()
+	hm.heartbeatMu.Lock()
+	hb, ok := hm.heartbeats[req.NodeID]
+	if !ok {
+		hb = &nodeHeartbeat{detector: newPhiAccrualDetector(hm.interval, now)}
+		hm.heartbeats[req.NodeID] = hb
+	} else {
+		hb.detector.heartbeat(now)
 	}
+	hb.last = req
+	hm.heartbeatMu.Unlock()
 
-	return nil
+	resp.RemoteUsage = hm.keyspaceUsage(hm.nodeManager.shardOf(req.NodeID))
+	return resp, nil
 }
 
-func (hm *HealthManager) handleNodeFailure(node cluster.NodeInfo) {
-	hm.nodeManager.mutex.Lock()
-	defer hm.nodeManager.mutex.Unlock()
+// lastHeartbeat returns the latest heartbeat of a node and when it arrived.
+func (hm *HealthManager) lastHeartbeat(nodeID int) (api.HeartbeatRequest, time.Time, bool) {
+	hm.heartbeatMu.Lock()
+	defer hm.heartbeatMu.Unlock()
+
+	hb, ok := hm.heartbeats[nodeID]
+	if !ok {
+		return api.HeartbeatRequest{}, time.Time{}, false
+	}
+	return hb.last, hb.detector.lastHeartbeat(), true
+}
 
+func (hm *HealthManager) handleNodeFailure(node cluster.NodeInfo, phi float64) {
+	hm.nodeManager.mutex.Lock()
 	n := hm.nodeManager.Nodes[node.ID]
-	if n != nil {
+	if n != nil && n.Status == cluster.NodeStatusMaintenance {
+		hm.nodeManager.mutex.Unlock()
+		logrus.WithField("node", n.ID).Info("Ignoring missed heartbeats of node in maintenance")
+		return
+	}
+	var masterFailed bool
+	var shardKey int
+	if n != nil && n.Status != cluster.NodeStatusFailed && n.Status != cluster.NodeStatusDecommissioned {
 		n.Status = cluster.NodeStatusFailed
+		hm.nodeManager.events.Record(api.Event{
+			Type:     api.EventNodeFailed,
+			NodeID:   n.ID,
+			ShardKey: n.ShardKey,
+			Actor:    actorController,
+			Reason:   "heartbeats stopped",
+			Details:  map[string]string{"phi": fmt.Sprintf("%.1f", phi), "role": string(n.StoreNodeType)},
+		})
+		masterFailed, shardKey = n.StoreNodeType == cluster.NodeTypeMaster, n.ShardKey
+		hm.nodeManager.notifyTopologyChange()
+	}
+	hm.nodeManager.mutex.Unlock()
 
-		// If this was a master node, we need to elect a new leader
-		if n.StoreNodeType == cluster.NodeTypeMaster {
-			hm.electNewLeader(n.ShardKey)
-		}
+	// If this was a master node, we need to elect a new leader
+	if masterFailed {
+		hm.electNewLeader(shardKey, "master failed")
 	}
 }
 
-func (hm *HealthManager) electNewLeader(shardKey int) {
-	shardInfo, exists := hm.nodeManager.ShardMap[shardKey]
+// electNewLeader makes the most caught-up serving follower the master of the
+// shard in a new epoch, reason is recorded in the event log. The followers are
+// asked for their progress without the node manager lock, so heartbeats keep
+// coming in meanwhile, and the result is only committed if the shard is still
+// in the epoch the election started in. A follower that cannot be told it
+// leads is replaced by the next one. Must be called without the lock held.
+func (hm *HealthManager) electNewLeader(shardKey int, reason string) {
+	shardInfo, exists := hm.nodeManager.shardSnapshot(shardKey)
 	if !exists {
 		logrus.WithField("shardKey", shardKey).Error("Shard not found during leader election")
 		return
 	}
+	failed := shardInfo.Master
 
-	// Find the follower with the highest sequence number
-	var newLeader *cluster.NodeInfo
-	var highestSeq int64 = -1
-
-	// Check all followers
+	// Ask every serving follower for its last sequence number
+	type candidate struct {
+		node *cluster.NodeInfo
+		seq  int64
+	}
+	var candidates []candidate
 	for _, follower := range shardInfo.Followers {
-		if follower.Status != cluster.NodeStatusActive {
+		if !isServing(follower.Status) {
 			continue
 		}
-
-		// Get the follower's last sequence number
 		seq, err := hm.getNodeLastSeq(follower)
 		if err != nil {
 			logrus.WithError(err).WithField("node", follower.ID).Error("Failed to get node last sequence number")
 			continue
 		}
-		if seq > highestSeq {
-			highestSeq = seq
-			newLeader = follower
-		}
-	}
-
-	if newLeader == nil {
-		logrus.WithField("shardKey", shardKey).Error("No suitable follower found for leader election, terminating the shard")
-		// TODO: Remove the shard from the shard map
-		return
+		candidates = append(candidates, candidate{node: follower, seq: seq})
 	}
+	// Highest sequence number first, then followers outside the failed
+	// master's zone, the whole zone may be going down
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].seq != candidates[j].seq {
+			return candidates[i].seq > candidates[j].seq
+		}
+		return !sameZone(failed, candidates[i].node) && sameZone(failed, candidates[j].node)
+	})
 
-	// Update the shard's master
-	shardInfo.Master = newLeader
-	newLeader.StoreNodeType = cluster.NodeTypeMaster
+	for _, c := range candidates {
+		newLeader := c.node
+		change, err := hm.nodeManager.promote(shardKey, shardInfo.Epoch, newLeader.ID, c.seq, false)
+		if err != nil {
+			logrus.WithError(err).WithField("shardKey", shardKey).Warn("Shard changed during leader election, keeping its new master")
+			return
+		}
+		epoch := change.newEpoch()
+
+		// Notify the new leader
+		if err := hm.notifyNewLeader(newLeader, epoch, c.seq); err != nil {
+			logrus.WithError(err).WithField("node", newLeader.ID).Error("Failed to notify new leader")
+			if !hm.nodeManager.revertMasterChange(change) {
+				return
+			}
+			continue
+		}
 
-	// Remove the new leader from followers list
-	newFollowers := make([]*cluster.NodeInfo, 0)
-	for _, f := range shardInfo.Followers {
-		if f.ID != newLeader.ID {
-			newFollowers = append(newFollowers, f)
+		// Notify followers about the leadership change
+		if err := hm.notifyFollowers(shardInfo.Followers, newLeader, epoch, c.seq); err != nil {
+			logrus.WithError(err).Error("Failed to notify some followers about leader change")
 		}
-	}
-	shardInfo.Followers = newFollowers
 
-	// Notify the new leader
-	if err := hm.notifyNewLeader(newLeader); err != nil {
-		logrus.WithError(err).WithField("node", newLeader.ID).Error("Failed to notify new leader")
+		from := -1
+		if failed != nil {
+			from = failed.ID
+		}
+		logrus.WithFields(logrus.Fields{
+			"shardKey":  shardKey,
+			"newLeader": newLeader.ID,
+			"epoch":     epoch,
+		}).Info("New leader elected")
+		hm.nodeManager.events.Record(api.Event{
+			Type:     api.EventLeaderChanged,
+			NodeID:   newLeader.ID,
+			ShardKey: shardKey,
+			Actor:    actorController,
+			Reason:   reason,
+			Details: map[string]string{
+				"from":     fmt.Sprint(from),
+				"to":       fmt.Sprint(newLeader.ID),
+				"epoch":    fmt.Sprint(epoch),
+				"fork_seq": fmt.Sprint(c.seq),
+			},
+		})
 		return
 	}
 
-	// Notify followers about the leadership change
-	if err := hm.notifyFollowers(shardInfo.Followers, newLeader); err != nil {
-		logrus.WithError(err).Error("Failed to notify some followers about leader change")
-	}
-
-	logrus.WithFields(logrus.Fields{
-		"shardKey":  shardKey,
-		"newLeader": newLeader.ID,
-	}).Info("New leader elected")
+	logrus.WithField("shardKey", shardKey).Error("No suitable follower found for leader election, terminating the shard")
+	// TODO: Remove the shard from the shard map
 }
 
-func (hm *HealthManager) updateNodeState(node *cluster.NodeInfo, state cluster.StoreNodeType, leaderID int) error {
+// updateNodeState tells a node its new role. The update carries the leader
+// address so the node has no reason to call back into the controller.
+func (hm *HealthManager) updateNodeState(node *cluster.NodeInfo, update api.StateUpdate) error {
 	client := &http.Client{Timeout: hm.timeout}
-	stateUpdate := struct {
-		State    cluster.StoreNodeType `json:"state"`
-		LeaderID int                   `json:"leader_id"`
-	}{
-		State:    state,
-		LeaderID: leaderID,
-	}
-
-	body, err := json.Marshal(stateUpdate)
+	body, err := json.Marshal(update)
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %v", err)
 	}
@@ -187,17 +331,32 @@ func (hm *HealthManager) updateNodeState(node *cluster.NodeInfo, state cluster.S
 	return nil
 }
 
-func (hm *HealthManager) notifyNewLeader(node *cluster.NodeInfo) error {
-	return hm.updateNodeState(node, cluster.NodeTypeMaster, node.ID)
+func (hm *HealthManager) notifyNewLeader(node *cluster.NodeInfo, epoch, forkSeq int64) error {
+	return hm.updateNodeState(node, api.StateUpdate{
+		State:         cluster.NodeTypeMaster,
+		LeaderID:      node.ID,
+		LeaderAddress: node.HostPort(),
+		Epoch:         epoch,
+		ForkSeq:       forkSeq,
+	})
 }
 
-func (hm *HealthManager) notifyFollowers(followers []*cluster.NodeInfo, newLeader *cluster.NodeInfo) error {
+// notifyFollowers points the followers at the new leader. Records past forkSeq
+// never reached it, followers holding them resync from it.
+func (hm *HealthManager) notifyFollowers(followers []*cluster.NodeInfo, newLeader *cluster.NodeInfo, epoch, forkSeq int64) error {
 	var lastErr error
 	for _, follower := range followers {
 		if follower.ID == newLeader.ID {
 			continue // Skip the new leader
 		}
-		if err := hm.updateNodeState(follower, cluster.NodeTypeFollower, newLeader.ID); err != nil {
+		update := api.StateUpdate{
+			State:         cluster.NodeTypeFollower,
+			LeaderID:      newLeader.ID,
+			LeaderAddress: newLeader.HostPort(),
+			Epoch:         epoch,
+			ForkSeq:       forkSeq,
+		}
+		if err := hm.updateNodeState(follower, update); err != nil {
 			logrus.WithError(err).WithField("follower", follower.ID).Warn("Failed to notify follower about leader change")
 			lastErr = err
 		}
@@ -205,6 +364,38 @@ func (hm *HealthManager) notifyFollowers(followers []*cluster.NodeInfo, newLeade
 	return lastErr
 }
 
+// fenceNode stops a master from accepting writes, or lets it accept them
+// again, and returns its last WAL sequence.
+func (hm *HealthManager) fenceNode(node *cluster.NodeInfo, fenced bool) (int64, error) {
+	client := &http.Client{Timeout: hm.timeout}
+	body, err := json.Marshal(api.FenceRequest{Fenced: fenced})
+	if err != nil {
+		return -1, fmt.Errorf("failed to marshal fence request: %v", err)
+	}
+
+	resp, err := client.Post(
+		fmt.Sprintf("http://%s:%d/fence", node.Address.IP.String(), node.Address.Port),
+		"application/json",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return -1, fmt.Errorf("node returned non-200 status: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		LastSeq int64 `json:"last_seq"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return -1, err
+	}
+	return result.LastSeq, nil
+}
+
 func (hm *HealthManager) getNodeLastSeq(node *cluster.NodeInfo) (int64, error) {
 	client := &http.Client{Timeout: hm.timeout}
 	resp, err := client.Get(fmt.Sprintf("http://%s:%d/last-seq", node.Address.IP.String(), node.Address.Port))
@@ -226,3 +417,55 @@ func (hm *HealthManager) getNodeLastSeq(node *cluster.NodeInfo) (int64, error) {
 
 	return result.LastSeq, nil
 }
+
+// waitForCatchUp polls the nodes until at least need of them have applied the
+// WAL up to targetSeq. The caught-up nodes are returned, most advanced first.
+func (hm *HealthManager) waitForCatchUp(nodes []*cluster.NodeInfo, targetSeq int64, need int) ([]*cluster.NodeInfo, error) {
+	deadline := time.Now().Add(hm.catchUpTimeout)
+	for {
+		seqs := make(map[int]int64)
+		var caughtUp []*cluster.NodeInfo
+		for _, node := range nodes {
+			seq, err := hm.getNodeLastSeq(node)
+			if err != nil {
+				logrus.WithError(err).WithField("node", node.ID).Debug("Failed to get node last sequence number")
+				continue
+			}
+			if seq >= targetSeq {
+				seqs[node.ID] = seq
+				caughtUp = append(caughtUp, node)
+			}
+		}
+
+		if len(caughtUp) >= need {
+			sort.SliceStable(caughtUp, func(i, j int) bool {
+				return seqs[caughtUp[i].ID] > seqs[caughtUp[j].ID]
+			})
+			return caughtUp, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("only %d of %d replicas reached seq %d within %v", len(caughtUp), need, targetSeq, hm.catchUpTimeout)
+		}
+		time.Sleep(hm.interval)
+	}
+}
+
+// stopNode tells a node to stop serving client requests.
+func (hm *HealthManager) stopNode(node *cluster.NodeInfo) error {
+	client := &http.Client{Timeout: hm.timeout}
+	resp, err := client.Post(
+		fmt.Sprintf("http://%s:%d/decommission", node.Address.IP.String(), node.Address.Port),
+		"application/json",
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node returned non-200 status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// fakeNode answers the controller's leader election calls for a node whose
+// WAL reached lastSeq. Role changes are answered with status.
+func fakeNode(t *testing.T, node *cluster.NodeInfo, lastSeq int64, status int, lastSeqCalled func()) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/last-seq", func(w http.ResponseWriter, r *http.Request) {
+		if lastSeqCalled != nil {
+			lastSeqCalled()
+		}
+		json.NewEncoder(w).Encode(map[string]int64{"last_seq": lastSeq})
+	})
+	mux.HandleFunc("/update-state", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	node.Address = *server.Listener.Addr().(*net.TCPAddr)
+	node.Status = cluster.NodeStatusActive
+}
+
+// newElectionCluster returns a shard of a failed master and two followers.
+func newElectionCluster(t *testing.T) (*NodeManager, *HealthManager) {
+	t.Helper()
+	cfg := &config.KvControllerConfig{}
+	cfg.Discovery.HeartbeatIntervalMs = 100
+	cfg.Discovery.FailureTimeoutMs = 1000
+	nm := NewNodeManager(1, 3, cfg)
+	hm := NewHealthManager(nm, cfg)
+	nm.healthManager = hm
+	nm.ShardMap[0].Master.Status = cluster.NodeStatusFailed
+	return nm, hm
+}
+
+func TestElectionSkipsAFollowerThatCannotBeTold(t *testing.T) {
+	nm, hm := newElectionCluster(t)
+	shard := nm.ShardMap[0]
+	ahead, behind := shard.Followers[0], shard.Followers[1]
+	fakeNode(t, ahead, 10, http.StatusInternalServerError, nil)
+	fakeNode(t, behind, 5, http.StatusOK, nil)
+	epoch := shard.Epoch
+
+	if got := nm.failedMasters(); len(got) != 1 || got[0] != 0 {
+		t.Fatalf("shards with a failed master %v, want [0]", got)
+	}
+	hm.electNewLeader(0, "master failed")
+
+	if shard.Master != behind || shard.Epoch != epoch+1 || shard.ForkSeq != 5 {
+		t.Fatalf("master %d in epoch %d from seq %d, want %d in epoch %d from seq 5", shard.Master.ID, shard.Epoch, shard.ForkSeq, behind.ID, epoch+1)
+	}
+	if ahead.StoreNodeType != cluster.NodeTypeFollower || ahead.LeaderID != behind.ID {
+		t.Fatalf("the follower that refused leads %d as %s", ahead.LeaderID, ahead.StoreNodeType)
+	}
+	if got := nm.failedMasters(); len(got) != 0 {
+		t.Fatalf("shards with a failed master %v after the election", got)
+	}
+}
+
+func TestElectionDoesNotHoldTheNodeManagerLock(t *testing.T) {
+	nm, hm := newElectionCluster(t)
+	shard := nm.ShardMap[0]
+	asked, release := make(chan struct{}, 2), make(chan struct{})
+	for _, f := range shard.Followers {
+		fakeNode(t, f, 1, http.StatusOK, func() {
+			asked <- struct{}{}
+			<-release
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		hm.electNewLeader(0, "master failed")
+		close(done)
+	}()
+	<-asked
+
+	// A heartbeat needs the lock while the followers are being asked
+	locked := make(chan struct{})
+	go func() {
+		nm.GetNodeInfo(0)
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("the node manager stayed locked during the election")
+	}
+	close(release)
+	<-done
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// maxReportedPhi caps the suspicion level shown in health reports.
+const maxReportedPhi = 100
+
+// healthRank orders verdicts so the worst of several can be picked.
+var healthRank = map[api.HealthStatus]int{
+	api.HealthGreen:  0,
+	api.HealthYellow: 1,
+	api.HealthRed:    2,
+}
+
+func worseHealth(a, b api.HealthStatus) api.HealthStatus {
+	if healthRank[b] > healthRank[a] {
+		return b
+	}
+	return a
+}
+
+// Report aggregates the state of every shard and registered node. The cluster
+// is as healthy as its least healthy shard.
+func (hm *HealthManager) Report() api.ClusterHealth {
+	topology := hm.nodeManager.Topology()
+	now := time.Now()
+
+	report := api.ClusterHealth{
+		Status:   api.HealthGreen,
+		Time:     now,
+		Shards:   make([]api.ShardHealth, 0, len(topology.Shards)),
+		Nodes:    make([]api.NodeHealth, 0),
+		Warnings: hm.nodeManager.PlacementWarnings(),
+	}
+	if len(topology.Shards) == 0 {
+		report.Status = api.HealthRed
+	}
+
+	for _, shard := range topology.Shards {
+		health := hm.shardHealth(shard)
+		report.Status = worseHealth(report.Status, health.Status)
+		report.Shards = append(report.Shards, health)
+	}
+
+	for _, node := range hm.nodeManager.nodeSnapshot() {
+		if node.Status == cluster.NodeStatusUnregistered || node.Status == cluster.NodeStatusDecommissioned {
+			continue
+		}
+		health := api.NodeHealth{
+			ID:           node.ID,
+			ShardKey:     node.ShardKey,
+			Status:       node.Status,
+			Role:         node.StoreNodeType,
+			LastSeq:      node.LastSeq,
+			Lag:          node.Lag,
+			OpenBreakers: hm.openBreakers(node.ID),
+		}
+		if last, phi, ok := hm.heartbeatState(node.ID, now); ok {
+			health.LastHeartbeat = &last
+			health.Phi = phi
+		}
+		report.Nodes = append(report.Nodes, health)
+	}
+	return report
+}
+
+// shardHealth rates one shard. It is red without a serving master and yellow
+// while fewer than the configured number of replicas are in sync.
+func (hm *HealthManager) shardHealth(shard api.ShardTopology) api.ShardHealth {
+	health := api.ShardHealth{
+		ShardKey: shard.ShardKey,
+		Status:   api.HealthGreen,
+		Epoch:    shard.Epoch,
+		MasterID: -1,
+		Replicas: shard.Replicas,
+	}
+
+	members := shard.Followers
+	if shard.Master != nil {
+		health.MasterID = shard.Master.ID
+		members = append([]cluster.NodeInfo{*shard.Master}, members...)
+	}
+	for _, member := range members {
+		if isServing(member.Status) || member.Status == cluster.NodeStatusSyncing {
+			health.MaxLag = max(health.MaxLag, member.Lag)
+		}
+		if isServing(member.Status) && member.Lag == 0 {
+			health.InSync++
+		}
+	}
+
+	switch {
+	case shard.Master == nil || !isServing(shard.Master.Status):
+		health.Status = api.HealthRed
+		health.Issues = append(health.Issues, "no serving master")
+	case shard.Master.Status == cluster.NodeStatusSuspect:
+		health.Status = api.HealthYellow
+		health.Issues = append(health.Issues, fmt.Sprintf("master %d is suspected", shard.Master.ID))
+	}
+	if shard.Master != nil && isServing(shard.Master.Status) {
+		// The master looks alive to the controller but not to the routers
+		if open := hm.openBreakers(shard.Master.ID); len(open) > 0 {
+			health.Status = worseHealth(health.Status, api.HealthYellow)
+			health.Issues = append(health.Issues, fmt.Sprintf("circuit breaker to master %d is open on %d load balancer(s)", shard.Master.ID, len(open)))
+		}
+	}
+	health.HasMaster = health.Status != api.HealthRed
+
+	if health.InSync < health.Replicas {
+		health.Status = worseHealth(health.Status, api.HealthYellow)
+		health.Issues = append(health.Issues, fmt.Sprintf("%d of %d replicas in sync", health.InSync, health.Replicas))
+	}
+	return health
+}
+
+// heartbeatState returns when a node was last heard from and its current
+// suspicion level, without starting to track unknown nodes.
+func (hm *HealthManager) heartbeatState(nodeID int, now time.Time) (time.Time, float64, bool) {
+	hm.heartbeatMu.Lock()
+	hb, ok := hm.heartbeats[nodeID]
+	hm.heartbeatMu.Unlock()
+	if !ok {
+		return time.Time{}, 0, false
+	}
+
+	// phi reaches infinity for nodes that are long gone, which JSON cannot carry
+	return hb.detector.lastHeartbeat(), min(hb.detector.phi(now), maxReportedPhi), true
+}
+
+// Ready reports whether every shard has a serving master, so routers are given
+// a complete topology. The reason explains a negative answer.
+func (hm *HealthManager) Ready() (bool, string) {
+	report := hm.Report()
+	if len(report.Shards) == 0 {
+		return false, "no shards"
+	}
+	for _, shard := range report.Shards {
+		if !shard.HasMaster {
+			return false, fmt.Sprintf("shard %d has no serving master", shard.ShardKey)
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// shardTopKeys is the number of hot keys listed per shard.
+const shardTopKeys = 5
+
+// dominantKeyShare is the share of a shard's requests above which a single key,
+// and not the shard's key range, is the problem.
+const dominantKeyShare = 0.5
+
+// HotspotDetector finds shards and keys that carry far more requests than the
+// rest of the cluster from the load nodes report in their heartbeats.
+type HotspotDetector struct {
+	nodeManager   *NodeManager
+	healthManager *HealthManager
+	loadFactor    float64
+	minOpsPerSec  float64
+}
+
+func NewHotspotDetector(nodeManager *NodeManager, healthManager *HealthManager, cfg *config.KvControllerConfig) *HotspotDetector {
+	loadFactor := cfg.Hotspots.LoadFactor
+	if loadFactor <= 1 {
+		loadFactor = 2
+	}
+	return &HotspotDetector{
+		nodeManager:   nodeManager,
+		healthManager: healthManager,
+		loadFactor:    loadFactor,
+		minOpsPerSec:  cfg.Hotspots.MinOpsPerSec,
+	}
+}
+
+// Report lists every shard by request rate, busiest first, and the limit most
+// requested keys of the cluster.
+func (hd *HotspotDetector) Report(limit int) api.HotspotReport {
+	topology := hd.nodeManager.Topology()
+	report := api.HotspotReport{
+		LoadFactor: hd.loadFactor,
+		Shards:     make([]api.ShardLoad, 0, len(topology.Shards)),
+		Keys:       make([]api.HotKey, 0),
+	}
+
+	var total float64
+	for _, shard := range topology.Shards {
+		load := hd.shardLoad(shard)
+		total += load.OpsPerSec
+		for _, key := range load.TopKeys {
+			report.Keys = append(report.Keys, api.HotKey{KeyLoad: key, ShardKey: shard.ShardKey})
+		}
+		if len(load.TopKeys) > shardTopKeys {
+			load.TopKeys = load.TopKeys[:shardTopKeys]
+		}
+		report.Shards = append(report.Shards, load)
+	}
+	if len(report.Shards) > 0 {
+		report.AverageOpsPerSec = total / float64(len(report.Shards))
+	}
+
+	for i := range report.Shards {
+		shard := &report.Shards[i]
+		shard.Hot = report.AverageOpsPerSec > 0 &&
+			shard.OpsPerSec >= hd.loadFactor*report.AverageOpsPerSec &&
+			shard.OpsPerSec >= hd.minOpsPerSec
+		if shard.Hot {
+			shard.Recommendation = recommend(shard, topology.Partitions)
+		} else {
+			shard.SplitKey = ""
+		}
+	}
+
+	sort.Slice(report.Shards, func(i, j int) bool {
+		return report.Shards[i].OpsPerSec > report.Shards[j].OpsPerSec
+	})
+	sort.Slice(report.Keys, func(i, j int) bool {
+		return report.Keys[i].OpsPerSec > report.Keys[j].OpsPerSec
+	})
+	if limit > 0 && len(report.Keys) > limit {
+		report.Keys = report.Keys[:limit]
+	}
+	return report
+}
+
+// shardLoad sums the load reported by the serving members of a shard. The hot
+// keys of all members are merged, busiest first.
+func (hd *HotspotDetector) shardLoad(shard api.ShardTopology) api.ShardLoad {
+	load := api.ShardLoad{ShardKey: shard.ShardKey, MasterID: -1, TopKeys: make([]api.KeyLoad, 0)}
+	members := append([]cluster.NodeInfo(nil), shard.Followers...)
+	if shard.Master != nil {
+		load.MasterID = shard.Master.ID
+		members = append(members, *shard.Master)
+	}
+
+	keys := make(map[string]*api.KeyLoad)
+	for _, member := range members {
+		if !isServing(member.Status) {
+			continue
+		}
+		hb, _, ok := hd.healthManager.lastHeartbeat(member.ID)
+		if !ok {
+			continue
+		}
+		load.OpsPerSec += hb.Load.OpsPerSec
+		if member.ID == load.MasterID {
+			load.Keys = hb.Load.Keys
+		}
+		for _, key := range hb.Load.HotKeys {
+			if merged, ok := keys[key.Key]; ok {
+				merged.OpsPerSec += key.OpsPerSec
+				merged.Error += key.Error
+				continue
+			}
+			k := key
+			keys[key.Key] = &k
+		}
+	}
+
+	for _, key := range keys {
+		load.TopKeys = append(load.TopKeys, *key)
+	}
+	sort.Slice(load.TopKeys, func(i, j int) bool {
+		return load.TopKeys[i].OpsPerSec > load.TopKeys[j].OpsPerSec
+	})
+	load.SplitKey = splitKey(load.TopKeys)
+	return load
+}
+
+// splitKey returns the hot key at which the sampled load of a shard is halved
+// when its keys are taken in order.
+func splitKey(hot []api.KeyLoad) string {
+	if len(hot) < 2 {
+		return ""
+	}
+	byKey := append([]api.KeyLoad(nil), hot...)
+	sort.Slice(byKey, func(i, j int) bool {
+		return byKey[i].Key < byKey[j].Key
+	})
+
+	var total float64
+	for _, key := range byKey {
+		total += key.OpsPerSec
+	}
+	var below float64
+	for i, key := range byKey {
+		// The split key opens the upper half, so it is never the first key
+		if i > 0 && below+key.OpsPerSec/2 >= total/2 {
+			return key.Key
+		}
+		below += key.OpsPerSec
+	}
+	return byKey[len(byKey)-1].Key
+}
+
+// recommend suggests how to relieve a hot shard.
+func recommend(shard *api.ShardLoad, partitions partition.Map) string {
+	if len(shard.TopKeys) > 0 && shard.TopKeys[0].OpsPerSec >= dominantKeyShare*shard.OpsPerSec {
+		shard.SplitKey = ""
+		return fmt.Sprintf("key %q carries %.0f%% of the requests, splitting cannot spread a single key; cache it or spread it over several keys",
+			shard.TopKeys[0].Key, 100*shard.TopKeys[0].OpsPerSec/shard.OpsPerSec)
+	}
+	if partitions.Mode != partition.ModeRange {
+		shard.SplitKey = ""
+		return "hash partitioning cannot split a single shard; raise its replicas to spread reads or move its leadership to a less busy host"
+	}
+	if shard.SplitKey != "" {
+		return fmt.Sprintf("split the shard at %q", shard.SplitKey)
+	}
+	return "split the shard at its median key"
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+// keyspaceName is the form of a valid keyspace name.
+var keyspaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// createKeyspace adds a keyspace to the catalog.
+func (nm *NodeManager) createKeyspace(name string, quota api.KeyspaceQuota) (api.Keyspace, error) {
+	if !keyspaceName.MatchString(name) {
+		return api.Keyspace{}, fmt.Errorf("invalid keyspace name %q: use up to 63 lowercase letters, digits, '-' and '_'", name)
+	}
+	if quota.MaxKeys < 0 || quota.MaxBytes < 0 || quota.MaxOpsPerSec < 0 {
+		return api.Keyspace{}, fmt.Errorf("invalid quota: limits must not be negative")
+	}
+
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if _, exists := nm.keyspaces[name]; exists {
+		return api.Keyspace{}, fmt.Errorf("keyspace %q already exists", name)
+	}
+	keyspace := api.Keyspace{Name: name, Quota: quota, Created: time.Now()}
+	nm.keyspaces[name] = keyspace
+	nm.keyspacesVersion++
+	nm.notifyTopologyChange()
+	nm.events.Record(api.Event{
+		Type:     api.EventKeyspaceCreated,
+		NodeID:   -1,
+		ShardKey: -1,
+		Actor:    actorAdmin,
+		Details: map[string]string{
+			"keyspace":        name,
+			"max_keys":        fmt.Sprint(quota.MaxKeys),
+			"max_bytes":       fmt.Sprint(quota.MaxBytes),
+			"max_ops_per_sec": fmt.Sprint(quota.MaxOpsPerSec),
+		},
+	})
+	return keyspace, nil
+}
+
+// dropKeyspace removes a keyspace from the catalog, nodes delete its data when
+// they receive the new catalog.
+func (nm *NodeManager) dropKeyspace(name string) error {
+	if name == api.DefaultKeyspace {
+		return fmt.Errorf("cannot drop the default keyspace")
+	}
+
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if _, exists := nm.keyspaces[name]; !exists {
+		return fmt.Errorf("keyspace %q not found", name)
+	}
+	delete(nm.keyspaces, name)
+	nm.keyspacesVersion++
+	nm.notifyTopologyChange()
+	nm.events.Record(api.Event{
+		Type:     api.EventKeyspaceDropped,
+		NodeID:   -1,
+		ShardKey: -1,
+		Actor:    actorAdmin,
+		Details:  map[string]string{"keyspace": name},
+	})
+	return nil
+}
+
+// keyspaceList returns the keyspaces by name. Must be called with the lock held.
+func (nm *NodeManager) keyspaceList() []api.Keyspace {
+	keyspaces := make([]api.Keyspace, 0, len(nm.keyspaces))
+	for _, keyspace := range nm.keyspaces {
+		keyspaces = append(keyspaces, keyspace)
+	}
+	sort.Slice(keyspaces, func(i, j int) bool {
+		return keyspaces[i].Name < keyspaces[j].Name
+	})
+	return keyspaces
+}
+
+// KeyspaceCatalog returns the versioned catalog handed to nodes.
+func (nm *NodeManager) KeyspaceCatalog() api.KeyspaceCatalog {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	return api.KeyspaceCatalog{Version: nm.keyspacesVersion, Keyspaces: nm.keyspaceList()}
+}
+
+// shardOf returns the shard of a node, -1 for spares and unknown nodes.
+func (nm *NodeManager) shardOf(nodeID int) int {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	if nodeID < 0 || nodeID >= len(nm.Nodes) {
+		return -1
+	}
+	return nm.Nodes[nodeID].ShardKey
+}
+
+// masters returns the master of every shard by shard key.
+func (nm *NodeManager) masters() map[int]int {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	masters := make(map[int]int, len(nm.ShardMap))
+	for key, shardInfo := range nm.ShardMap {
+		if shardInfo.Master != nil {
+			masters[key] = shardInfo.Master.ID
+		}
+	}
+	return masters
+}
+
+// keyspaceUsage sums the usage of every keyspace over the shard masters as of
+// their last heartbeat, leaving out excludeShard. Shard keys are never
+// negative, so -1 counts every shard.
+func (hm *HealthManager) keyspaceUsage(excludeShard int) map[string]api.KeyspaceUsage {
+	usage := make(map[string]api.KeyspaceUsage)
+	for shardKey, masterID := range hm.nodeManager.masters() {
+		if shardKey == excludeShard {
+			continue
+		}
+		hb, _, ok := hm.lastHeartbeat(masterID)
+		if !ok {
+			continue
+		}
+		for name, u := range hb.Load.Keyspaces {
+			total := usage[name]
+			total.Keys += u.Keys
+			total.Bytes += u.Bytes
+			usage[name] = total
+		}
+	}
+	return usage
+}
+
+// CreateKeyspace adds a keyspace and hands the new catalog to the nodes.
+func (c *KvController) CreateKeyspace(name string, quota api.KeyspaceQuota) (api.Keyspace, error) {
+	keyspace, err := c.NodeManager.createKeyspace(name, quota)
+	if err != nil {
+		return api.Keyspace{}, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"keyspace": name,
+		"quota":    fmt.Sprintf("%+v", quota),
+	}).Info("Keyspace created")
+	c.publishKeyspaces()
+	return keyspace, nil
+}
+
+// DropKeyspace removes a keyspace, the nodes delete its keys.
+func (c *KvController) DropKeyspace(name string) error {
+	if err := c.NodeManager.dropKeyspace(name); err != nil {
+		return err
+	}
+	logrus.WithField("keyspace", name).Info("Keyspace dropped")
+	c.publishKeyspaces()
+	return nil
+}
+
+// GetKeyspaces lists the keyspaces with their usage across the cluster.
+func (c *KvController) GetKeyspaces() []api.Keyspace {
+	keyspaces := c.NodeManager.KeyspaceCatalog().Keyspaces
+	usage := c.HealthManager.keyspaceUsage(-1)
+	for i := range keyspaces {
+		u := usage[keyspaces[i].Name]
+		keyspaces[i].Usage = &u
+	}
+	return keyspaces
+}
+
+// GetKeyspace returns one keyspace with its usage across the cluster.
+func (c *KvController) GetKeyspace(name string) (api.Keyspace, error) {
+	for _, keyspace := range c.GetKeyspaces() {
+		if keyspace.Name == name {
+			return keyspace, nil
+		}
+	}
+	return api.Keyspace{}, fmt.Errorf("keyspace %q not found", name)
+}
+
+func (c *KvController) GetKeyspaceCatalog() api.KeyspaceCatalog {
+	return c.NodeManager.KeyspaceCatalog()
+}
+
+// publishKeyspaces pushes the catalog to every live node so the change applies
+// at once. Nodes that miss it fetch it after their next heartbeat.
+func (c *KvController) publishKeyspaces() {
+	catalog := c.NodeManager.KeyspaceCatalog()
+	for _, node := range c.NodeManager.nodeSnapshot() {
+		switch node.Status {
+		case cluster.NodeStatusUnregistered, cluster.NodeStatusFailed, cluster.NodeStatusDecommissioned:
+			continue
+		}
+		if err := c.RangeManager.postJSON(node, "/keyspaces", catalog); err != nil {
+			logrus.WithError(err).WithField("node", node.ID).Warn("Failed to push the keyspace catalog, the node syncs on its next heartbeat")
+		}
+	}
+}
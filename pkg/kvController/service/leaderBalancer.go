@@ -0,0 +1,325 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+// maxBalancerHistory is the number of recent moves kept for the status endpoint.
+const maxBalancerHistory = 20
+
+// LeaderBalancer periodically spreads shard leadership, and with it the write
+// traffic, evenly over the hosts of the cluster. Leadership is only handed to
+// followers that have caught up with their master.
+type LeaderBalancer struct {
+	nodeManager   *NodeManager
+	healthManager *HealthManager
+	// move transfers leadership of a shard through the controller
+	move          func(shardID, nodeID int, reason string) error
+	interval      time.Duration
+	maxMoves      int
+	cooldown      time.Duration
+	loadImbalance float64
+
+	mu        sync.Mutex
+	paused    bool
+	dryRun    bool
+	lastRun   time.Time
+	lastMoved map[int]time.Time
+	hosts     []api.BalancerHost
+	history   []api.BalancerMove
+	stopChan  chan struct{}
+}
+
+func NewLeaderBalancer(nodeManager *NodeManager, healthManager *HealthManager, move func(shardID, nodeID int, reason string) error, cfg *config.KvControllerConfig) *LeaderBalancer {
+	interval := time.Duration(cfg.Balancer.IntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	maxMoves := cfg.Balancer.MaxMovesPerRound
+	if maxMoves <= 0 {
+		maxMoves = 1
+	}
+	loadImbalance := cfg.Balancer.LoadImbalance
+	if loadImbalance <= 1 {
+		loadImbalance = 1.5
+	}
+
+	return &LeaderBalancer{
+		nodeManager:   nodeManager,
+		healthManager: healthManager,
+		move:          move,
+		interval:      interval,
+		maxMoves:      maxMoves,
+		cooldown:      time.Duration(cfg.Balancer.ShardCooldownMs) * time.Millisecond,
+		loadImbalance: loadImbalance,
+		paused:        !cfg.Balancer.Enabled,
+		dryRun:        cfg.Balancer.DryRun,
+		lastMoved:     make(map[int]time.Time),
+		stopChan:      make(chan struct{}),
+	}
+}
+
+func (lb *LeaderBalancer) Start() {
+	go lb.balanceLoop()
+}
+
+func (lb *LeaderBalancer) Stop() {
+	close(lb.stopChan)
+}
+
+func (lb *LeaderBalancer) balanceLoop() {
+	ticker := time.NewTicker(lb.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lb.stopChan:
+			return
+		case <-ticker.C:
+			lb.balance()
+		}
+	}
+}
+
+// Status returns the current settings, host loads and recent moves.
+func (lb *LeaderBalancer) Status() api.BalancerStatus {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	return api.BalancerStatus{
+		Paused:  lb.paused,
+		DryRun:  lb.dryRun,
+		LastRun: lb.lastRun,
+		Hosts:   append([]api.BalancerHost{}, lb.hosts...),
+		Moves:   append([]api.BalancerMove{}, lb.history...),
+	}
+}
+
+// Update pauses, resumes or switches the dry-run mode of the balancer.
+func (lb *LeaderBalancer) Update(settings api.BalancerSettings) api.BalancerStatus {
+	lb.mu.Lock()
+	if settings.Paused != nil {
+		lb.paused = *settings.Paused
+	}
+	if settings.DryRun != nil {
+		lb.dryRun = *settings.DryRun
+	}
+	logrus.WithFields(logrus.Fields{
+		"paused":  lb.paused,
+		"dry_run": lb.dryRun,
+	}).Info("Leader balancer settings changed")
+	lb.mu.Unlock()
+
+	return lb.Status()
+}
+
+// hostState is the leadership carried by a host while a round is planned.
+type hostState struct {
+	name    string
+	masters int
+	load    float64
+}
+
+func (lb *LeaderBalancer) balance() {
+	lb.mu.Lock()
+	paused, dryRun := lb.paused, lb.dryRun
+	lb.mu.Unlock()
+
+	shards := lb.nodeManager.rangeShards()
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].ShardKey < shards[j].ShardKey
+	})
+
+	hosts := make(map[string]*hostState)
+	loads := make(map[int]float64)
+	for _, shard := range shards {
+		for _, member := range shard.Members {
+			if !isServing(member.Status) {
+				continue
+			}
+			name := hostOf(member)
+			if hosts[name] == nil {
+				hosts[name] = &hostState{name: name}
+			}
+		}
+		if !isServing(shard.Master.Status) {
+			continue
+		}
+		if hb, _, ok := lb.healthManager.lastHeartbeat(shard.Master.ID); ok {
+			loads[shard.ShardKey] = hb.Load.OpsPerSec
+		}
+		host := hosts[hostOf(shard.Master)]
+		host.masters++
+		host.load += loads[shard.ShardKey]
+	}
+
+	// Report the hosts as they are, planning below changes them
+	summary := make([]api.BalancerHost, 0, len(hosts))
+	for _, host := range hosts {
+		summary = append(summary, api.BalancerHost{Host: host.name, Masters: host.masters, WriteLoad: host.load})
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		return summary[i].Host < summary[j].Host
+	})
+
+	var moves []api.BalancerMove
+	if !paused {
+		moved := make(map[int]bool)
+		now := time.Now()
+		lb.mu.Lock()
+		for key, at := range lb.lastMoved {
+			if now.Sub(at) < lb.cooldown {
+				moved[key] = true
+			}
+		}
+		lb.mu.Unlock()
+
+		for len(moves) < lb.maxMoves {
+			move, ok := lb.nextMove(shards, hosts, loads, moved)
+			if !ok {
+				break
+			}
+			moved[move.ShardKey] = true
+			moves = append(moves, move)
+		}
+	}
+
+	for i := range moves {
+		moves[i].DryRun = dryRun
+		moves[i].Time = time.Now()
+		fields := logrus.Fields{
+			"shard":   moves[i].ShardKey,
+			"from":    moves[i].From,
+			"to":      moves[i].To,
+			"reason":  moves[i].Reason,
+			"dry_run": dryRun,
+		}
+		if dryRun {
+			logrus.WithFields(fields).Info("Leader balancer would move shard leadership")
+			continue
+		}
+		if err := lb.move(moves[i].ShardKey, moves[i].To, moves[i].Reason); err != nil {
+			moves[i].Error = err.Error()
+			logrus.WithError(err).WithFields(fields).Warn("Leader balancer failed to move shard leadership")
+			continue
+		}
+		logrus.WithFields(fields).Info("Leader balancer moved shard leadership")
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.lastRun = time.Now()
+	lb.hosts = summary
+	for _, move := range moves {
+		if !move.DryRun && move.Error == "" {
+			lb.lastMoved[move.ShardKey] = move.Time
+		}
+		lb.history = append(lb.history, move)
+	}
+	if len(lb.history) > maxBalancerHistory {
+		lb.history = lb.history[len(lb.history)-maxBalancerHistory:]
+	}
+}
+
+// nextMove picks one leadership transfer and applies it to hosts. Evening out
+// the number of masters comes first, then moving write load off a host that
+// carries far more than the average.
+func (lb *LeaderBalancer) nextMove(shards []rangeShard, hosts map[string]*hostState, loads map[int]float64, moved map[int]bool) (api.BalancerMove, bool) {
+	type candidate struct {
+		shard  rangeShard
+		target cluster.NodeInfo
+		src    *hostState
+		dst    *hostState
+	}
+	var candidates []candidate
+	for _, shard := range shards {
+		if moved[shard.ShardKey] || !isServing(shard.Master.Status) {
+			continue
+		}
+		src := hosts[hostOf(shard.Master)]
+		for _, member := range shard.Members {
+			// Only followers that are fully caught up may take over
+			if member.ID == shard.Master.ID || member.Status != cluster.NodeStatusActive || member.Lag > 0 {
+				continue
+			}
+			dst := hosts[hostOf(member)]
+			if dst == nil || dst == src {
+				continue
+			}
+			candidates = append(candidates, candidate{shard: shard, target: member, src: src, dst: dst})
+		}
+	}
+
+	apply := func(c candidate, reason string) (api.BalancerMove, bool) {
+		load := loads[c.shard.ShardKey]
+		c.src.masters--
+		c.src.load -= load
+		c.dst.masters++
+		c.dst.load += load
+		return api.BalancerMove{
+			ShardKey: c.shard.ShardKey,
+			From:     c.shard.Master.ID,
+			To:       c.target.ID,
+			Reason:   reason,
+		}, true
+	}
+
+	// Masters: move from a host with at least two more masters than the target
+	var best *candidate
+	for i, c := range candidates {
+		if c.src.masters-c.dst.masters < 2 {
+			continue
+		}
+		if best == nil || c.dst.masters < best.dst.masters ||
+			(c.dst.masters == best.dst.masters && c.src.masters > best.src.masters) {
+			best = &candidates[i]
+		}
+	}
+	if best != nil {
+		return apply(*best, "masters")
+	}
+
+	// Write load: only when the busiest host is well above the average, and
+	// only if the move lowers the peak of the two hosts involved
+	var total float64
+	for _, host := range hosts {
+		total += host.load
+	}
+	if len(hosts) == 0 || total == 0 {
+		return api.BalancerMove{}, false
+	}
+	avg := total / float64(len(hosts))
+	var bestPeak float64
+	for i, c := range candidates {
+		if c.src.load < lb.loadImbalance*avg || c.dst.masters >= c.src.masters {
+			continue
+		}
+		load := loads[c.shard.ShardKey]
+		peak := max(c.src.load-load, c.dst.load+load)
+		if peak >= c.src.load {
+			continue
+		}
+		if best == nil || peak < bestPeak {
+			best, bestPeak = &candidates[i], peak
+		}
+	}
+	if best != nil {
+		return apply(*best, "write load")
+	}
+	return api.BalancerMove{}, false
+}
+
+// hostOf names the host a node runs on, by its topology label or else its address.
+func hostOf(node cluster.NodeInfo) string {
+	if node.Topology.Host != "" {
+		return node.Topology.Host
+	}
+	return node.Address.IP.String()
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/sirupsen/logrus"
+)
+
+// A load balancer that has not sent a heartbeat for loadBalancerStaleAfter is
+// listed as stale, after loadBalancerForgetAfter it is dropped from the fleet.
+const (
+	loadBalancerStaleAfter  = 15 * time.Second
+	loadBalancerForgetAfter = 5 * time.Minute
+)
+
+// LoadBalancerFleet keeps the load balancers that registered through their
+// heartbeats. Load balancers hold no state of their own, so the fleet is only
+// reported, never coordinated.
+type LoadBalancerFleet struct {
+	mu            sync.Mutex
+	loadBalancers map[string]api.LoadBalancerInfo
+}
+
+func NewLoadBalancerFleet() *LoadBalancerFleet {
+	return &LoadBalancerFleet{loadBalancers: make(map[string]api.LoadBalancerInfo)}
+}
+
+// Heartbeat records a heartbeat, registering the load balancer on its first.
+func (f *LoadBalancerFleet) Heartbeat(hb api.LoadBalancerHeartbeat) {
+	f.mu.Lock()
+	previous, known := f.loadBalancers[hb.ID]
+	f.loadBalancers[hb.ID] = api.LoadBalancerInfo{LoadBalancerHeartbeat: hb, LastHeartbeat: time.Now()}
+	f.mu.Unlock()
+
+	fields := logrus.Fields{"loadBalancer": hb.ID, "address": hb.Address, "state": hb.State}
+	switch {
+	case !known || !previous.Started.Equal(hb.Started):
+		logrus.WithFields(fields).Info("Load balancer registered")
+	case previous.State != hb.State:
+		logrus.WithFields(fields).Info("Load balancer changed state")
+	}
+}
+
+// Deregister drops a load balancer that shut down.
+func (f *LoadBalancerFleet) Deregister(id string) {
+	f.mu.Lock()
+	_, known := f.loadBalancers[id]
+	delete(f.loadBalancers, id)
+	f.mu.Unlock()
+
+	if known {
+		logrus.WithField("loadBalancer", id).Info("Load balancer deregistered")
+	}
+}
+
+// List returns the fleet by ID, forgetting load balancers gone for long.
+func (f *LoadBalancerFleet) List() []api.LoadBalancerInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fleet := make([]api.LoadBalancerInfo, 0, len(f.loadBalancers))
+	for id, lb := range f.loadBalancers {
+		silence := time.Since(lb.LastHeartbeat)
+		if silence > loadBalancerForgetAfter {
+			delete(f.loadBalancers, id)
+			continue
+		}
+		lb.Stale = silence > loadBalancerStaleAfter
+		fleet = append(fleet, lb)
+	}
+	sort.Slice(fleet, func(i, j int) bool {
+		return fleet[i].ID < fleet[j].ID
+	})
+	return fleet
+}
+
+func (c *KvController) LoadBalancerHeartbeat(hb api.LoadBalancerHeartbeat) {
+	c.LoadBalancers.Heartbeat(hb)
+}
+
+func (c *KvController) DeregisterLoadBalancer(id string) {
+	c.LoadBalancers.Deregister(id)
+}
+
+func (c *KvController) GetLoadBalancers() []api.LoadBalancerInfo {
+	return c.LoadBalancers.List()
+}
@@ -4,10 +4,17 @@ import (
 	"fmt"
 	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
 	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/sirupsen/logrus"
 )
 
 type NodeManager struct {
@@ -18,14 +25,50 @@ type NodeManager struct {
 	ShardMap      map[int]*cluster.ShardInfo
 	timeout       time.Duration
 	healthManager *HealthManager
+	virtualNodes  int
+	mode          partition.Mode
+	partitionMap  partition.Map
+	nextShardKey  int
+	// topologyVersion is bumped on every routing relevant change, watchers
+	// block on topologyChanged which is closed and replaced at the same time.
+	// incarnation tells the versions of this process from those of earlier ones.
+	incarnation     string
+	topologyVersion int64
+	topologyChanged chan struct{}
+	events          *EventLog
+	// keyspaces is the catalog of keyspaces, keyspacesVersion is bumped when it changes
+	keyspaces        map[string]api.Keyspace
+	keyspacesVersion int64
+	// settingsVersion mirrors the version of the runtime settings for the topology
+	settingsVersion int64
 }
 
 func NewNodeManager(partitions int, replicas int, cfg *config.KvControllerConfig) *NodeManager {
+	virtualNodes := cfg.Cluster.VirtualNodes
+	if virtualNodes <= 0 {
+		virtualNodes = partition.DefaultVirtualNodes
+	}
+	mode, err := partition.ParseMode(cfg.Cluster.Partitioning)
+	if err != nil {
+		logrus.Fatalf("invalid cluster config: %v", err)
+	}
+
 	nm := &NodeManager{
-		replicas:   replicas,
-		partitions: partitions,
-		mutex:      sync.Mutex{},
-		timeout:    time.Duration(cfg.Discovery.HeartbeatIntervalMs) * time.Millisecond,
+		replicas:        replicas,
+		partitions:      partitions,
+		mutex:           sync.Mutex{},
+		timeout:         time.Duration(cfg.Discovery.HeartbeatIntervalMs) * time.Millisecond,
+		virtualNodes:    virtualNodes,
+		mode:            mode,
+		nextShardKey:    partitions,
+		incarnation:     strconv.FormatInt(time.Now().UnixNano(), 36),
+		topologyChanged: make(chan struct{}),
+		events:          NewEventLog(cfg.Events.Retention),
+		keyspaces: map[string]api.Keyspace{
+			api.DefaultKeyspace: {Name: api.DefaultKeyspace, Created: time.Now()},
+		},
+		keyspacesVersion: 1,
+		settingsVersion:  1,
 	}
 	nm.initializeNodes()
 	return nm
@@ -59,6 +102,8 @@ func (nm *NodeManager) initializeNodes() {
 				ShardKey:  shardKey,
 				Master:    node,
 				Followers: []*cluster.NodeInfo{},
+				Replicas:  nm.replicas,
+				Epoch:     1,
 			}
 		} else {
 			// Next nodes are replicas
@@ -66,9 +111,87 @@ func (nm *NodeManager) initializeNodes() {
 			shardInfo.Followers = append(shardInfo.Followers, node)
 		}
 	}
+
+	for _, shardInfo := range nm.ShardMap {
+		syncLeaderIDs(shardInfo)
+	}
+
+	if nm.mode == partition.ModeRange {
+		keys := make([]int, 0, len(nm.ShardMap))
+		for key := range nm.ShardMap {
+			keys = append(keys, key)
+		}
+		sort.Ints(keys)
+		for _, r := range partition.EvenRanges(keys) {
+			nm.ShardMap[r.ShardKey].StartKey = r.Start
+			nm.ShardMap[r.ShardKey].EndKey = r.End
+		}
+	}
+	nm.updatePartitionMap()
 }
 
-func (nm *NodeManager) RegisterNode(address string, port int) (*cluster.NodeInfo, error) {
+// updatePartitionMap publishes a new version of the partition map when the set
+// of shards has changed. Must be called with the mutex held.
+func (nm *NodeManager) updatePartitionMap() {
+	shards := make([]int, 0, len(nm.ShardMap))
+	for key := range nm.ShardMap {
+		shards = append(shards, key)
+	}
+	sort.Ints(shards)
+
+	m := partition.Map{
+		Version: nm.partitionMap.Version + 1,
+		Mode:    nm.mode,
+		Shards:  shards,
+	}
+	if nm.mode == partition.ModeRange {
+		for _, key := range shards {
+			shardInfo := nm.ShardMap[key]
+			m.Ranges = append(m.Ranges, partition.Range{
+				ShardKey: key,
+				Start:    shardInfo.StartKey,
+				End:      shardInfo.EndKey,
+			})
+		}
+	} else {
+		m.VirtualNodes = nm.virtualNodes
+	}
+
+	if nm.partitionMap.Version > 0 && slices.Equal(m.Shards, nm.partitionMap.Shards) && slices.Equal(m.Ranges, nm.partitionMap.Ranges) {
+		return
+	}
+	nm.partitionMap = m
+	nm.notifyTopologyChange()
+}
+
+// GetPartitionMap returns a copy of the current partition map.
+func (nm *NodeManager) GetPartitionMap() partition.Map {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	m := nm.partitionMap
+	m.Shards = slices.Clone(nm.partitionMap.Shards)
+	m.Ranges = slices.Clone(nm.partitionMap.Ranges)
+	return m
+}
+
+// syncLeaderIDs points every member of the shard at its current master.
+func syncLeaderIDs(shardInfo *cluster.ShardInfo) {
+	if shardInfo.Master == nil {
+		return
+	}
+	for _, node := range shardInfo.Members() {
+		node.LeaderID = shardInfo.Master.ID
+	}
+}
+
+// isServing reports whether a node with the given status holds an up to date
+// copy of its shard's data. SYNCING nodes are still catching up.
+func isServing(status cluster.NodeStatus) bool {
+	return status == cluster.NodeStatusActive || status == cluster.NodeStatusSuspect
+}
+
+func (nm *NodeManager) RegisterNode(address string, port int, topology cluster.Topology) (*cluster.NodeInfo, error) {
 	// See if there is an empty spot in the nodes list,
 	// unregistered / failed nodes are empty spots
 	ip := net.ParseIP(address)
@@ -91,24 +214,86 @@ func (nm *NodeManager) RegisterNode(address string, port int) (*cluster.NodeInfo
 			if node.Status == cluster.NodeStatusActive {
 				return nil, fmt.Errorf("node %s:%d is already registered.", address, port)
 			}
-			node.Status = cluster.NodeStatusSyncing
-			// TODO: Start syncing data from master.
+			if node.Status == cluster.NodeStatusDecommissioning {
+				return nil, fmt.Errorf("node %s:%d is being decommissioned", address, port)
+			}
+			// The node restarted with an empty store, a master hands its
+			// shard to the most caught-up follower before it rejoins and
+			// resyncs from it
+			node.Topology = topology
+			if shardInfo, exists := nm.ShardMap[node.ShardKey]; exists && shardInfo.Master == node {
+				// The election talks to the followers, heartbeats must not wait on it
+				nm.mutex.Unlock()
+				nm.healthManager.electNewLeader(node.ShardKey, "master restarted with an empty store")
+				nm.mutex.Lock()
+				if shardInfo, exists := nm.ShardMap[node.ShardKey]; exists && shardInfo.Master == node {
+					logrus.WithFields(logrus.Fields{
+						"node":     node.ID,
+						"shardKey": node.ShardKey,
+					}).Error("Master restarted with an empty store and no replica can take over, the data of the shard is lost")
+				}
+			}
+			nm.rejoinNode(node)
+			nm.notifyTopologyChange()
+			nm.events.Record(api.Event{
+				Type:     api.EventNodeRegistered,
+				NodeID:   node.ID,
+				ShardKey: node.ShardKey,
+				Actor:    actorNode,
+				Reason:   "restarted",
+				Details:  map[string]string{"address": addr.String(), "role": string(node.StoreNodeType)},
+			})
 			return node, nil
 		}
 	}
+
+	// Failed followers and free shard slots restore a replica, spare slots only
+	// grow the pool, so they are filled last
+	var shardSlots, spareSlots []*cluster.NodeInfo
 	for _, node := range nm.Nodes {
-		if node.StoreNodeType == cluster.NodeTypeFollower && node.Status == cluster.NodeStatusFailed {
-			node.Address = addr
-			node.Status = cluster.NodeStatusSyncing
-			return node, nil
+		switch {
+		case node.StoreNodeType == cluster.NodeTypeFollower && node.Status == cluster.NodeStatusFailed:
+			shardSlots = append(shardSlots, node)
+		case node.Status == cluster.NodeStatusUnregistered && node.ShardKey >= 0:
+			shardSlots = append(shardSlots, node)
+		case node.Status == cluster.NodeStatusUnregistered:
+			spareSlots = append(spareSlots, node)
 		}
 	}
-	for _, node := range nm.Nodes {
-		if node.Status == cluster.NodeStatusUnregistered {
-			node.Address = addr
-			node.Status = cluster.NodeStatusSyncing
-			return node, nil
+	for _, slots := range [][]*cluster.NodeInfo{shardSlots, spareSlots} {
+		if len(slots) == 0 {
+			continue
+		}
+		node, cost := nm.pickSlot(slots, topology)
+		if cost.worst != cluster.DomainNone {
+			logrus.WithFields(logrus.Fields{
+				"node":     node.ID,
+				"shardKey": node.ShardKey,
+				"shared":   cost.worst.String(),
+			}).Warn("No free slot keeps the node in a separate failure domain from its shard")
+		}
+		node.Address = addr
+		node.Topology = topology
+		node.Status = cluster.NodeStatusSyncing
+		nm.notifyTopologyChange()
+		event := api.Event{
+			Type:     api.EventNodeRegistered,
+			NodeID:   node.ID,
+			ShardKey: node.ShardKey,
+			Actor:    actorNode,
+			Details: map[string]string{
+				"address": addr.String(),
+				"role":    string(node.StoreNodeType),
+				"zone":    topology.Zone,
+				"rack":    topology.Rack,
+				"host":    topology.Host,
+			},
 		}
+		if cost.worst != cluster.DomainNone {
+			event.Reason = "shares its " + topology.Label(cost.worst) + " with its shard"
+		}
+		nm.events.Record(event)
+		return node, nil
 	}
 	return nil, fmt.Errorf("cannot register node at %s:%d: all cluster spots are full", address, port)
 }
@@ -136,6 +321,230 @@ func (nm *NodeManager) GetActiveNodes() []cluster.NodeInfo {
 	return active
 }
 
+// nodeSnapshot returns copies of all node slots.
+func (nm *NodeManager) nodeSnapshot() []cluster.NodeInfo {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	nodes := make([]cluster.NodeInfo, 0, len(nm.Nodes))
+	for _, node := range nm.Nodes {
+		nodes = append(nodes, *node)
+	}
+	return nodes
+}
+
+// failedMasters returns the shards whose master failed and was not replaced
+// although a follower might take over.
+func (nm *NodeManager) failedMasters() []int {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	var shards []int
+	for key, shardInfo := range nm.ShardMap {
+		if shardInfo.Master == nil || shardInfo.Master.Status != cluster.NodeStatusFailed {
+			continue
+		}
+		for _, f := range shardInfo.Followers {
+			if isServing(f.Status) {
+				shards = append(shards, key)
+				break
+			}
+		}
+	}
+	return shards
+}
+
+// GetMonitoredNodes returns the registered nodes whose heartbeats are watched for failures.
+func (nm *NodeManager) GetMonitoredNodes() []cluster.NodeInfo {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	var monitored []cluster.NodeInfo
+	for _, node := range nm.Nodes {
+		if isServing(node.Status) || node.Status == cluster.NodeStatusSyncing {
+			monitored = append(monitored, *node)
+		}
+	}
+	return monitored
+}
+
+// heartbeatReceived updates the controller's view of a node that was heard
+// from. Nodes that come back after being declared failed, or that still act
+// as master of an older epoch, are told to rejoin. SYNCING nodes are promoted
+// to ACTIVE once they caught up with their master. A new topology is published
+// when the lag of a follower of the shard moves to another bucket.
+func (nm *NodeManager) heartbeatReceived(req api.HeartbeatRequest) (api.HeartbeatResponse, error) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if req.NodeID < 0 || req.NodeID >= len(nm.Nodes) {
+		return api.HeartbeatResponse{}, fmt.Errorf("invalid node ID: %d", req.NodeID)
+	}
+	node := nm.Nodes[req.NodeID]
+	shardInfo := nm.ShardMap[node.ShardKey]
+	status, role := node.Status, node.StoreNodeType
+
+	resp := api.HeartbeatResponse{
+		KeyspacesVersion: nm.keyspacesVersion,
+		SettingsVersion:  nm.settingsVersion,
+	}
+	if shardInfo != nil {
+		resp.Epoch = shardInfo.Epoch
+	}
+	switch node.Status {
+	case cluster.NodeStatusUnregistered, cluster.NodeStatusDecommissioned:
+		resp.Status = node.Status
+		return resp, nil
+	case cluster.NodeStatusSuspect:
+		node.Status = cluster.NodeStatusActive
+		logrus.WithField("node", node.ID).Info("Node is no longer suspected")
+		nm.events.Record(api.Event{
+			Type:     api.EventNodeRecovered,
+			NodeID:   node.ID,
+			ShardKey: node.ShardKey,
+			Actor:    actorController,
+			Reason:   "heartbeats resumed",
+		})
+	}
+
+	staleMaster := shardInfo != nil && req.Role == cluster.NodeTypeMaster &&
+		node.StoreNodeType != cluster.NodeTypeMaster && req.Epoch < shardInfo.Epoch
+	if node.Status == cluster.NodeStatusFailed || staleMaster {
+		reason := "rejoined after failure"
+		if staleMaster {
+			reason = "stale master demoted"
+		}
+		rejoin := nm.rejoinNode(node)
+		resp.Rejoin = &rejoin
+		nm.events.Record(api.Event{
+			Type:     api.EventNodeRecovered,
+			NodeID:   node.ID,
+			ShardKey: node.ShardKey,
+			Actor:    actorController,
+			Reason:   reason,
+			Details:  map[string]string{"role": string(rejoin.Role), "epoch": fmt.Sprint(rejoin.Epoch)},
+		})
+	}
+
+	lags := lagBuckets(shardInfo)
+	node.LastSeq = req.LastSeq
+	nm.updateSyncProgress(node, req)
+	if node.Status != status || node.StoreNodeType != role || !slices.Equal(lagBuckets(shardInfo), lags) {
+		nm.notifyTopologyChange()
+	}
+
+	resp.Status = node.Status
+	return resp, nil
+}
+
+// rejoinNode puts a returning node back into its shard as a SYNCING member.
+// It becomes a follower of the current master unless no other node took over
+// the shard while it was gone. A node in maintenance keeps its status, it only
+// leaves maintenance when told to. Must be called with the lock held.
+func (nm *NodeManager) rejoinNode(node *cluster.NodeInfo) api.RejoinInfo {
+	if node.Status != cluster.NodeStatusMaintenance {
+		node.Status = cluster.NodeStatusSyncing
+	}
+	node.Lag = 0
+
+	shardInfo, exists := nm.ShardMap[node.ShardKey]
+	if !exists {
+		node.StoreNodeType = cluster.NodeTypeUnknown
+		logrus.WithField("node", node.ID).Info("Node rejoined the spare pool")
+		return api.RejoinInfo{Role: cluster.NodeTypeUnknown, LeaderID: -1}
+	}
+
+	if shardInfo.Master == nil || shardInfo.Master.ID == node.ID {
+		shardInfo.Master = node
+		node.StoreNodeType = cluster.NodeTypeMaster
+	} else {
+		node.StoreNodeType = cluster.NodeTypeFollower
+		member := false
+		for _, f := range shardInfo.Followers {
+			if f.ID == node.ID {
+				member = true
+				break
+			}
+		}
+		if !member {
+			shardInfo.Followers = append(shardInfo.Followers, node)
+		}
+	}
+	syncLeaderIDs(shardInfo)
+
+	logrus.WithFields(logrus.Fields{
+		"node":     node.ID,
+		"shardKey": shardInfo.ShardKey,
+		"role":     node.StoreNodeType,
+		"epoch":    shardInfo.Epoch,
+		"forkSeq":  shardInfo.ForkSeq,
+	}).Info("Node rejoining shard")
+
+	return api.RejoinInfo{
+		Role:          node.StoreNodeType,
+		LeaderID:      node.LeaderID,
+		LeaderAddress: shardInfo.Master.HostPort(),
+		Epoch:         shardInfo.Epoch,
+		ForkSeq:       shardInfo.ForkSeq,
+	}
+}
+
+// updateSyncProgress recomputes the replication lag of a node from its latest
+// heartbeat and promotes it from SYNCING to ACTIVE once it caught up. Masters
+// and spares are promoted as soon as they confirm their role. A master's
+// heartbeat recomputes the lag of its followers, against the seq they last
+// reported, so lags are at most a heartbeat interval old. Must be called with
+// the lock held.
+func (nm *NodeManager) updateSyncProgress(node *cluster.NodeInfo, req api.HeartbeatRequest) {
+	shardInfo, exists := nm.ShardMap[node.ShardKey]
+	node.Lag = 0
+	if exists && node.StoreNodeType == cluster.NodeTypeFollower && shardInfo.Master != nil {
+		node.Lag = max(shardInfo.Master.LastSeq-node.LastSeq, 0)
+	}
+	if exists && shardInfo.Master == node {
+		for _, f := range shardInfo.Followers {
+			f.Lag = max(node.LastSeq-f.LastSeq, 0)
+		}
+	}
+	if node.Status != cluster.NodeStatusSyncing || req.Role != node.StoreNodeType {
+		return
+	}
+	if exists && req.Epoch != shardInfo.Epoch {
+		return
+	}
+	if node.StoreNodeType == cluster.NodeTypeFollower &&
+		(shardInfo.Master == nil || !isServing(shardInfo.Master.Status) || node.Lag > 0) {
+		return
+	}
+
+	node.Status = cluster.NodeStatusActive
+	logrus.WithFields(logrus.Fields{
+		"node":    node.ID,
+		"role":    node.StoreNodeType,
+		"lastSeq": node.LastSeq,
+	}).Info("Node caught up and is active")
+	nm.events.Record(api.Event{
+		Type:     api.EventNodeActive,
+		NodeID:   node.ID,
+		ShardKey: node.ShardKey,
+		Actor:    actorController,
+		Reason:   "caught up",
+		Details:  map[string]string{"role": string(node.StoreNodeType), "last_seq": fmt.Sprint(node.LastSeq)},
+	})
+}
+
+// lagBuckets returns the lag bucket of every follower of a shard.
+func lagBuckets(shardInfo *cluster.ShardInfo) []int {
+	if shardInfo == nil {
+		return nil
+	}
+	buckets := make([]int, len(shardInfo.Followers))
+	for i, f := range shardInfo.Followers {
+		buckets[i] = cluster.LagBucket(f.Lag)
+	}
+	return buckets
+}
+
 func (nm *NodeManager) GetShardInfo(shardID int) (*cluster.ShardInfo, bool) {
 	nm.mutex.Lock()
 	defer nm.mutex.Unlock()
@@ -148,6 +557,29 @@ func (nm *NodeManager) GetShardInfo(shardID int) (*cluster.ShardInfo, bool) {
 	return shardInfo, true
 }
 
+// shardSnapshot returns a copy of a shard and of its members, for callers
+// that talk to the nodes without holding the lock.
+func (nm *NodeManager) shardSnapshot(shardKey int) (cluster.ShardInfo, bool) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	shardInfo, exists := nm.ShardMap[shardKey]
+	if !exists {
+		return cluster.ShardInfo{}, false
+	}
+	snapshot := *shardInfo
+	if shardInfo.Master != nil {
+		master := *shardInfo.Master
+		snapshot.Master = &master
+	}
+	snapshot.Followers = make([]*cluster.NodeInfo, len(shardInfo.Followers))
+	for i, f := range shardInfo.Followers {
+		follower := *f
+		snapshot.Followers[i] = &follower
+	}
+	return snapshot, true
+}
+
 func (nm *NodeManager) UpdateShardMaster(shardID int, masterID int) error {
 	nm.mutex.Lock()
 	defer nm.mutex.Unlock()
@@ -156,6 +588,43 @@ func (nm *NodeManager) UpdateShardMaster(shardID int, masterID int) error {
 	if !exists {
 		return fmt.Errorf("shard %d not found", shardID)
 	}
+	_, err := nm.changeMaster(shardInfo, shardInfo.Epoch, masterID, shardInfo.ForkSeq, true)
+	return err
+}
+
+// masterChange is a change of a shard's master committed to the shard map.
+// It is kept until the new master took on its role, so the change can be
+// undone when the node cannot be told.
+type masterChange struct {
+	shardKey int
+	// epoch and forkSeq are those of the shard before the change
+	epoch     int64
+	forkSeq   int64
+	oldMaster *cluster.NodeInfo
+	newMaster *cluster.NodeInfo
+	keptOld   bool
+}
+
+// promote makes masterID, a follower of the shard, its master in a new epoch
+// whose history begins at forkSeq. It fails if the shard is no longer in
+// epoch, someone else changed its master since the caller looked. keepOld
+// keeps the old master as a follower, a failed one leaves the shard.
+func (nm *NodeManager) promote(shardKey int, epoch int64, masterID int, forkSeq int64, keepOld bool) (masterChange, error) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	shardInfo, exists := nm.ShardMap[shardKey]
+	if !exists {
+		return masterChange{}, fmt.Errorf("shard %d not found", shardKey)
+	}
+	return nm.changeMaster(shardInfo, epoch, masterID, forkSeq, keepOld)
+}
+
+// changeMaster is promote with the lock held.
+func (nm *NodeManager) changeMaster(shardInfo *cluster.ShardInfo, epoch int64, masterID int, forkSeq int64, keepOld bool) (masterChange, error) {
+	if shardInfo.Epoch != epoch {
+		return masterChange{}, fmt.Errorf("shard %d moved on to epoch %d", shardInfo.ShardKey, shardInfo.Epoch)
+	}
 
 	// Find the target node
 	var targetNode *cluster.NodeInfo
@@ -167,28 +636,440 @@ func (nm *NodeManager) UpdateShardMaster(shardID int, masterID int) error {
 	}
 
 	if targetNode == nil {
-		return fmt.Errorf("node %d not found in shard %d", masterID, shardID)
+		return masterChange{}, fmt.Errorf("node %d not found in shard %d", masterID, shardInfo.ShardKey)
 	}
 
-	// Update the shard's master
-	oldMaster := shardInfo.Master
-	shardInfo.Master = targetNode
-	targetNode.StoreNodeType = cluster.NodeTypeMaster
+	change := masterChange{
+		shardKey:  shardInfo.ShardKey,
+		epoch:     shardInfo.Epoch,
+		forkSeq:   shardInfo.ForkSeq,
+		oldMaster: shardInfo.Master,
+		newMaster: targetNode,
+		keptOld:   keepOld && shardInfo.Master != nil,
+	}
 
 	// Remove the new leader from followers list
-	newFollowers := make([]*cluster.NodeInfo, 0)
+	newFollowers := make([]*cluster.NodeInfo, 0, len(shardInfo.Followers))
 	for _, f := range shardInfo.Followers {
 		if f.ID != targetNode.ID {
 			newFollowers = append(newFollowers, f)
 		}
 	}
+	// Add the old master to followers list if it stays
+	if change.keptOld {
+		shardInfo.Master.StoreNodeType = cluster.NodeTypeFollower
+		newFollowers = append(newFollowers, shardInfo.Master)
+	}
+
+	shardInfo.Master = targetNode
 	shardInfo.Followers = newFollowers
+	shardInfo.Epoch++
+	shardInfo.ForkSeq = forkSeq
+	targetNode.StoreNodeType = cluster.NodeTypeMaster
+	syncLeaderIDs(shardInfo)
+	nm.notifyTopologyChange()
+
+	return change, nil
+}
+
+// newEpoch is the epoch the change started.
+func (c masterChange) newEpoch() int64 {
+	return c.epoch + 1
+}
+
+// revertMasterChange gives the shard its old master and epoch back, unless
+// its master changed again since. It reports whether the change was undone.
+func (nm *NodeManager) revertMasterChange(change masterChange) bool {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	shardInfo, exists := nm.ShardMap[change.shardKey]
+	if !exists || shardInfo.Epoch != change.newEpoch() || shardInfo.Master != change.newMaster {
+		return false
+	}
+
+	followers := make([]*cluster.NodeInfo, 0, len(shardInfo.Followers)+1)
+	for _, f := range shardInfo.Followers {
+		if !change.keptOld || f != change.oldMaster {
+			followers = append(followers, f)
+		}
+	}
+	change.newMaster.StoreNodeType = cluster.NodeTypeFollower
+	shardInfo.Followers = append(followers, change.newMaster)
+	shardInfo.Master = change.oldMaster
+	masterID := -1
+	if change.oldMaster != nil {
+		change.oldMaster.StoreNodeType = cluster.NodeTypeMaster
+		masterID = change.oldMaster.ID
+	}
+	shardInfo.Epoch = change.epoch
+	shardInfo.ForkSeq = change.forkSeq
+	syncLeaderIDs(shardInfo)
+	nm.notifyTopologyChange()
+
+	logrus.WithFields(logrus.Fields{
+		"shardKey":  change.shardKey,
+		"master":    masterID,
+		"newMaster": change.newMaster.ID,
+		"epoch":     change.epoch,
+	}).Warn("Reverted master change, the new master could not be told")
+	return true
+}
+
+// AddNodeSlot pre-provisions an unregistered slot in the given shard that the
+// next self-registering node will claim. A negative shardKey picks the shard
+// with the fewest slots.
+func (nm *NodeManager) AddNodeSlot(shardKey int) (*cluster.NodeInfo, error) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if shardKey < 0 {
+		shardKey = nm.smallestShard()
+	}
+	shardInfo, exists := nm.ShardMap[shardKey]
+	if !exists {
+		return nil, fmt.Errorf("shard %d not found", shardKey)
+	}
+
+	node := &cluster.NodeInfo{
+		ID:            len(nm.Nodes),
+		ShardKey:      shardKey,
+		Status:        cluster.NodeStatusUnregistered,
+		Address:       net.TCPAddr{},
+		StoreNodeType: cluster.NodeTypeFollower,
+	}
+	if shardInfo.Master == nil {
+		node.StoreNodeType = cluster.NodeTypeMaster
+		shardInfo.Master = node
+	} else {
+		shardInfo.Followers = append(shardInfo.Followers, node)
+	}
+	syncLeaderIDs(shardInfo)
+	nm.Nodes = append(nm.Nodes, node)
+	nm.notifyTopologyChange()
+
+	return node, nil
+}
+
+// smallestShard returns the shard with the fewest slots, lowest key first on ties.
+func (nm *NodeManager) smallestShard() int {
+	keys := make([]int, 0, len(nm.ShardMap))
+	for key := range nm.ShardMap {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	smallest, size := -1, 0
+	for _, key := range keys {
+		if n := len(nm.ShardMap[key].Members()); smallest < 0 || n < size {
+			smallest, size = key, n
+		}
+	}
+	return smallest
+}
+
+// CanRemoveNode returns an error if removing the node would leave its shard
+// with fewer slots, or fewer registered replicas, than the configured count.
+func (nm *NodeManager) CanRemoveNode(nodeID int) error {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if nodeID < 0 || nodeID >= len(nm.Nodes) {
+		return fmt.Errorf("invalid node ID: %d", nodeID)
+	}
+	node := nm.Nodes[nodeID]
+	shardInfo, exists := nm.ShardMap[node.ShardKey]
+	if !exists {
+		return nil
+	}
+
+	var slots, serving int
+	for _, member := range shardInfo.Members() {
+		if member.ID == nodeID {
+			continue
+		}
+		slots++
+		if isServing(member.Status) {
+			serving++
+		}
+	}
+
+	if slots < shardInfo.Replicas {
+		return fmt.Errorf("removing node %d would drop shard %d below %d replicas, add a node slot first", nodeID, node.ShardKey, shardInfo.Replicas)
+	}
+	if isServing(node.Status) && serving < shardInfo.Replicas {
+		return fmt.Errorf("removing node %d would drop shard %d below %d replicas, only %d other replicas are registered", nodeID, node.ShardKey, shardInfo.Replicas, serving)
+	}
+	return nil
+}
+
+// SetNodeStatus updates the status of a single node.
+func (nm *NodeManager) SetNodeStatus(nodeID int, status cluster.NodeStatus) error {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if nodeID < 0 || nodeID >= len(nm.Nodes) {
+		return fmt.Errorf("invalid node ID: %d", nodeID)
+	}
+	if nm.Nodes[nodeID].Status != status {
+		nm.Nodes[nodeID].Status = status
+		nm.notifyTopologyChange()
+	}
+	return nil
+}
+
+// enterMaintenance puts a node into maintenance and returns it as it was
+// before. Moving leadership off the node is up to the caller.
+func (nm *NodeManager) enterMaintenance(nodeID int) (cluster.NodeInfo, error) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if nodeID < 0 || nodeID >= len(nm.Nodes) {
+		return cluster.NodeInfo{}, fmt.Errorf("invalid node ID: %d", nodeID)
+	}
+	node := nm.Nodes[nodeID]
+	switch node.Status {
+	case cluster.NodeStatusActive, cluster.NodeStatusSuspect, cluster.NodeStatusSyncing, cluster.NodeStatusFailed:
+	case cluster.NodeStatusMaintenance:
+		return cluster.NodeInfo{}, fmt.Errorf("node %d is already in maintenance", nodeID)
+	default:
+		return cluster.NodeInfo{}, fmt.Errorf("node %d cannot enter maintenance while %s", nodeID, strings.ToLower(string(node.Status)))
+	}
+
+	before := *node
+	node.Status = cluster.NodeStatusMaintenance
+	nm.notifyTopologyChange()
+	return before, nil
+}
+
+// exitMaintenance takes a node out of maintenance. It rejoins its shard as a
+// SYNCING member and only serves again once it caught up with its master.
+func (nm *NodeManager) exitMaintenance(nodeID int) (cluster.NodeInfo, api.RejoinInfo, error) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if nodeID < 0 || nodeID >= len(nm.Nodes) {
+		return cluster.NodeInfo{}, api.RejoinInfo{}, fmt.Errorf("invalid node ID: %d", nodeID)
+	}
+	node := nm.Nodes[nodeID]
+	if node.Status != cluster.NodeStatusMaintenance {
+		return cluster.NodeInfo{}, api.RejoinInfo{}, fmt.Errorf("node %d is not in maintenance", nodeID)
+	}
+
+	node.Status = cluster.NodeStatusSyncing
+	rejoin := nm.rejoinNode(node)
+	nm.notifyTopologyChange()
+	return *node, rejoin, nil
+}
+
+// ReleaseNode removes a node from its shard and retires its slot. The node must
+// not be the master of its shard.
+func (nm *NodeManager) ReleaseNode(nodeID int) error {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if nodeID < 0 || nodeID >= len(nm.Nodes) {
+		return fmt.Errorf("invalid node ID: %d", nodeID)
+	}
+	node := nm.Nodes[nodeID]
 
-	// Add the old master to followers list if it exists
-	if oldMaster != nil {
-		oldMaster.StoreNodeType = cluster.NodeTypeFollower
-		shardInfo.Followers = append(shardInfo.Followers, oldMaster)
+	if shardInfo, exists := nm.ShardMap[node.ShardKey]; exists {
+		if shardInfo.Master == node {
+			return fmt.Errorf("node %d is still the master of shard %d", nodeID, node.ShardKey)
+		}
+		followers := make([]*cluster.NodeInfo, 0, len(shardInfo.Followers))
+		for _, f := range shardInfo.Followers {
+			if f.ID != nodeID {
+				followers = append(followers, f)
+			}
+		}
+		shardInfo.Followers = followers
 	}
 
+	nm.events.Record(api.Event{
+		Type:     api.EventNodeDecommissioned,
+		NodeID:   node.ID,
+		ShardKey: node.ShardKey,
+		Actor:    actorAdmin,
+		Details:  map[string]string{"status": string(node.Status), "role": string(node.StoreNodeType)},
+	})
+	node.Status = cluster.NodeStatusDecommissioned
+	node.StoreNodeType = cluster.NodeTypeUnknown
+	node.Address = net.TCPAddr{}
+	node.Topology = cluster.Topology{}
+	nm.notifyTopologyChange()
 	return nil
 }
+
+// AddSpareSlot pre-provisions a slot outside of any shard. Registered spare
+// nodes are used to host new shards when a range is split.
+func (nm *NodeManager) AddSpareSlot() *cluster.NodeInfo {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	node := &cluster.NodeInfo{
+		ID:            len(nm.Nodes),
+		ShardKey:      -1,
+		Status:        cluster.NodeStatusUnregistered,
+		Address:       net.TCPAddr{},
+		StoreNodeType: cluster.NodeTypeUnknown,
+		LeaderID:      -1,
+	}
+	nm.Nodes = append(nm.Nodes, node)
+	nm.notifyTopologyChange()
+	return node
+}
+
+// rangeShard is a point-in-time copy of a shard in range partitioning mode.
+type rangeShard struct {
+	ShardKey int
+	Range    partition.Range
+	Master   cluster.NodeInfo
+	Members  []cluster.NodeInfo
+}
+
+// rangeShards returns copies of all shards ordered by the start of their range.
+func (nm *NodeManager) rangeShards() []rangeShard {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	shards := make([]rangeShard, 0, len(nm.ShardMap))
+	for key, shardInfo := range nm.ShardMap {
+		if shardInfo.Master == nil {
+			continue
+		}
+		shard := rangeShard{
+			ShardKey: key,
+			Range:    partition.Range{ShardKey: key, Start: shardInfo.StartKey, End: shardInfo.EndKey},
+			Master:   *shardInfo.Master,
+		}
+		for _, member := range shardInfo.Members() {
+			shard.Members = append(shard.Members, *member)
+		}
+		shards = append(shards, shard)
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].Range.Start < shards[j].Range.Start
+	})
+	return shards
+}
+
+// allocateShard reserves registered spare nodes for a new shard, as many as
+// the default replication factor. The first node becomes the master. The shard
+// is not routable until commitSplit.
+func (nm *NodeManager) allocateShard() (int, []cluster.NodeInfo, error) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	n := nm.replicas
+	var spares []*cluster.NodeInfo
+	for _, node := range nm.Nodes {
+		if node.ShardKey < 0 && isServing(node.Status) {
+			spares = append(spares, node)
+		}
+	}
+	if len(spares) < n {
+		return 0, nil, fmt.Errorf("need %d spare nodes, only %d are registered", n, len(spares))
+	}
+	spares = pickDiverse(spares, n)
+
+	// Shard keys are never reused so routers cannot confuse a new shard with a merged one
+	shardKey := nm.nextShardKey
+	nm.nextShardKey++
+
+	nodes := make([]cluster.NodeInfo, n)
+	for i, node := range spares[:n] {
+		node.ShardKey = shardKey
+		node.LeaderID = spares[0].ID
+		node.StoreNodeType = cluster.NodeTypeFollower
+		if i == 0 {
+			node.StoreNodeType = cluster.NodeTypeMaster
+		}
+		nodes[i] = *node
+	}
+	return shardKey, nodes, nil
+}
+
+// releaseToSpares returns nodes to the spare pool.
+func (nm *NodeManager) releaseToSpares(nodeIDs []int) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	for _, id := range nodeIDs {
+		if id >= 0 && id < len(nm.Nodes) {
+			nm.Nodes[id].ShardKey = -1
+			nm.Nodes[id].LeaderID = -1
+			nm.Nodes[id].StoreNodeType = cluster.NodeTypeUnknown
+		}
+	}
+}
+
+// commitSplit hands [splitKey, end) of a shard over to a shard allocated with
+// allocateShard and publishes the new partition map.
+func (nm *NodeManager) commitSplit(shardKey, newShardKey int, splitKey string) error {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	shardInfo, exists := nm.ShardMap[shardKey]
+	if !exists {
+		return fmt.Errorf("shard %d not found", shardKey)
+	}
+
+	newShard := &cluster.ShardInfo{
+		ShardKey: newShardKey,
+		StartKey: splitKey,
+		EndKey:   shardInfo.EndKey,
+		Replicas: nm.replicas,
+		Epoch:    1,
+	}
+	for _, node := range nm.Nodes {
+		if node.ShardKey != newShardKey {
+			continue
+		}
+		if node.StoreNodeType == cluster.NodeTypeMaster {
+			newShard.Master = node
+		} else {
+			newShard.Followers = append(newShard.Followers, node)
+		}
+	}
+	if newShard.Master == nil {
+		return fmt.Errorf("shard %d has no master", newShardKey)
+	}
+
+	shardInfo.EndKey = splitKey
+	nm.ShardMap[newShardKey] = newShard
+	nm.updatePartitionMap()
+	return nil
+}
+
+// commitMerge extends the left shard over the range of its right neighbour,
+// removes the right shard and returns its former members to the spare pool.
+func (nm *NodeManager) commitMerge(leftKey, rightKey int) ([]cluster.NodeInfo, error) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	left, exists := nm.ShardMap[leftKey]
+	if !exists {
+		return nil, fmt.Errorf("shard %d not found", leftKey)
+	}
+	right, exists := nm.ShardMap[rightKey]
+	if !exists {
+		return nil, fmt.Errorf("shard %d not found", rightKey)
+	}
+	if left.EndKey != right.StartKey {
+		return nil, fmt.Errorf("shards %d and %d are not adjacent", leftKey, rightKey)
+	}
+
+	left.EndKey = right.EndKey
+	delete(nm.ShardMap, rightKey)
+
+	var released []cluster.NodeInfo
+	for _, node := range right.Members() {
+		node.ShardKey = -1
+		node.LeaderID = -1
+		node.StoreNodeType = cluster.NodeTypeUnknown
+		released = append(released, *node)
+	}
+	nm.updatePartitionMap()
+	return released, nil
+}
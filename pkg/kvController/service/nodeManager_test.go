@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+func TestPromoteRequiresTheEpochItWasPlannedIn(t *testing.T) {
+	nm := NewNodeManager(1, 3, &config.KvControllerConfig{})
+	shard := nm.ShardMap[0]
+	follower := shard.Followers[0].ID
+
+	if _, err := nm.promote(0, shard.Epoch-1, follower, 0, true); err == nil {
+		t.Fatal("promoted in an epoch the shard already left")
+	}
+	change, err := nm.promote(0, shard.Epoch, follower, 7, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shard.Master.ID != follower || shard.Epoch != change.newEpoch() || shard.ForkSeq != 7 {
+		t.Fatalf("shard after promotion: master %d epoch %d fork seq %d", shard.Master.ID, shard.Epoch, shard.ForkSeq)
+	}
+	for _, member := range shard.Members() {
+		if member.LeaderID != follower {
+			t.Fatalf("node %d follows %d, want %d", member.ID, member.LeaderID, follower)
+		}
+	}
+}
+
+func TestRevertMasterChangeRestoresMasterAndEpoch(t *testing.T) {
+	nm := NewNodeManager(1, 3, &config.KvControllerConfig{})
+	shard := nm.ShardMap[0]
+	oldMaster, target := shard.Master, shard.Followers[1]
+	epoch := shard.Epoch
+
+	change, err := nm.promote(0, epoch, target.ID, 3, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nm.revertMasterChange(change) {
+		t.Fatal("change was not reverted")
+	}
+	if shard.Master != oldMaster || shard.Epoch != epoch || shard.ForkSeq != 0 {
+		t.Fatalf("shard after revert: master %d epoch %d fork seq %d", shard.Master.ID, shard.Epoch, shard.ForkSeq)
+	}
+	if oldMaster.StoreNodeType != cluster.NodeTypeMaster || target.StoreNodeType != cluster.NodeTypeFollower {
+		t.Fatalf("roles after revert: old master %s, target %s", oldMaster.StoreNodeType, target.StoreNodeType)
+	}
+	if len(shard.Followers) != 2 {
+		t.Fatalf("%d followers after revert, want 2", len(shard.Followers))
+	}
+	for _, f := range shard.Followers {
+		if f == oldMaster {
+			t.Fatal("the old master is still listed as a follower")
+		}
+	}
+
+	// A change superseded by another one is left alone
+	change, _ = nm.promote(0, epoch, target.ID, 3, true)
+	if _, err := nm.promote(0, change.newEpoch(), oldMaster.ID, 4, true); err != nil {
+		t.Fatal(err)
+	}
+	if nm.revertMasterChange(change) {
+		t.Fatal("reverted a change the shard moved on from")
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// placementCost measures how much a node would share failure domains with the
+// other members of a shard. worst is the narrowest shared domain, total sums
+// the shared domains over all members and breaks ties.
+type placementCost struct {
+	worst cluster.FailureDomain
+	total int
+}
+
+func (c placementCost) less(o placementCost) bool {
+	if c.worst != o.worst {
+		return c.worst < o.worst
+	}
+	return c.total < o.total
+}
+
+// placesReplica reports whether the node holds, or is about to hold, a copy of its shard.
+func placesReplica(node *cluster.NodeInfo) bool {
+	return node.Status != cluster.NodeStatusUnregistered && node.Status != cluster.NodeStatusDecommissioned
+}
+
+// slotCost returns the cost of placing a node with the given topology into
+// slot. Must be called with the lock held.
+func (nm *NodeManager) slotCost(slot *cluster.NodeInfo, topology cluster.Topology) placementCost {
+	return nm.shardCost(slot.ShardKey, topology, slot.ID)
+}
+
+// shardCost returns the cost of adding a node with the given topology to a
+// shard, ignoring the member excludeID. Must be called with the lock held.
+func (nm *NodeManager) shardCost(shardKey int, topology cluster.Topology, excludeID int) placementCost {
+	var cost placementCost
+	shardInfo, exists := nm.ShardMap[shardKey]
+	if !exists {
+		return cost
+	}
+	for _, member := range shardInfo.Members() {
+		if member.ID == excludeID || !placesReplica(member) {
+			continue
+		}
+		domain := topology.SharedDomain(member.Topology)
+		cost.worst = max(cost.worst, domain)
+		cost.total += int(domain)
+	}
+	return cost
+}
+
+// pickSlot returns the slot that keeps a node with the given topology in the
+// most distinct failure domains from the rest of its shard, earlier slots win
+// ties. Must be called with the lock held.
+func (nm *NodeManager) pickSlot(slots []*cluster.NodeInfo, topology cluster.Topology) (*cluster.NodeInfo, placementCost) {
+	var best *cluster.NodeInfo
+	var bestCost placementCost
+	for _, slot := range slots {
+		cost := nm.slotCost(slot, topology)
+		if best == nil || cost.less(bestCost) {
+			best, bestCost = slot, cost
+		}
+	}
+	return best, bestCost
+}
+
+// pickDiverse greedily picks n nodes that share as few failure domains as
+// possible with each other.
+func pickDiverse(candidates []*cluster.NodeInfo, n int) []*cluster.NodeInfo {
+	remaining := append([]*cluster.NodeInfo(nil), candidates...)
+	picked := make([]*cluster.NodeInfo, 0, n)
+	for len(picked) < n && len(remaining) > 0 {
+		bestIdx := 0
+		var bestCost placementCost
+		for i, candidate := range remaining {
+			var cost placementCost
+			for _, p := range picked {
+				domain := candidate.Topology.SharedDomain(p.Topology)
+				cost.worst = max(cost.worst, domain)
+				cost.total += int(domain)
+			}
+			if i == 0 || cost.less(bestCost) {
+				bestIdx, bestCost = i, cost
+			}
+		}
+		picked = append(picked, remaining[bestIdx])
+		remaining = append(remaining[:bestIdx], remaining[bestIdx+1:]...)
+	}
+	return picked
+}
+
+// sameZone reports whether two nodes are known to run in the same zone.
+func sameZone(a, b *cluster.NodeInfo) bool {
+	return a != nil && b != nil && a.Topology.Zone != "" && a.Topology.Zone == b.Topology.Zone
+}
+
+// PlacementWarnings lists the replicas of each shard that share a failure
+// domain, so losing one zone, rack or host takes out several copies.
+func (nm *NodeManager) PlacementWarnings() []string {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	keys := make([]int, 0, len(nm.ShardMap))
+	for key := range nm.ShardMap {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	warnings := make([]string, 0)
+	for _, key := range keys {
+		members := nm.ShardMap[key].Members()
+		for i, a := range members {
+			if !placesReplica(a) {
+				continue
+			}
+			for _, b := range members[i+1:] {
+				if !placesReplica(b) {
+					continue
+				}
+				if domain := a.Topology.SharedDomain(b.Topology); domain != cluster.DomainNone {
+					warnings = append(warnings, fmt.Sprintf("shard %d: nodes %d and %d share %s", key, a.ID, b.ID, a.Topology.Label(domain)))
+				}
+			}
+		}
+	}
+	return warnings
+}
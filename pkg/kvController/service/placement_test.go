@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+func TestPlacementCostOrdersByNarrowestSharedDomain(t *testing.T) {
+	tests := []struct {
+		a, b placementCost
+		less bool
+	}{
+		{placementCost{worst: cluster.DomainNone, total: 0}, placementCost{worst: cluster.DomainZone, total: 1}, true},
+		{placementCost{worst: cluster.DomainZone, total: 5}, placementCost{worst: cluster.DomainRack, total: 2}, true},
+		{placementCost{worst: cluster.DomainRack, total: 2}, placementCost{worst: cluster.DomainRack, total: 3}, true},
+		{placementCost{worst: cluster.DomainHost, total: 3}, placementCost{worst: cluster.DomainHost, total: 3}, false},
+		{placementCost{worst: cluster.DomainHost, total: 3}, placementCost{worst: cluster.DomainZone, total: 9}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.less(tt.b); got != tt.less {
+			t.Errorf("%+v.less(%+v) = %v, want %v", tt.a, tt.b, got, tt.less)
+		}
+	}
+}
+
+func TestShardCostCountsSharedDomainsOfPlacedMembers(t *testing.T) {
+	nm := NewNodeManager(1, 3, &config.KvControllerConfig{})
+	shard := nm.ShardMap[0]
+	shard.Master.Status = cluster.NodeStatusActive
+	shard.Master.Topology = cluster.Topology{Zone: "a", Rack: "1", Host: "h1"}
+	shard.Followers[0].Status = cluster.NodeStatusActive
+	shard.Followers[0].Topology = cluster.Topology{Zone: "a", Rack: "2", Host: "h2"}
+	// An empty slot holds no copy yet and costs nothing
+	shard.Followers[1].Topology = cluster.Topology{Zone: "b", Rack: "1", Host: "h3"}
+
+	tests := []struct {
+		topology cluster.Topology
+		want     placementCost
+	}{
+		{cluster.Topology{Zone: "b", Rack: "1", Host: "h3"}, placementCost{}},
+		{cluster.Topology{Zone: "a", Rack: "3", Host: "h4"}, placementCost{worst: cluster.DomainZone, total: 2}},
+		{cluster.Topology{Zone: "a", Rack: "1", Host: "h5"}, placementCost{worst: cluster.DomainRack, total: 3}},
+		{cluster.Topology{Zone: "a", Rack: "1", Host: "h1"}, placementCost{worst: cluster.DomainHost, total: 4}},
+	}
+	for _, tt := range tests {
+		if got := nm.shardCost(0, tt.topology, shard.Followers[1].ID); got != tt.want {
+			t.Errorf("shardCost(%+v) = %+v, want %+v", tt.topology, got, tt.want)
+		}
+	}
+
+	// Ignoring the master, only the follower in zone a is shared
+	if got := nm.shardCost(0, cluster.Topology{Zone: "a", Rack: "1", Host: "h1"}, shard.Master.ID); got != (placementCost{worst: cluster.DomainZone, total: 1}) {
+		t.Errorf("shardCost without the master = %+v, want zone shared once", got)
+	}
+}
+
+func TestPickDiverseSpreadsAcrossZones(t *testing.T) {
+	candidates := []*cluster.NodeInfo{
+		{ID: 0, Topology: cluster.Topology{Zone: "a", Rack: "1", Host: "h1"}},
+		{ID: 1, Topology: cluster.Topology{Zone: "a", Rack: "1", Host: "h2"}},
+		{ID: 2, Topology: cluster.Topology{Zone: "a", Rack: "2", Host: "h3"}},
+		{ID: 3, Topology: cluster.Topology{Zone: "b", Rack: "1", Host: "h4"}},
+	}
+
+	picked := pickDiverse(candidates, 3)
+	ids := make([]int, len(picked))
+	for i, node := range picked {
+		ids[i] = node.ID
+	}
+	// Zone b first, then another rack of zone a, the shared rack comes last
+	if len(ids) != 3 || ids[0] != 0 || ids[1] != 3 || ids[2] != 2 {
+		t.Fatalf("picked %v, want [0 3 2]", ids)
+	}
+	if got := pickDiverse(candidates, 10); len(got) != len(candidates) {
+		t.Fatalf("picked %d of %d candidates", len(got), len(candidates))
+	}
+}
@@ -0,0 +1,420 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+// RangeManager splits hot or large shards at their median key and merges cold
+// adjacent shards when the cluster runs in range partitioning mode.
+type RangeManager struct {
+	nodeManager *NodeManager
+	cfg         config.RangeConfig
+	interval    time.Duration
+	client      *http.Client
+	mu          sync.Mutex // serializes splits and merges
+	stopChan    chan struct{}
+}
+
+func NewRangeManager(nodeManager *NodeManager, cfg *config.KvControllerConfig) *RangeManager {
+	interval := time.Duration(cfg.Ranges.CheckIntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	return &RangeManager{
+		nodeManager: nodeManager,
+		cfg:         cfg.Ranges,
+		interval:    interval,
+		client:      &http.Client{Timeout: time.Duration(cfg.Discovery.FailureTimeoutMs) * time.Millisecond},
+		stopChan:    make(chan struct{}),
+	}
+}
+
+func (rm *RangeManager) Start() {
+	go rm.rebalanceLoop()
+}
+
+func (rm *RangeManager) Stop() {
+	close(rm.stopChan)
+}
+
+func (rm *RangeManager) rebalanceLoop() {
+	ticker := time.NewTicker(rm.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rm.stopChan:
+			return
+		case <-ticker.C:
+			rm.rebalance()
+		}
+	}
+}
+
+// rebalance performs at most one split or merge per round so the partition
+// map changes gradually.
+func (rm *RangeManager) rebalance() {
+	shards := rm.nodeManager.rangeShards()
+	stats := make(map[int]api.NodeStats)
+	for _, shard := range shards {
+		if !isServing(shard.Master.Status) {
+			continue
+		}
+		var s api.NodeStats
+		if err := rm.getJSON(shard.Master, "/stats", &s); err != nil {
+			logrus.WithError(err).WithField("shard", shard.ShardKey).Warn("Failed to get shard stats")
+			continue
+		}
+		stats[shard.ShardKey] = s
+	}
+
+	for _, shard := range shards {
+		s, ok := stats[shard.ShardKey]
+		if !ok || !rm.shouldSplit(s) {
+			continue
+		}
+		if err := rm.SplitShard(shard.ShardKey); err != nil {
+			logrus.WithError(err).WithField("shard", shard.ShardKey).Warn("Failed to split shard")
+			continue
+		}
+		return
+	}
+
+	for i := 0; i+1 < len(shards); i++ {
+		left, okLeft := stats[shards[i].ShardKey]
+		right, okRight := stats[shards[i+1].ShardKey]
+		if !okLeft || !okRight || !rm.shouldMerge(left, right) {
+			continue
+		}
+		if err := rm.MergeShards(shards[i].ShardKey, shards[i+1].ShardKey); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"left":  shards[i].ShardKey,
+				"right": shards[i+1].ShardKey,
+			}).Warn("Failed to merge shards")
+			continue
+		}
+		return
+	}
+}
+
+func (rm *RangeManager) shouldSplit(s api.NodeStats) bool {
+	return (rm.cfg.SplitMaxKeys > 0 && s.Keys > rm.cfg.SplitMaxKeys) ||
+		(rm.cfg.SplitMaxOpsPerSec > 0 && s.OpsPerSec > rm.cfg.SplitMaxOpsPerSec)
+}
+
+func (rm *RangeManager) shouldMerge(left, right api.NodeStats) bool {
+	if rm.cfg.MergeMaxKeys <= 0 && rm.cfg.MergeMaxOpsPerSec <= 0 {
+		return false
+	}
+	if rm.cfg.MergeMaxKeys > 0 && left.Keys+right.Keys > rm.cfg.MergeMaxKeys {
+		return false
+	}
+	if rm.cfg.MergeMaxOpsPerSec > 0 && left.OpsPerSec+right.OpsPerSec > rm.cfg.MergeMaxOpsPerSec {
+		return false
+	}
+	// Never merge into something that would be split right away
+	merged := api.NodeStats{Keys: left.Keys + right.Keys, OpsPerSec: left.OpsPerSec + right.OpsPerSec}
+	return !rm.shouldSplit(merged)
+}
+
+// SplitShard moves the upper half of a shard's range, starting at its median
+// key, to a new shard hosted on spare nodes.
+func (rm *RangeManager) SplitShard(shardKey int) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	shard, err := rm.findShard(shardKey)
+	if err != nil {
+		return err
+	}
+
+	var median api.RangeMedianResponse
+	if err := rm.getJSON(shard.Master, "/range/median?"+rangeQuery(shard.Range), &median); err != nil {
+		return fmt.Errorf("failed to get median key: %v", err)
+	}
+	if median.Count < 2 || median.Key == shard.Range.Start {
+		return fmt.Errorf("shard %d has too few keys to split", shardKey)
+	}
+
+	newShardKey, nodes, err := rm.nodeManager.allocateShard()
+	if err != nil {
+		return fmt.Errorf("cannot split shard %d: %v", shardKey, err)
+	}
+
+	moved := partition.Range{Start: median.Key, End: shard.Range.End}
+	if err := rm.seedShard(newShardKey, nodes); err != nil {
+		rm.returnToSpares(nodes)
+		return err
+	}
+	if err := rm.handOver(shard.Master, moved, newShardKey, nodes[0]); err != nil {
+		rm.returnToSpares(nodes)
+		return err
+	}
+	if err := rm.nodeManager.commitSplit(shardKey, newShardKey, median.Key); err != nil {
+		rm.cancelHandOver(shard.Master, nodes[0], moved)
+		rm.returnToSpares(nodes)
+		return err
+	}
+
+	// Routers still on the old topology are sent to the new shard with ASK,
+	// only then the copies on the old one can go
+	if err := rm.migrateRange(shard.Master, moved, newShardKey, nodes[0], false); err != nil {
+		logrus.WithError(err).WithField("shard", shardKey).Warn("Failed to redirect moved keys, keeping them on the split shard")
+	} else if err := rm.postJSON(shard.Master, "/range/drop", api.RangeDropRequest{Start: moved.Start, End: moved.End}); err != nil {
+		logrus.WithError(err).WithField("shard", shardKey).Warn("Failed to drop moved keys from split shard")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"shard":     shardKey,
+		"new_shard": newShardKey,
+		"split_key": median.Key,
+		"keys":      median.Count,
+	}).Info("Shard split")
+	rm.nodeManager.events.Record(api.Event{
+		Type:     api.EventShardSplit,
+		NodeID:   -1,
+		ShardKey: shardKey,
+		Actor:    actorController,
+		Details: map[string]string{
+			"new_shard": fmt.Sprint(newShardKey),
+			"split_key": median.Key,
+			"keys":      fmt.Sprint(median.Count),
+		},
+	})
+	return nil
+}
+
+// MergeShards folds the range of the right shard into its left neighbour and
+// returns the right shard's nodes to the spare pool.
+func (rm *RangeManager) MergeShards(leftKey, rightKey int) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	left, err := rm.findShard(leftKey)
+	if err != nil {
+		return err
+	}
+	right, err := rm.findShard(rightKey)
+	if err != nil {
+		return err
+	}
+	if left.Range.End != right.Range.Start {
+		return fmt.Errorf("shards %d and %d are not adjacent", leftKey, rightKey)
+	}
+
+	if err := rm.handOver(right.Master, right.Range, leftKey, left.Master); err != nil {
+		return err
+	}
+	// The right shard keeps rejecting writes until its nodes are released
+	released, err := rm.nodeManager.commitMerge(leftKey, rightKey)
+	if err != nil {
+		rm.cancelHandOver(right.Master, left.Master, right.Range)
+		return err
+	}
+	for _, node := range released {
+		if err := rm.assignShard(node, -1, cluster.NodeTypeUnknown, nil); err != nil {
+			logrus.WithError(err).WithField("node", node.ID).Warn("Failed to return node to the spare pool")
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"shard":        leftKey,
+		"merged_shard": rightKey,
+	}).Info("Shards merged")
+	rm.nodeManager.events.Record(api.Event{
+		Type:     api.EventShardsMerged,
+		NodeID:   -1,
+		ShardKey: leftKey,
+		Actor:    actorController,
+		Details:  map[string]string{"merged_shard": fmt.Sprint(rightKey)},
+	})
+	return nil
+}
+
+func (rm *RangeManager) findShard(shardKey int) (rangeShard, error) {
+	for _, shard := range rm.nodeManager.rangeShards() {
+		if shard.ShardKey == shardKey {
+			return shard, nil
+		}
+	}
+	return rangeShard{}, fmt.Errorf("shard %d not found", shardKey)
+}
+
+// seedShard assigns the nodes to a new shard, the first one as its master.
+func (rm *RangeManager) seedShard(shardKey int, nodes []cluster.NodeInfo) error {
+	master := nodes[0]
+	if err := rm.assignShard(master, shardKey, cluster.NodeTypeMaster, &master); err != nil {
+		return fmt.Errorf("failed to assign master of shard %d: %v", shardKey, err)
+	}
+	for _, follower := range nodes[1:] {
+		if err := rm.assignShard(follower, shardKey, cluster.NodeTypeFollower, &master); err != nil {
+			return fmt.Errorf("failed to assign follower of shard %d: %v", shardKey, err)
+		}
+	}
+	return nil
+}
+
+// returnToSpares undoes a shard allocation after a failed split.
+func (rm *RangeManager) returnToSpares(nodes []cluster.NodeInfo) {
+	ids := make([]int, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID
+		if err := rm.assignShard(node, -1, cluster.NodeTypeUnknown, nil); err != nil {
+			logrus.WithError(err).WithField("node", node.ID).Warn("Failed to return node to the spare pool")
+		}
+	}
+	rm.nodeManager.releaseToSpares(ids)
+}
+
+// handOver copies the keys of r from the master source to target, the master
+// of shardKey. Target is told it imports r first, so it serves the requests
+// source sends it with ASK later on. Source rejects writes to r from the
+// start, so the copy holds every write acknowledged before the topology moves
+// r, and target is checked to hold all of it. Both marks are lifted when the
+// copy fails.
+func (rm *RangeManager) handOver(source cluster.NodeInfo, r partition.Range, shardKey int, target cluster.NodeInfo) error {
+	err := rm.postJSON(target, "/range/migration", api.RangeMigrationRequest{
+		Start:    r.Start,
+		End:      r.End,
+		ShardKey: source.ShardKey,
+		NodeID:   source.ID,
+		Address:  source.HostPort(),
+		Import:   true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark range import on node %d: %v", target.ID, err)
+	}
+	if err := rm.migrateRange(source, r, shardKey, target, true); err != nil {
+		rm.cancelHandOver(source, target, r)
+		return err
+	}
+	if err := rm.copyRange(source, target, r); err != nil {
+		rm.cancelHandOver(source, target, r)
+		return err
+	}
+	return nil
+}
+
+// copyRange replaces the keys of r on to with those on from and checks that
+// every one of them arrived.
+func (rm *RangeManager) copyRange(from, to cluster.NodeInfo, r partition.Range) error {
+	// Leftovers of an earlier attempt must not survive the copy
+	if err := rm.postJSON(to, "/range/drop", api.RangeDropRequest{Start: r.Start, End: r.End}); err != nil {
+		return fmt.Errorf("failed to clear range on node %d: %v", to.ID, err)
+	}
+	var export api.RangeExportResponse
+	if err := rm.getJSON(from, "/range/export?"+rangeQuery(r), &export); err != nil {
+		return fmt.Errorf("failed to export range from node %d: %v", from.ID, err)
+	}
+	if err := rm.postJSON(to, "/range/import", export); err != nil {
+		return fmt.Errorf("failed to import range into node %d: %v", to.ID, err)
+	}
+
+	exported := 0
+	for _, keys := range export.Data {
+		exported += len(keys)
+	}
+	var imported api.RangeMedianResponse
+	if err := rm.getJSON(to, "/range/median?"+rangeQuery(r), &imported); err != nil {
+		return fmt.Errorf("failed to count range on node %d: %v", to.ID, err)
+	}
+	if imported.Count != exported {
+		return fmt.Errorf("node %d holds %d of the %d keys copied", to.ID, imported.Count, exported)
+	}
+	return nil
+}
+
+// migrateRange marks r on the master source as handed over to target, the
+// master of shardKey. A frozen range rejects writes while it is copied,
+// otherwise source answers requests for its keys with an ASK redirect to
+// target until its topology assigns r to that shard.
+func (rm *RangeManager) migrateRange(source cluster.NodeInfo, r partition.Range, shardKey int, target cluster.NodeInfo, freeze bool) error {
+	err := rm.postJSON(source, "/range/migration", api.RangeMigrationRequest{
+		Start:    r.Start,
+		End:      r.End,
+		ShardKey: shardKey,
+		NodeID:   target.ID,
+		Address:  target.HostPort(),
+		Freeze:   freeze,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark range migration on node %d: %v", source.ID, err)
+	}
+	return nil
+}
+
+// cancelHandOver lifts the freeze or ends the redirects on source, and the
+// import on target, of a hand-over that was not committed.
+func (rm *RangeManager) cancelHandOver(source, target cluster.NodeInfo, r partition.Range) {
+	err := rm.postJSON(source, "/range/migration", api.RangeMigrationRequest{Start: r.Start, End: r.End, Cancel: true})
+	if err != nil {
+		logrus.WithError(err).WithField("node", source.ID).Warn("Failed to cancel range migration")
+	}
+	err = rm.postJSON(target, "/range/migration", api.RangeMigrationRequest{Start: r.Start, End: r.End, Import: true, Cancel: true})
+	if err != nil {
+		logrus.WithError(err).WithField("node", target.ID).Warn("Failed to cancel range import")
+	}
+}
+
+// assignShard moves a node to a shard led by leader, a nil leader returns it
+// to the spare pool.
+func (rm *RangeManager) assignShard(node cluster.NodeInfo, shardKey int, state cluster.StoreNodeType, leader *cluster.NodeInfo) error {
+	assignment := api.ShardAssignment{ShardKey: shardKey, State: state, LeaderID: -1}
+	if leader != nil {
+		assignment.LeaderID = leader.ID
+		assignment.LeaderAddress = leader.HostPort()
+	}
+	return rm.postJSON(node, "/assign-shard", assignment)
+}
+
+func rangeQuery(r partition.Range) string {
+	return url.Values{"start": {r.Start}, "end": {r.End}}.Encode()
+}
+
+func (rm *RangeManager) getJSON(node cluster.NodeInfo, path string, out interface{}) error {
+	resp, err := rm.client.Get(fmt.Sprintf("http://%s:%d%s", node.Address.IP.String(), node.Address.Port, path))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node returned non-200 status: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (rm *RangeManager) postJSON(node cluster.NodeInfo, path string, in interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	resp, err := rm.client.Post(
+		fmt.Sprintf("http://%s:%d%s", node.Address.IP.String(), node.Address.Port, path),
+		"application/json",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node returned non-200 status: %d", resp.StatusCode)
+	}
+	return nil
+}
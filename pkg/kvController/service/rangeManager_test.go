@@ -0,0 +1,94 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// newRangeNodeManager returns a range partitioned cluster of two shards with
+// one node each and a registered spare.
+func newRangeNodeManager(t *testing.T) *NodeManager {
+	t.Helper()
+	cfg := &config.KvControllerConfig{}
+	cfg.Cluster.Partitioning = string(partition.ModeRange)
+	nm := NewNodeManager(2, 1, cfg)
+	for _, node := range nm.Nodes {
+		node.Status = cluster.NodeStatusActive
+	}
+	nm.AddSpareSlot().Status = cluster.NodeStatusActive
+	return nm
+}
+
+func locate(t *testing.T, nm *NodeManager, key string) int {
+	t.Helper()
+	shard, ok := partition.NewLocator(nm.GetPartitionMap()).Locate(key)
+	if !ok {
+		t.Fatalf("%q has no owner", key)
+	}
+	return shard
+}
+
+func TestSplitMovesTheUpperHalfToTheNewShard(t *testing.T) {
+	nm := newRangeNodeManager(t)
+	if got := locate(t, nm, "0"); got != 0 {
+		t.Fatalf("%q is on shard %d before the split, want 0", "0", got)
+	}
+	version := nm.GetPartitionMap().Version
+
+	newShardKey, nodes, err := nm.allocateShard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].ID != 2 {
+		t.Fatalf("allocated %+v, want the spare", nodes)
+	}
+	// The new shard is not routable before the split is committed
+	if got := locate(t, nm, "0"); got != 0 {
+		t.Fatalf("%q moved to shard %d before the split was committed", "0", got)
+	}
+
+	if err := nm.commitSplit(0, newShardKey, "0"); err != nil {
+		t.Fatal(err)
+	}
+	if m := nm.GetPartitionMap(); m.Version != version+1 || len(m.Ranges) != 3 {
+		t.Fatalf("partition map %+v, want version %d with 3 ranges", m, version+1)
+	}
+	for key, want := range map[string]int{"": 0, "/": 0, "0": newShardKey, "00": newShardKey, "z": 1} {
+		if got := locate(t, nm, key); got != want {
+			t.Errorf("%q is on shard %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestMergeFoldsTheRightShardIntoItsNeighbour(t *testing.T) {
+	nm := newRangeNodeManager(t)
+	newShardKey, _, err := nm.allocateShard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nm.commitSplit(0, newShardKey, "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := nm.commitMerge(0, 1); err == nil {
+		t.Fatal("merged shards that are not adjacent")
+	}
+	released, err := nm.commitMerge(0, newShardKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 1 || released[0].ShardKey != -1 {
+		t.Fatalf("released %+v, want the new shard's node back in the spare pool", released)
+	}
+	for key, want := range map[string]int{"": 0, "0": 0, "00": 0, "z": 1} {
+		if got := locate(t, nm, key); got != want {
+			t.Errorf("%q is on shard %d, want %d", key, got, want)
+		}
+	}
+	if m := nm.GetPartitionMap(); len(m.Ranges) != 2 {
+		t.Fatalf("partition map %+v, want 2 ranges", m)
+	}
+}
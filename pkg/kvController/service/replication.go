@@ -0,0 +1,269 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+// setReplicas records the replication factor of a shard and returns its
+// previous one and a copy of its members.
+func (nm *NodeManager) setReplicas(shardKey, replicas int) (int, []cluster.NodeInfo, error) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	shardInfo, exists := nm.ShardMap[shardKey]
+	if !exists {
+		return 0, nil, fmt.Errorf("shard %d not found", shardKey)
+	}
+	previous := shardInfo.Replicas
+	shardInfo.Replicas = replicas
+
+	var members []cluster.NodeInfo
+	for _, member := range shardInfo.Members() {
+		members = append(members, *member)
+	}
+	return previous, members, nil
+}
+
+// setDefaultReplicas changes the replication factor given to new shards and
+// returns the keys of all current shards.
+func (nm *NodeManager) setDefaultReplicas(replicas int) []int {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	nm.replicas = replicas
+	keys := make([]int, 0, len(nm.ShardMap))
+	for key := range nm.ShardMap {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// adoptSpare moves the registered spare node that shares the fewest failure
+// domains with the shard into it as a SYNCING follower. It returns the spare
+// and the master it follows.
+func (nm *NodeManager) adoptSpare(shardKey int) (cluster.NodeInfo, cluster.NodeInfo, bool) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	shardInfo, exists := nm.ShardMap[shardKey]
+	if !exists || shardInfo.Master == nil {
+		return cluster.NodeInfo{}, cluster.NodeInfo{}, false
+	}
+
+	var spare *cluster.NodeInfo
+	var spareCost placementCost
+	for _, node := range nm.Nodes {
+		if node.ShardKey >= 0 || node.Status != cluster.NodeStatusActive {
+			continue
+		}
+		cost := nm.shardCost(shardKey, node.Topology, node.ID)
+		if spare == nil || cost.less(spareCost) {
+			spare, spareCost = node, cost
+		}
+	}
+	if spare == nil {
+		return cluster.NodeInfo{}, cluster.NodeInfo{}, false
+	}
+
+	spare.ShardKey = shardKey
+	spare.StoreNodeType = cluster.NodeTypeFollower
+	spare.Status = cluster.NodeStatusSyncing
+	spare.Lag = 0
+	shardInfo.Followers = append(shardInfo.Followers, spare)
+	syncLeaderIDs(shardInfo)
+	nm.notifyTopologyChange()
+	return *spare, *shardInfo.Master, true
+}
+
+// detachToSpare removes a follower from its shard and returns it to the spare pool.
+func (nm *NodeManager) detachToSpare(nodeID int) error {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if nodeID < 0 || nodeID >= len(nm.Nodes) {
+		return fmt.Errorf("invalid node ID: %d", nodeID)
+	}
+	node := nm.Nodes[nodeID]
+	if shardInfo, exists := nm.ShardMap[node.ShardKey]; exists {
+		if shardInfo.Master == node {
+			return fmt.Errorf("node %d is still the master of shard %d", nodeID, node.ShardKey)
+		}
+		followers := make([]*cluster.NodeInfo, 0, len(shardInfo.Followers))
+		for _, f := range shardInfo.Followers {
+			if f.ID != nodeID {
+				followers = append(followers, f)
+			}
+		}
+		shardInfo.Followers = followers
+	}
+
+	node.ShardKey = -1
+	node.LeaderID = -1
+	node.StoreNodeType = cluster.NodeTypeUnknown
+	node.Lag = 0
+	nm.notifyTopologyChange()
+	return nil
+}
+
+// retireOrder ranks followers by how little is lost by removing them: empty
+// slots first, then nodes that are down, then those still catching up, and
+// finally serving followers, the most lagging first.
+func retireOrder(followers []cluster.NodeInfo) {
+	rank := func(node cluster.NodeInfo) int {
+		switch node.Status {
+		case cluster.NodeStatusUnregistered:
+			return 0
+		case cluster.NodeStatusFailed:
+			return 1
+		case cluster.NodeStatusMaintenance:
+			return 2
+		case cluster.NodeStatusSyncing:
+			return 3
+		default:
+			return 4
+		}
+	}
+	sort.SliceStable(followers, func(i, j int) bool {
+		ri, rj := rank(followers[i]), rank(followers[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return followers[i].Lag > followers[j].Lag
+	})
+}
+
+// SetReplicas changes the replication factor of one shard at runtime. A
+// negative shardKey changes every shard and the default for shards created
+// later.
+func (c *KvController) SetReplicas(shardKey, replicas int) ([]api.ReplicaChange, error) {
+	if replicas < 1 {
+		return nil, fmt.Errorf("replicas must be at least 1")
+	}
+
+	keys := []int{shardKey}
+	if shardKey < 0 {
+		keys = c.NodeManager.setDefaultReplicas(replicas)
+	}
+
+	changes := make([]api.ReplicaChange, 0, len(keys))
+	var failed []string
+	for _, key := range keys {
+		change, err := c.resizeShard(key, replicas)
+		if err != nil {
+			if shardKey >= 0 {
+				return nil, err
+			}
+			failed = append(failed, err.Error())
+			continue
+		}
+		changes = append(changes, change)
+	}
+	if len(failed) > 0 {
+		return changes, fmt.Errorf("failed to resize some shards: %s", strings.Join(failed, "; "))
+	}
+	return changes, nil
+}
+
+// resizeShard adds or retires followers until the shard has the given number
+// of members. New followers are taken from the spare pool and bootstrap from
+// the master, empty slots are provisioned for registering nodes when the pool
+// runs dry. The master is never retired.
+func (c *KvController) resizeShard(shardKey, replicas int) (api.ReplicaChange, error) {
+	previous, members, err := c.NodeManager.setReplicas(shardKey, replicas)
+	if err != nil {
+		return api.ReplicaChange{}, err
+	}
+	change := api.ReplicaChange{
+		ShardKey: shardKey,
+		From:     previous,
+		To:       replicas,
+		Added:    make([]int, 0),
+		Slots:    make([]int, 0),
+		Removed:  make([]int, 0),
+	}
+
+	for missing := replicas - len(members); missing > 0; missing-- {
+		spare, master, ok := c.NodeManager.adoptSpare(shardKey)
+		if !ok {
+			slot, err := c.NodeManager.AddNodeSlot(shardKey)
+			if err != nil {
+				return change, err
+			}
+			change.Slots = append(change.Slots, slot.ID)
+			continue
+		}
+		if err := c.RangeManager.assignShard(spare, shardKey, cluster.NodeTypeFollower, &master); err != nil {
+			_ = c.NodeManager.detachToSpare(spare.ID)
+			return change, fmt.Errorf("failed to assign spare node %d to shard %d: %v", spare.ID, shardKey, err)
+		}
+		c.HealthManager.trackNode(spare.ID)
+		change.Added = append(change.Added, spare.ID)
+	}
+
+	var followers []cluster.NodeInfo
+	for _, member := range members {
+		if member.StoreNodeType != cluster.NodeTypeMaster {
+			followers = append(followers, member)
+		}
+	}
+	retireOrder(followers)
+	for surplus := len(members) - replicas; surplus > 0 && len(followers) > 0; surplus-- {
+		node := followers[0]
+		followers = followers[1:]
+		if err := c.retireFollower(node); err != nil {
+			return change, err
+		}
+		change.Removed = append(change.Removed, node.ID)
+	}
+
+	if len(change.Added)+len(change.Slots)+len(change.Removed) > 0 || previous != replicas {
+		logrus.WithFields(logrus.Fields{
+			"shard":   shardKey,
+			"from":    previous,
+			"to":      replicas,
+			"added":   change.Added,
+			"slots":   change.Slots,
+			"removed": change.Removed,
+		}).Info("Shard replication factor changed")
+		c.NodeManager.events.Record(api.Event{
+			Type:     api.EventReplicasChanged,
+			NodeID:   -1,
+			ShardKey: shardKey,
+			Actor:    actorAdmin,
+			Details: map[string]string{
+				"from":    fmt.Sprint(previous),
+				"to":      fmt.Sprint(replicas),
+				"added":   fmt.Sprint(change.Added),
+				"slots":   fmt.Sprint(change.Slots),
+				"removed": fmt.Sprint(change.Removed),
+			},
+		})
+	}
+	return change, nil
+}
+
+// retireFollower takes a surplus follower out of its shard. Empty and failed
+// slots are released, live nodes go back to the spare pool and drop their copy.
+// A node in maintenance goes back to the pool too, still in maintenance, so it
+// is only adopted again once it left it.
+func (c *KvController) retireFollower(node cluster.NodeInfo) error {
+	switch node.Status {
+	case cluster.NodeStatusUnregistered, cluster.NodeStatusFailed:
+		return c.NodeManager.ReleaseNode(node.ID)
+	}
+
+	if err := c.NodeManager.detachToSpare(node.ID); err != nil {
+		return err
+	}
+	if err := c.RangeManager.assignShard(node, -1, cluster.NodeTypeUnknown, nil); err != nil {
+		logrus.WithError(err).WithField("node", node.ID).Warn("Failed to return retired follower to the spare pool")
+	}
+	return nil
+}
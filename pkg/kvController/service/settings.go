@@ -0,0 +1,255 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+// Components that report the settings version they run.
+const (
+	componentNode         = "node"
+	componentLoadBalancer = "loadbalancer"
+)
+
+// SettingsManager keeps the versioned runtime settings document and the
+// version each component reported running.
+type SettingsManager struct {
+	nodeManager *NodeManager
+
+	mu       sync.Mutex
+	document api.SettingsDocument
+	applied  map[string]api.ComponentSettings
+}
+
+func NewSettingsManager(nodeManager *NodeManager) *SettingsManager {
+	return &SettingsManager{
+		nodeManager: nodeManager,
+		document: api.SettingsDocument{
+			Version:       1,
+			Updated:       time.Now(),
+			NodeOverrides: make(map[int]api.RuntimeSettings),
+		},
+		applied: make(map[string]api.ComponentSettings),
+	}
+}
+
+// validateSettings rejects values no component could apply.
+func validateSettings(s api.RuntimeSettings) error {
+	if s.LogLevel != nil {
+		if _, err := logrus.ParseLevel(*s.LogLevel); err != nil {
+			return fmt.Errorf("invalid log_level %q", *s.LogLevel)
+		}
+	}
+	if s.HTTPTimeoutMs != nil && *s.HTTPTimeoutMs <= 0 {
+		return fmt.Errorf("invalid http_timeout_ms: must be positive")
+	}
+	if s.HeartbeatIntervalMs != nil && *s.HeartbeatIntervalMs <= 0 {
+		return fmt.Errorf("invalid heartbeat_interval_ms: must be positive")
+	}
+	if s.HotKeySampleRate != nil && (*s.HotKeySampleRate <= 0 || *s.HotKeySampleRate > 1) {
+		return fmt.Errorf("invalid hot_key_sample_rate: must be in (0, 1]")
+	}
+	if s.TopologyPollIntervalMs != nil && *s.TopologyPollIntervalMs <= 0 {
+		return fmt.Errorf("invalid topology_poll_interval_ms: must be positive")
+	}
+	return nil
+}
+
+// Document returns a copy of the settings document.
+func (sm *SettingsManager) Document() api.SettingsDocument {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	document := sm.document
+	document.NodeOverrides = make(map[int]api.RuntimeSettings, len(sm.document.NodeOverrides))
+	for id, override := range sm.document.NodeOverrides {
+		document.NodeOverrides[id] = override
+	}
+	return document
+}
+
+// Version returns the current settings version.
+func (sm *SettingsManager) Version() int64 {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.document.Version
+}
+
+// ForNode returns the settings in effect on a node, a negative ID gets the
+// cluster-wide settings.
+func (sm *SettingsManager) ForNode(nodeID int) api.AppliedSettings {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return api.AppliedSettings{
+		Version:  sm.document.Version,
+		Settings: sm.document.Settings.Merge(sm.document.NodeOverrides[nodeID]),
+	}
+}
+
+// update applies change to the document and bumps its version.
+func (sm *SettingsManager) update(change func(*api.SettingsDocument) error) (api.SettingsDocument, error) {
+	sm.mu.Lock()
+	if err := change(&sm.document); err != nil {
+		sm.mu.Unlock()
+		return api.SettingsDocument{}, err
+	}
+	sm.document.Version++
+	sm.document.Updated = time.Now()
+	version := sm.document.Version
+	sm.mu.Unlock()
+
+	// Routers learn about new settings through the topology. A concurrent
+	// update may have published a newer version already
+	sm.nodeManager.mutex.Lock()
+	if version > sm.nodeManager.settingsVersion {
+		sm.nodeManager.settingsVersion = version
+		sm.nodeManager.notifyTopologyChange()
+	}
+	sm.nodeManager.mutex.Unlock()
+
+	logrus.WithField("version", version).Info("Runtime settings changed")
+	return sm.Document(), nil
+}
+
+// SetClusterSettings replaces the cluster-wide settings.
+func (sm *SettingsManager) SetClusterSettings(settings api.RuntimeSettings) (api.SettingsDocument, error) {
+	if err := validateSettings(settings); err != nil {
+		return api.SettingsDocument{}, err
+	}
+	return sm.update(func(d *api.SettingsDocument) error {
+		d.Settings = settings
+		return nil
+	})
+}
+
+// SetNodeOverride replaces the settings overridden on one node.
+func (sm *SettingsManager) SetNodeOverride(nodeID int, settings api.RuntimeSettings) (api.SettingsDocument, error) {
+	if err := validateSettings(settings); err != nil {
+		return api.SettingsDocument{}, err
+	}
+	if _, err := sm.nodeManager.GetNodeInfo(nodeID); err != nil {
+		return api.SettingsDocument{}, err
+	}
+	return sm.update(func(d *api.SettingsDocument) error {
+		d.NodeOverrides[nodeID] = settings
+		return nil
+	})
+}
+
+// ClearNodeOverride makes a node follow the cluster-wide settings again.
+func (sm *SettingsManager) ClearNodeOverride(nodeID int) (api.SettingsDocument, error) {
+	return sm.update(func(d *api.SettingsDocument) error {
+		if _, ok := d.NodeOverrides[nodeID]; !ok {
+			return fmt.Errorf("settings override of node %d not found", nodeID)
+		}
+		delete(d.NodeOverrides, nodeID)
+		return nil
+	})
+}
+
+// report records the settings version a component runs.
+func (sm *SettingsManager) report(component, id string, version int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.applied[component+"/"+id] = api.ComponentSettings{
+		Component: component,
+		ID:        id,
+		Version:   version,
+		Reported:  time.Now(),
+	}
+}
+
+// Status lists the settings version every component last reported.
+func (sm *SettingsManager) Status() api.SettingsStatus {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	status := api.SettingsStatus{
+		Version:    sm.document.Version,
+		Components: make([]api.ComponentSettings, 0, len(sm.applied)),
+	}
+	for _, c := range sm.applied {
+		c.Current = c.Version == sm.document.Version
+		status.Components = append(status.Components, c)
+	}
+	sort.Slice(status.Components, func(i, j int) bool {
+		a, b := status.Components[i], status.Components[j]
+		if a.Component != b.Component {
+			return a.Component < b.Component
+		}
+		ai, errA := strconv.Atoi(a.ID)
+		bi, errB := strconv.Atoi(b.ID)
+		if errA == nil && errB == nil {
+			return ai < bi
+		}
+		return a.ID < b.ID
+	})
+	return status
+}
+
+// publishSettings pushes the settings in effect on every live node so changes
+// apply at once. Nodes that miss it fetch them after their next heartbeat.
+func (c *KvController) publishSettings() {
+	for _, node := range c.NodeManager.nodeSnapshot() {
+		switch node.Status {
+		case cluster.NodeStatusUnregistered, cluster.NodeStatusFailed, cluster.NodeStatusDecommissioned:
+			continue
+		}
+		if err := c.RangeManager.postJSON(node, "/settings", c.Settings.ForNode(node.ID)); err != nil {
+			logrus.WithError(err).WithField("node", node.ID).Warn("Failed to push runtime settings, the node syncs on its next heartbeat")
+		}
+	}
+}
+
+func (c *KvController) GetSettings() api.SettingsDocument {
+	return c.Settings.Document()
+}
+
+func (c *KvController) SetClusterSettings(settings api.RuntimeSettings) (api.SettingsDocument, error) {
+	document, err := c.Settings.SetClusterSettings(settings)
+	if err != nil {
+		return document, err
+	}
+	c.publishSettings()
+	return document, nil
+}
+
+func (c *KvController) SetNodeSettings(nodeID int, settings api.RuntimeSettings) (api.SettingsDocument, error) {
+	document, err := c.Settings.SetNodeOverride(nodeID, settings)
+	if err != nil {
+		return document, err
+	}
+	c.publishSettings()
+	return document, nil
+}
+
+func (c *KvController) ClearNodeSettings(nodeID int) (api.SettingsDocument, error) {
+	document, err := c.Settings.ClearNodeOverride(nodeID)
+	if err != nil {
+		return document, err
+	}
+	c.publishSettings()
+	return document, nil
+}
+
+func (c *KvController) GetSettingsStatus() api.SettingsStatus {
+	return c.Settings.Status()
+}
+
+// GetAppliedSettings returns the settings in effect on a node, a negative ID
+// gets the cluster-wide settings load balancers run.
+func (c *KvController) GetAppliedSettings(nodeID int) api.AppliedSettings {
+	return c.Settings.ForNode(nodeID)
+}
+
+// AckSettings records the settings version a load balancer applied.
+func (c *KvController) AckSettings(ack api.SettingsAck) {
+	c.Settings.report(componentLoadBalancer, ack.ID, ack.Version)
+}
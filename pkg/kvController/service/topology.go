@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"sort"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// notifyTopologyChange publishes a new topology version and wakes up every
+// watcher. Must be called with the lock held.
+func (nm *NodeManager) notifyTopologyChange() {
+	nm.topologyVersion++
+	close(nm.topologyChanged)
+	nm.topologyChanged = make(chan struct{})
+}
+
+// topology builds the current snapshot. Must be called with the lock held.
+func (nm *NodeManager) topology() api.Topology {
+	t := api.Topology{
+		Incarnation: nm.incarnation,
+		Version:     nm.topologyVersion,
+		Partitions:  nm.partitionMap,
+		Shards:      make([]api.ShardTopology, 0, len(nm.ShardMap)),
+		Keyspaces:   nm.keyspaceList(),
+
+		SettingsVersion: nm.settingsVersion,
+	}
+	t.Partitions.Shards = slices.Clone(nm.partitionMap.Shards)
+	t.Partitions.Ranges = slices.Clone(nm.partitionMap.Ranges)
+
+	for key, shardInfo := range nm.ShardMap {
+		shard := api.ShardTopology{
+			ShardKey:  key,
+			Epoch:     shardInfo.Epoch,
+			Replicas:  shardInfo.Replicas,
+			Followers: make([]cluster.NodeInfo, 0, len(shardInfo.Followers)),
+		}
+		if shardInfo.Master != nil {
+			master := *shardInfo.Master
+			shard.Master = &master
+		}
+		for _, f := range shardInfo.Followers {
+			shard.Followers = append(shard.Followers, *f)
+		}
+		t.Shards = append(t.Shards, shard)
+	}
+	sort.Slice(t.Shards, func(i, j int) bool {
+		return t.Shards[i].ShardKey < t.Shards[j].ShardKey
+	})
+	return t
+}
+
+// Topology returns the current topology snapshot.
+func (nm *NodeManager) Topology() api.Topology {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	return nm.topology()
+}
+
+// WatchTopology blocks until the topology is newer than version or ctx is done.
+// It returns the latest snapshot and whether it is newer than version. A
+// watcher that knows another incarnation gets the current snapshot right away.
+func (nm *NodeManager) WatchTopology(ctx context.Context, incarnation string, version int64) (api.Topology, bool) {
+	for {
+		nm.mutex.Lock()
+		if incarnation != nm.incarnation || nm.topologyVersion > version {
+			t := nm.topology()
+			nm.mutex.Unlock()
+			return t, true
+		}
+		changed := nm.topologyChanged
+		nm.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nm.Topology(), false
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package kvLoadbalancer
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/ratelimit"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/pkg/kvLoadbalancer/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Client identities rate limits can be keyed by.
+const (
+	keyByAPIKey   = "api_key"
+	keyByIP       = "ip"
+	keyByKeyspace = "keyspace"
+)
+
+// idleBucketTTL is how long the bucket of a client without requests is kept.
+const idleBucketTTL = 10 * time.Minute
+
+// clientBucket is the token bucket of one client.
+type clientBucket struct {
+	bucket   *ratelimit.Bucket
+	lastUsed time.Time
+}
+
+// admission rate limits every client with its own token bucket and caps the
+// requests in flight across all clients, so load is shed at the load balancer
+// before it piles up on the nodes.
+type admission struct {
+	mu           sync.Mutex
+	keyBy        string
+	defaultLimit config.ClientLimit
+	limits       map[string]config.ClientLimit
+	buckets      map[string]*clientBucket
+	lastSweep    time.Time
+	// slots holds a token per request in flight, nil is unlimited
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	rateLimited atomic.Int64
+	shed        atomic.Int64
+}
+
+func newAdmission(cfg config.RateLimitConfig) *admission {
+	a := &admission{buckets: make(map[string]*clientBucket), lastSweep: time.Now()}
+	a.update(cfg)
+	return a
+}
+
+// update applies a new rate limit config. Buckets keep their tokens, requests
+// in flight keep the slot they hold.
+func (a *admission) update(cfg config.RateLimitConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.keyBy = cfg.KeyBy
+	switch a.keyBy {
+	case keyByAPIKey, keyByIP, keyByKeyspace:
+	default:
+		a.keyBy = keyByIP
+	}
+	a.defaultLimit = cfg.Default
+	a.limits = make(map[string]config.ClientLimit, len(cfg.Clients))
+	for _, limit := range cfg.Clients {
+		a.limits[limit.ID] = limit
+	}
+	for id, b := range a.buckets {
+		limit := a.limitOf(id)
+		b.bucket.SetRate(limit.Rate, limit.Burst)
+	}
+
+	if cfg.MaxInFlight <= 0 {
+		a.slots = nil
+	} else if a.slots == nil || cap(a.slots) != cfg.MaxInFlight {
+		a.slots = make(chan struct{}, cfg.MaxInFlight)
+	}
+	a.queueTimeout = time.Duration(cfg.QueueTimeoutMs) * time.Millisecond
+}
+
+// limitOf returns the limit of a client. Must be called with the lock held.
+func (a *admission) limitOf(id string) config.ClientLimit {
+	if limit, ok := a.limits[id]; ok {
+		return limit
+	}
+	return a.defaultLimit
+}
+
+// identify returns the ID of the client a request is charged to. Requests
+// without an API key are charged to their source IP.
+func (a *admission) identify(client api.ClientIdentity) string {
+	switch a.keyBy {
+	case keyByAPIKey:
+		if client.APIKey != "" {
+			return client.APIKey
+		}
+	case keyByKeyspace:
+		if client.Keyspace == "" {
+			return apiTypes.DefaultKeyspace
+		}
+		return client.Keyspace
+	}
+	return client.IP
+}
+
+// bucket returns the bucket of a client, dropping buckets of idle clients now
+// and then. Must be called with the lock held.
+func (a *admission) bucket(id string, now time.Time) *ratelimit.Bucket {
+	if now.Sub(a.lastSweep) > idleBucketTTL {
+		for other, b := range a.buckets {
+			if now.Sub(b.lastUsed) > idleBucketTTL {
+				delete(a.buckets, other)
+			}
+		}
+		a.lastSweep = now
+	}
+
+	b, ok := a.buckets[id]
+	if !ok {
+		limit := a.limitOf(id)
+		b = &clientBucket{bucket: ratelimit.NewBucket(limit.Rate, limit.Burst)}
+		a.buckets[id] = b
+	}
+	b.lastUsed = now
+	return b.bucket
+}
+
+// Admit charges a request to its client's rate limit and takes a slot for it.
+// release must be called once the request is done.
+func (s *LoadBalancerService) Admit(client api.ClientIdentity) (func(), error) {
+	a := s.admission
+	a.mu.Lock()
+	id := a.identify(client)
+	bucket := a.bucket(id, time.Now())
+	slots, queueTimeout := a.slots, a.queueTimeout
+	a.mu.Unlock()
+
+	if allowed, retryAfter := bucket.Allow(); !allowed {
+		a.rateLimited.Add(1)
+		return nil, &api.StatusError{
+			Code:       http.StatusTooManyRequests,
+			Message:    fmt.Sprintf("client %q is over its rate limit, retry after %s", id, retryAfter),
+			RetryAfter: retryAfter,
+		}
+	}
+
+	if slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if queueTimeout > 0 {
+		timer := time.NewTimer(queueTimeout)
+		defer timer.Stop()
+		select {
+		case slots <- struct{}{}:
+			return release, nil
+		case <-timer.C:
+		}
+	}
+	a.shed.Add(1)
+	return nil, &api.StatusError{
+		Code:       http.StatusServiceUnavailable,
+		Message:    "load balancer is overloaded",
+		RetryAfter: time.Second,
+	}
+}
+
+// ReloadRateLimits applies the rate limits of a reloaded config.
+func (s *LoadBalancerService) ReloadRateLimits(cfg config.RateLimitConfig) {
+	s.admission.update(cfg)
+	log.WithFields(log.Fields{
+		"keyBy":       cfg.KeyBy,
+		"clients":     len(cfg.Clients),
+		"maxInFlight": cfg.MaxInFlight,
+	}).Info("Reloaded rate limits")
+}
+
+// Admission returns the admission control counters.
+func (s *LoadBalancerService) Admission() apiTypes.AdmissionStats {
+	a := s.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := apiTypes.AdmissionStats{
+		KeyBy:       a.keyBy,
+		Clients:     len(a.buckets),
+		RateLimited: a.rateLimited.Load(),
+		Shed:        a.shed.Load(),
+	}
+	if a.slots != nil {
+		stats.InFlight = len(a.slots)
+		stats.MaxInFlight = cap(a.slots)
+	}
+	return stats
+}
@@ -2,25 +2,83 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/Amirali-Amirifar/kv/internal/types/api"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
 
 type Service interface {
-	Get(key string) (string, error)
-	Set(key, value string) error
-	Del(key string) error
+	Get(ctx context.Context, req api.GetRequest) (string, error)
+	Set(ctx context.Context, keyspace, key, value string) error
+	Del(ctx context.Context, keyspace, key string) error
+	NodePools() []api.NodePoolStats
+	Hedging() api.HedgingStats
+	Admit(client ClientIdentity) (func(), error)
+	Admission() api.AdmissionStats
+	Cache() api.CacheStats
+	Coalescing() api.CoalescingStats
+	Ready() error
+	RoutingTable() (api.RoutingTable, error)
 	//UpdateNodeData() error
 }
 
+// ClientIdentity is who a request is charged to by the rate limits.
+type ClientIdentity struct {
+	APIKey   string
+	IP       string
+	Keyspace string
+}
+
+// ErrCircuitOpen is the cause of requests short-circuited by the open circuit
+// breaker of a node.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// StatusError is an error answered with a specific HTTP status, such as one
+// relayed from a node. Err is its cause, if any, Redirect where a node sent
+// the request instead.
+type StatusError struct {
+	Code       int
+	Message    string
+	RetryAfter time.Duration
+	Redirect   *api.Redirect
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// writeError answers with the status carried by err, 500 for other errors.
+func writeError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		status = statusErr.Code
+		if statusErr.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(statusErr.RetryAfter.Seconds()))))
+		}
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
 type HTTPServer struct {
 	svc    Service
 	router *gin.Engine
+	server *http.Server
 }
 
 func NewHTTPServer(svc Service) *HTTPServer {
@@ -28,14 +86,26 @@ func NewHTTPServer(svc Service) *HTTPServer {
 	return &HTTPServer{
 		svc:    svc,
 		router: router,
+		server: &http.Server{Handler: router},
 	}
 }
 
+// Serve serves requests on port until Shutdown.
 func (s *HTTPServer) Serve(port int) error {
 	s.registerRoutes()
 	log.Printf("Listening to connections on HTTP, Port: %d\n", port)
 
-	return s.router.Run(":" + strconv.Itoa(port))
+	s.server.Addr = ":" + strconv.Itoa(port)
+	if err := s.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for the requests in flight
+// until ctx ends.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
 }
 
 func (s *HTTPServer) registerRoutes() {
@@ -60,10 +130,43 @@ func (s *HTTPServer) registerRoutes() {
 
 		c.Next()
 	})
-	s.router.POST("/get", s.handleGet)
-	s.router.POST("/set", s.handleSet)
-	s.router.POST("/del", s.handleDel)
+	ops := s.router.Group("/", s.admit)
+	ops.POST("/get", s.handleGet)
+	ops.POST("/set", s.handleSet)
+	ops.POST("/del", s.handleDel)
 	s.router.POST("/health", s.handleHealth)
+	s.router.GET("/health", s.handleHealth)
+	s.router.GET("/ready", s.handleReady)
+	s.router.GET("/routing", s.handleRoutingTable)
+	s.router.GET("/stats/nodes", s.handleNodeStats)
+	s.router.GET("/stats/hedging", s.handleHedgingStats)
+	s.router.GET("/stats/admission", s.handleAdmissionStats)
+	s.router.GET("/stats/cache", s.handleCacheStats)
+	s.router.GET("/stats/coalescing", s.handleCoalescingStats)
+}
+
+// admit rejects requests over their client's rate limit or while the load
+// balancer is overloaded.
+func (s *HTTPServer) admit(c *gin.Context) {
+	client := ClientIdentity{APIKey: c.GetHeader("X-API-Key"), IP: c.ClientIP()}
+	// The body was buffered by the logging middleware
+	if body, err := io.ReadAll(c.Request.Body); err == nil {
+		var req struct {
+			Keyspace string `json:"keyspace"`
+		}
+		_ = json.Unmarshal(body, &req)
+		client.Keyspace = req.Keyspace
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	}
+
+	release, err := s.svc.Admit(client)
+	if err != nil {
+		writeError(c, err)
+		c.Abort()
+		return
+	}
+	defer release()
+	c.Next()
 }
 
 // handleGet processes GET requests
@@ -74,9 +177,9 @@ func (s *HTTPServer) handleGet(c *gin.Context) {
 		return
 	}
 
-	value, err := s.svc.Get(req.Key)
+	value, err := s.svc.Get(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
@@ -91,8 +194,8 @@ func (s *HTTPServer) handleSet(c *gin.Context) {
 		return
 	}
 
-	if err := s.svc.Set(req.Key, req.Value); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.svc.Set(c.Request.Context(), req.Keyspace, req.Key, req.Value); err != nil {
+		writeError(c, err)
 		return
 	}
 
@@ -107,8 +210,8 @@ func (s *HTTPServer) handleDel(c *gin.Context) {
 		return
 	}
 
-	if err := s.svc.Del(req.Key); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.svc.Del(c.Request.Context(), req.Keyspace, req.Key); err != nil {
+		writeError(c, err)
 		return
 	}
 
@@ -123,3 +226,56 @@ func (s *HTTPServer) UpdateNodeData(c *gin.Context) (string, error) {
 func (s *HTTPServer) handleHealth(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
+
+// handleReady answers 200 while the load balancer should receive traffic: it
+// has synced the topology and is not draining.
+func (s *HTTPServer) handleReady(c *gin.Context) {
+	if err := s.svc.Ready(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "reason": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// handleNodeStats lists the connection pool stats of every node.
+func (s *HTTPServer) handleNodeStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"nodes": s.svc.NodePools()})
+}
+
+// handleHedgingStats reports the hedged read counters.
+func (s *HTTPServer) handleHedgingStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.svc.Hedging())
+}
+
+// handleAdmissionStats reports the admission control counters.
+func (s *HTTPServer) handleAdmissionStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.svc.Admission())
+}
+
+// handleCacheStats reports the read cache counters.
+func (s *HTTPServer) handleCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.svc.Cache())
+}
+
+// handleCoalescingStats reports how many reads shared a request to the nodes.
+func (s *HTTPServer) handleCoalescingStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.svc.Coalescing())
+}
+
+// handleRoutingTable serves the routing table for smart clients to cache,
+// tagged with its incarnation and version so they can revalidate it.
+func (s *HTTPServer) handleRoutingTable(c *gin.Context) {
+	table, err := s.svc.RoutingTable()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	etag := fmt.Sprintf("%q", table.Incarnation+"-"+strconv.FormatInt(table.Version, 10))
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, table)
+}
@@ -0,0 +1,299 @@
+package kvLoadbalancer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/pkg/kvLoadbalancer/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// outcome is how a request counts towards a circuit breaker.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnored is a request the client gave up on, it says nothing about the node
+	outcomeIgnored
+)
+
+// breakerOutcome tells how a request to a node ending in err counts. Only
+// failures of the node itself count against it, not answers such as a missing
+// key, and requests the client abandoned (ctx) do not count at all.
+func breakerOutcome(ctx context.Context, err error) outcome {
+	if err == nil {
+		return outcomeSuccess
+	}
+	if ctx.Err() != nil {
+		return outcomeIgnored
+	}
+	var statusErr *api.StatusError
+	if errors.As(err, &statusErr) && (statusErr.Code < 500 || statusErr.Code == http.StatusInsufficientStorage) {
+		return outcomeSuccess
+	}
+	return outcomeFailure
+}
+
+// breakerPolicy is when circuit breakers open and how they recover.
+type breakerPolicy struct {
+	windowSize        int
+	minRequests       int
+	errorRate         float64
+	latencyPercentile float64
+	latencyThreshold  time.Duration
+	openFor           time.Duration
+	halfOpenProbes    int
+}
+
+func newBreakerPolicy(cfg config.BreakerConfig) breakerPolicy {
+	policy := breakerPolicy{
+		windowSize:        cfg.WindowSize,
+		minRequests:       cfg.MinRequests,
+		errorRate:         cfg.ErrorRate,
+		latencyPercentile: cfg.LatencyPercentile,
+		latencyThreshold:  time.Duration(cfg.LatencyThresholdMs) * time.Millisecond,
+		openFor:           time.Duration(cfg.OpenMs) * time.Millisecond,
+		halfOpenProbes:    cfg.HalfOpenProbes,
+	}
+	if policy.windowSize <= 0 {
+		policy.windowSize = 100
+	}
+	if policy.minRequests <= 0 {
+		policy.minRequests = 20
+	}
+	policy.minRequests = min(policy.minRequests, policy.windowSize)
+	if policy.errorRate <= 0 || policy.errorRate > 1 {
+		policy.errorRate = 0.5
+	}
+	if policy.latencyPercentile <= 0 || policy.latencyPercentile > 1 {
+		policy.latencyPercentile = 0.99
+	}
+	if policy.latencyThreshold <= 0 {
+		policy.latencyThreshold = time.Second
+	}
+	if policy.openFor <= 0 {
+		policy.openFor = 5 * time.Second
+	}
+	if policy.halfOpenProbes <= 0 {
+		policy.halfOpenProbes = 3
+	}
+	return policy
+}
+
+// sample is the outcome of one request in a breaker's window.
+type sample struct {
+	latency time.Duration
+	failed  bool
+}
+
+// breaker is the circuit breaker of one node. It opens when too many of the
+// recent requests fail or the latency percentile gets too slow, rejects
+// requests while open, and after a while lets a few probes through half-open:
+// it closes when they all succeed and opens again as soon as one fails.
+type breaker struct {
+	policy   breakerPolicy
+	onChange func()
+
+	mu    sync.Mutex
+	state apiTypes.BreakerState
+	// generation is bumped by every transition, requests allowed in an
+	// earlier one are not counted
+	generation uint64
+	since      time.Time
+	window     []sample
+	next       int
+	probes     int
+	successes  int
+	// tripErrorRate and tripLatency are what opened the breaker last
+	tripErrorRate float64
+	tripLatency   time.Duration
+}
+
+func newBreaker(policy breakerPolicy, onChange func()) *breaker {
+	return &breaker{
+		policy:   policy,
+		onChange: onChange,
+		state:    apiTypes.BreakerClosed,
+		since:    time.Now(),
+		window:   make([]sample, 0, policy.windowSize),
+	}
+}
+
+// allow tells whether a request may go to the node, and otherwise how long
+// the breaker stays open. An allowed request must be recorded with the
+// generation returned.
+func (b *breaker) allow() (uint64, bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == apiTypes.BreakerOpen {
+		remaining := b.policy.openFor - time.Since(b.since)
+		if remaining > 0 {
+			return 0, false, remaining
+		}
+		b.transition(apiTypes.BreakerHalfOpen)
+	}
+	if b.state == apiTypes.BreakerHalfOpen {
+		if b.probes+b.successes >= b.policy.halfOpenProbes {
+			return 0, false, b.policy.openFor
+		}
+		b.probes++
+	}
+	return b.generation, true, 0
+}
+
+// record counts the outcome of a request allowed at generation. Requests
+// allowed before the last transition are not counted, so only the probes of
+// the current half-open state settle it.
+func (b *breaker) record(generation uint64, latency time.Duration, result outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case apiTypes.BreakerHalfOpen:
+		b.probes--
+		switch {
+		case result == outcomeIgnored:
+		case result == outcomeFailure || latency >= b.policy.latencyThreshold:
+			b.tripErrorRate, b.tripLatency = 0, latency
+			if result == outcomeFailure {
+				b.tripErrorRate = 1
+			}
+			b.transition(apiTypes.BreakerOpen)
+		default:
+			b.successes++
+			if b.successes >= b.policy.halfOpenProbes {
+				b.transition(apiTypes.BreakerClosed)
+			}
+		}
+	case apiTypes.BreakerClosed:
+		if result == outcomeIgnored {
+			return
+		}
+		s := sample{latency: latency, failed: result == outcomeFailure}
+		if len(b.window) < b.policy.windowSize {
+			b.window = append(b.window, s)
+		} else {
+			b.window[b.next] = s
+			b.next = (b.next + 1) % b.policy.windowSize
+		}
+		if len(b.window) < b.policy.minRequests {
+			return
+		}
+		if errorRate, latency := b.measure(); errorRate >= b.policy.errorRate || latency >= b.policy.latencyThreshold {
+			b.tripErrorRate, b.tripLatency = errorRate, latency
+			b.transition(apiTypes.BreakerOpen)
+		}
+	}
+}
+
+// transition moves the breaker to state with a fresh window. Must be called
+// with the lock held.
+func (b *breaker) transition(state apiTypes.BreakerState) {
+	b.state = state
+	b.generation++
+	b.since = time.Now()
+	b.window = b.window[:0]
+	b.next = 0
+	b.probes = 0
+	b.successes = 0
+	if b.onChange != nil {
+		go b.onChange()
+	}
+}
+
+// measure returns the error rate and latency percentile of the window. Must be
+// called with the lock held.
+func (b *breaker) measure() (float64, time.Duration) {
+	if len(b.window) == 0 {
+		return 0, 0
+	}
+	failures := 0
+	latencies := make([]time.Duration, len(b.window))
+	for i, s := range b.window {
+		if s.failed {
+			failures++
+		}
+		latencies[i] = s.latency
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	index := int(math.Ceil(b.policy.latencyPercentile*float64(len(latencies)))) - 1
+	return float64(failures) / float64(len(b.window)), latencies[max(index, 0)]
+}
+
+// snapshot returns the state of the breaker for a report, with the error rate
+// and latency of its window or, unless closed, those that opened it.
+func (b *breaker) snapshot() (apiTypes.BreakerState, time.Time, float64, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != apiTypes.BreakerClosed {
+		return b.state, b.since, b.tripErrorRate, b.tripLatency
+	}
+	errorRate, latency := b.measure()
+	return b.state, b.since, errorRate, latency
+}
+
+// breakerChange asks for a breaker report without waiting for the next interval.
+func (s *LoadBalancerService) breakerChange() {
+	select {
+	case s.breakerChanged <- struct{}{}:
+	default:
+	}
+}
+
+// reportBreakers reports the circuit breakers to the controller periodically
+// and whenever one changes state, the controller counts open breakers against
+// the health of their node.
+func (s *LoadBalancerService) reportBreakers() {
+	interval := time.Duration(s.config.Breaker.ReportIntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.breakerChanged:
+		}
+		if err := s.sendBreakerReport(); err != nil {
+			log.WithError(err).Warn("Failed to report circuit breakers")
+		}
+	}
+}
+
+func (s *LoadBalancerService) sendBreakerReport() error {
+	body, err := json.Marshal(apiTypes.BreakerReport{LoadBalancer: s.id(), Breakers: s.pools.breakers()})
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient().Post(
+		fmt.Sprintf("http://%s:%d/internal/breakers", s.config.Controller.Host, s.config.Controller.Port),
+		"application/json",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received status code %d reporting circuit breakers", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package kvLoadbalancer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/pkg/kvLoadbalancer/api"
+)
+
+func newTestBreaker() *breaker {
+	return newBreaker(newBreakerPolicy(config.BreakerConfig{
+		WindowSize:         4,
+		MinRequests:        4,
+		ErrorRate:          0.5,
+		LatencyThresholdMs: 100,
+		OpenMs:             1000,
+		HalfOpenProbes:     2,
+	}), nil)
+}
+
+// expire makes the open period of b run out.
+func expire(b *breaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.since = b.since.Add(-time.Hour)
+}
+
+func (b *breaker) currentState() apiTypes.BreakerState {
+	state, _, _, _ := b.snapshot()
+	return state
+}
+
+func (b *breaker) allowed() (bool, time.Duration) {
+	_, ok, retryAfter := b.allow()
+	return ok, retryAfter
+}
+
+// call lets one request through b and records its outcome.
+func call(t *testing.T, b *breaker, result outcome) {
+	t.Helper()
+	generation, ok, _ := b.allow()
+	if !ok {
+		t.Fatalf("request rejected while %s", b.currentState())
+	}
+	b.record(generation, time.Millisecond, result)
+}
+
+func tripped(t *testing.T) *breaker {
+	t.Helper()
+	b := newTestBreaker()
+	for _, result := range []outcome{outcomeSuccess, outcomeFailure, outcomeSuccess, outcomeFailure} {
+		call(t, b, result)
+	}
+	if state := b.currentState(); state != apiTypes.BreakerOpen {
+		t.Fatalf("breaker is %s at a 50%% error rate, want open", state)
+	}
+	return b
+}
+
+func TestBreakerOpensAtErrorRate(t *testing.T) {
+	b := newTestBreaker()
+	for i := 0; i < 3; i++ {
+		call(t, b, outcomeFailure)
+	}
+	if state := b.currentState(); state != apiTypes.BreakerClosed {
+		t.Fatalf("breaker is %s below the minimum number of requests", state)
+	}
+
+	b = tripped(t)
+	if ok, retryAfter := b.allowed(); ok || retryAfter <= 0 {
+		t.Fatalf("open breaker allowed = %v, retry after %v", ok, retryAfter)
+	}
+}
+
+func TestBreakerOpensOnSlowRequests(t *testing.T) {
+	b := newTestBreaker()
+	for i := 0; i < 4; i++ {
+		generation, _, _ := b.allow()
+		b.record(generation, 200*time.Millisecond, outcomeSuccess)
+	}
+	if state := b.currentState(); state != apiTypes.BreakerOpen {
+		t.Fatalf("breaker is %s with every request over the latency threshold", state)
+	}
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	b := tripped(t)
+	expire(b)
+
+	call(t, b, outcomeSuccess)
+	if state := b.currentState(); state != apiTypes.BreakerHalfOpen {
+		t.Fatalf("breaker is %s after the first probe, want half-open", state)
+	}
+	generation, ok, _ := b.allow()
+	if !ok {
+		t.Fatal("the second probe was rejected")
+	}
+	if ok, _ := b.allowed(); ok {
+		t.Fatal("more probes than allowed went through")
+	}
+	b.record(generation, time.Millisecond, outcomeSuccess)
+	if state := b.currentState(); state != apiTypes.BreakerClosed {
+		t.Fatalf("breaker is %s after every probe succeeded, want closed", state)
+	}
+
+	b = tripped(t)
+	expire(b)
+	call(t, b, outcomeFailure)
+	if state := b.currentState(); state != apiTypes.BreakerOpen {
+		t.Fatalf("breaker is %s after a probe failed, want open", state)
+	}
+}
+
+func TestBreakerIgnoresRequestsOfAnEarlierState(t *testing.T) {
+	b := newTestBreaker()
+	// Allowed while closed, still running when the breaker opens
+	stale, _, _ := b.allow()
+	for _, result := range []outcome{outcomeFailure, outcomeFailure, outcomeFailure, outcomeFailure} {
+		call(t, b, result)
+	}
+	expire(b)
+
+	probe, ok, _ := b.allow()
+	if !ok {
+		t.Fatal("the first probe was rejected")
+	}
+	b.record(stale, time.Millisecond, outcomeSuccess)
+	b.record(stale, time.Millisecond, outcomeFailure)
+	if state := b.currentState(); state != apiTypes.BreakerHalfOpen {
+		t.Fatalf("breaker is %s after outcomes of requests allowed while closed", state)
+	}
+	// The stale outcomes freed no probe slot
+	if _, ok, _ := b.allow(); !ok {
+		t.Fatal("the second probe was rejected")
+	}
+	if ok, _ := b.allowed(); ok {
+		t.Fatal("a stale outcome freed a probe slot")
+	}
+	b.record(probe, time.Millisecond, outcomeSuccess)
+	if state := b.currentState(); state != apiTypes.BreakerHalfOpen {
+		t.Fatalf("breaker is %s with a probe still out, want half-open", state)
+	}
+}
+
+func TestBreakerOutcome(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want outcome
+	}{
+		{"success", context.Background(), nil, outcomeSuccess},
+		{"unreachable", context.Background(), errors.New("connection refused"), outcomeFailure},
+		{"server error", context.Background(), &api.StatusError{Code: http.StatusInternalServerError}, outcomeFailure},
+		{"missing key", context.Background(), &api.StatusError{Code: http.StatusNotFound}, outcomeSuccess},
+		{"quota", context.Background(), &api.StatusError{Code: http.StatusInsufficientStorage}, outcomeSuccess},
+		{"abandoned", cancelled, errors.New("context canceled"), outcomeIgnored},
+	}
+	for _, tt := range tests {
+		if got := breakerOutcome(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: outcome %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
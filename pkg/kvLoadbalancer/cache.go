@@ -0,0 +1,409 @@
+package kvLoadbalancer
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	log "github.com/sirupsen/logrus"
+)
+
+// cacheKey is a key in a keyspace.
+type cacheKey struct {
+	keyspace string
+	key      string
+}
+
+// cacheEntry is a cached value and the shard it was read from.
+type cacheEntry struct {
+	key     cacheKey
+	value   string
+	shardID int
+	expires time.Time
+}
+
+// walRecord is the part of a master's WAL records the cache is invalidated by.
+type walRecord struct {
+	Operation string
+	Keyspace  string
+	Key       string
+	Seq       int64
+}
+
+// shardTail follows the write stream of one shard's master.
+type shardTail struct {
+	masterID int
+	epoch    int64
+	cancel   context.CancelFunc
+}
+
+// fill tracks the writes to a key while reads of it are pending.
+type fill struct {
+	readers int
+	// written is the clock of the last write to the key seen
+	written uint64
+}
+
+// readTicket is where a read started, to be passed to put.
+type readTicket struct {
+	at      uint64
+	written uint64
+	flushes uint64
+}
+
+// overtakenBy tells whether the key a read started at t was written, or the
+// cache flushed, before a later read of it started at later.
+func (t readTicket) overtakenBy(later readTicket) bool {
+	return later.written != t.written || later.flushes != t.flushes
+}
+
+// readCache is a bounded LRU cache of Get results with a TTL per entry. Every
+// shard master's WAL is tailed to evict keys as soon as they are written, and
+// values read while their key was written or their shard flushed are not
+// cached, so a cached value is never older than the write stream. The TTL
+// bounds how stale an entry can get while the stream lags.
+type readCache struct {
+	enabled      bool
+	maxEntries   int
+	ttl          time.Duration
+	pollInterval time.Duration
+	client       *http.Client
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+	// clock is bumped by every eviction, writes are only tracked for the keys
+	// with reads pending and flushes by shard
+	clock            uint64
+	fills            map[cacheKey]*fill
+	flushed          map[int]uint64
+	flushedAll       uint64
+	flushes          uint64
+	tails            map[int]*shardTail
+	partitionVersion int64
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	evictions     atomic.Int64
+	invalidations atomic.Int64
+}
+
+func newReadCache(cfg config.CacheConfig) *readCache {
+	c := &readCache{
+		enabled:      cfg.Enabled,
+		maxEntries:   cfg.MaxEntries,
+		ttl:          time.Duration(cfg.TTLMs) * time.Millisecond,
+		pollInterval: time.Duration(cfg.InvalidationPollMs) * time.Millisecond,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		entries:      make(map[cacheKey]*list.Element),
+		lru:          list.New(),
+		fills:        make(map[cacheKey]*fill),
+		flushed:      make(map[int]uint64),
+		tails:        make(map[int]*shardTail),
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = 10000
+	}
+	if c.ttl <= 0 {
+		c.ttl = 30 * time.Second
+	}
+	if c.pollInterval <= 0 {
+		c.pollInterval = 100 * time.Millisecond
+	}
+	return c
+}
+
+// get returns the cached value of a key.
+func (c *readCache) get(keyspace, key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[cacheKey{keyspace, key}]
+	if !ok {
+		c.misses.Add(1)
+		return "", false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		c.misses.Add(1)
+		return "", false
+	}
+	c.lru.MoveToFront(element)
+	c.hits.Add(1)
+	return entry.value, true
+}
+
+// begin starts tracking the writes to a key for a read of it, end must be
+// called once the read is done.
+func (c *readCache) begin(keyspace, key string) readTicket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := cacheKey{keyspace, key}
+	f, ok := c.fills[k]
+	if !ok {
+		// Writes before now were not tracked, a read started earlier cannot tell
+		f = &fill{written: c.clock}
+		c.fills[k] = f
+	}
+	f.readers++
+	return readTicket{at: c.clock, written: f.written, flushes: c.flushes}
+}
+
+// end stops tracking the writes to a key for a read begun.
+func (c *readCache) end(keyspace, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := cacheKey{keyspace, key}
+	if f, ok := c.fills[k]; ok {
+		if f.readers--; f.readers <= 0 {
+			delete(c.fills, k)
+		}
+	}
+}
+
+// put caches the value of a key read from a shard since ticket, unless the key
+// was written or the shard flushed meanwhile.
+func (c *readCache) put(keyspace, key string, shardID int, value string, ticket readTicket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := cacheKey{keyspace, key}
+	f, ok := c.fills[k]
+	if !ok || f.written != ticket.written || c.flushedAll > ticket.at || c.flushed[shardID] > ticket.at {
+		return
+	}
+	if element, ok := c.entries[k]; ok {
+		c.remove(element)
+	}
+	c.entries[k] = c.lru.PushFront(&cacheEntry{key: k, value: value, shardID: shardID, expires: time.Now().Add(c.ttl)})
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// remove must be called with the lock held.
+func (c *readCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// invalidate evicts the entries matching match.
+func (c *readCache) invalidate(match func(entry *cacheEntry) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock++
+	c.flushes++
+	c.flushedAll = c.clock
+	c.evict(match)
+}
+
+// invalidateShard evicts the entries read from a shard.
+func (c *readCache) invalidateShard(shardID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock++
+	c.flushes++
+	c.flushed[shardID] = c.clock
+	c.evict(func(entry *cacheEntry) bool { return entry.shardID == shardID })
+}
+
+// evict must be called with the lock held.
+func (c *readCache) evict(match func(entry *cacheEntry) bool) {
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*cacheEntry)) {
+			c.remove(element)
+			c.invalidations.Add(1)
+		}
+		element = next
+	}
+}
+
+// invalidateKey evicts one key.
+func (c *readCache) invalidateKey(keyspace, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock++
+	k := cacheKey{keyspace, key}
+	if f, ok := c.fills[k]; ok {
+		f.written = c.clock
+	}
+	if element, ok := c.entries[k]; ok {
+		c.remove(element)
+		c.invalidations.Add(1)
+	}
+}
+
+// sync follows the write stream of every shard's current master. A shard whose
+// master changed is flushed, the new master's stream starts where it is now,
+// and a new partition map flushes everything since keys may have moved.
+func (c *readCache) sync(partitionVersion int64, shardNodes map[int]*cluster.ShardInfo) {
+	if !c.enabled {
+		return
+	}
+
+	c.mu.Lock()
+	moved := c.partitionVersion != 0 && c.partitionVersion != partitionVersion
+	c.partitionVersion = partitionVersion
+	var flush []int
+	for shardID, tail := range c.tails {
+		shardInfo, ok := shardNodes[shardID]
+		if ok && shardInfo.Master != nil && shardInfo.Master.ID == tail.masterID && shardInfo.Epoch == tail.epoch {
+			continue
+		}
+		tail.cancel()
+		delete(c.tails, shardID)
+		flush = append(flush, shardID)
+	}
+	for shardID, shardInfo := range shardNodes {
+		if _, ok := c.tails[shardID]; ok || shardInfo.Master == nil {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		c.tails[shardID] = &shardTail{masterID: shardInfo.Master.ID, epoch: shardInfo.Epoch, cancel: cancel}
+		go c.tail(ctx, shardID, *shardInfo.Master)
+	}
+	c.mu.Unlock()
+
+	if moved {
+		c.invalidate(func(*cacheEntry) bool { return true })
+		return
+	}
+	for _, shardID := range flush {
+		c.invalidateShard(shardID)
+	}
+}
+
+// tail polls the WAL of a shard's master and evicts every key written. When
+// the master no longer has the records after the last one seen, the shard is
+// flushed and the stream picked up at its end.
+func (c *readCache) tail(ctx context.Context, shardID int, master cluster.NodeInfo) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	seq, synced := int64(0), false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !synced {
+			lastSeq, err := c.lastSeq(ctx, master)
+			if err != nil {
+				continue
+			}
+			// Writes before the stream was picked up may not have been seen
+			c.invalidateShard(shardID)
+			seq, synced = lastSeq, true
+			continue
+		}
+
+		records, err := c.walSince(ctx, master, seq)
+		if err != nil {
+			if err == errWALGone {
+				synced = false
+			} else if ctx.Err() == nil {
+				log.WithError(err).WithField("shard", shardID).Debug("Failed to tail the write stream")
+			}
+			continue
+		}
+		for _, record := range records {
+			keyspace := record.Keyspace
+			if keyspace == "" {
+				keyspace = apiTypes.DefaultKeyspace
+			}
+			if record.Operation == "DROP_KEYSPACE" {
+				c.invalidate(func(entry *cacheEntry) bool { return entry.key.keyspace == keyspace })
+			} else {
+				c.invalidateKey(keyspace, record.Key)
+			}
+			seq = max(seq, record.Seq)
+		}
+	}
+}
+
+// errWALGone is returned when the master no longer retains the records asked for.
+var errWALGone = fmt.Errorf("write stream truncated")
+
+func (c *readCache) lastSeq(ctx context.Context, master cluster.NodeInfo) (int64, error) {
+	var body struct {
+		LastSeq int64 `json:"last_seq"`
+	}
+	resp, err := c.getNode(ctx, master, "/last-seq")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("received status code %d from node %d", resp.StatusCode, master.ID)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return body.LastSeq, nil
+}
+
+func (c *readCache) walSince(ctx context.Context, master cluster.NodeInfo, seq int64) ([]walRecord, error) {
+	resp, err := c.getNode(ctx, master, fmt.Sprintf("/wal/get-since?since=%d", seq))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return nil, errWALGone
+	default:
+		return nil, fmt.Errorf("received status code %d from node %d", resp.StatusCode, master.ID)
+	}
+	var records []walRecord
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (c *readCache) getNode(ctx context.Context, node cluster.NodeInfo, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s:%d%s", node.Address.IP, node.Address.Port, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+func (c *readCache) stats() apiTypes.CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+
+	stats := apiTypes.CacheStats{
+		Enabled:       c.enabled,
+		Entries:       size,
+		MaxEntries:    c.maxEntries,
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
@@ -0,0 +1,121 @@
+package kvLoadbalancer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+func newTestCache() *readCache {
+	return newReadCache(config.CacheConfig{Enabled: true, MaxEntries: 2})
+}
+
+func TestReadCacheFillSurvivesWritesToOtherKeys(t *testing.T) {
+	c := newTestCache()
+	ticket := c.begin("default", "a")
+	defer c.end("default", "a")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.invalidateKey("default", fmt.Sprintf("other-%d-%d", i, j))
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.invalidateShard(1)
+	}()
+	wg.Wait()
+
+	c.put("default", "a", 0, "v", ticket)
+	if value, ok := c.get("default", "a"); !ok || value != "v" {
+		t.Fatalf("get = %q, %v, want the value filled", value, ok)
+	}
+}
+
+func TestReadCacheDropsFillRacingAWrite(t *testing.T) {
+	c := newTestCache()
+	ticket := c.begin("default", "a")
+	defer c.end("default", "a")
+
+	c.invalidateKey("default", "a")
+	c.put("default", "a", 0, "old", ticket)
+	if _, ok := c.get("default", "a"); ok {
+		t.Fatal("a value read before a write to its key was cached")
+	}
+}
+
+func TestReadCacheDropsFillRacingAShardFlush(t *testing.T) {
+	c := newTestCache()
+	ticket := c.begin("default", "a")
+	defer c.end("default", "a")
+
+	c.invalidateShard(0)
+	c.put("default", "a", 0, "old", ticket)
+	if _, ok := c.get("default", "a"); ok {
+		t.Fatal("a value read before its shard was flushed was cached")
+	}
+
+	c.invalidate(func(*cacheEntry) bool { return true })
+	c.put("default", "a", 1, "old", ticket)
+	if _, ok := c.get("default", "a"); ok {
+		t.Fatal("a value read before everything was flushed was cached")
+	}
+}
+
+func TestReadCacheDropsFillOfAnUntrackedRead(t *testing.T) {
+	c := newTestCache()
+	ticket := c.begin("default", "a")
+	c.end("default", "a")
+
+	// The write is not tracked with no read pending, a new read must not
+	// vouch for the old one
+	c.invalidateKey("default", "a")
+	c.begin("default", "a")
+	defer c.end("default", "a")
+
+	c.put("default", "a", 0, "old", ticket)
+	if _, ok := c.get("default", "a"); ok {
+		t.Fatal("a value read before a write to its key was cached")
+	}
+}
+
+func TestReadCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTestCache()
+	for _, key := range []string{"a", "b"} {
+		c.put("default", key, 0, key, c.begin("default", key))
+		c.end("default", key)
+	}
+	c.get("default", "a")
+	c.put("default", "c", 0, "c", c.begin("default", "c"))
+	c.end("default", "c")
+
+	if _, ok := c.get("default", "b"); ok {
+		t.Fatal("the least recently used entry was kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get("default", key); !ok {
+			t.Fatalf("%s was evicted", key)
+		}
+	}
+	if len(c.fills) != 0 {
+		t.Fatalf("%d fills tracked with no read pending", len(c.fills))
+	}
+}
+
+func TestDisabledReadCacheTailsNoShard(t *testing.T) {
+	c := newReadCache(config.CacheConfig{})
+	master := &cluster.NodeInfo{ID: 1}
+	c.sync(1, map[int]*cluster.ShardInfo{0: {Master: master}})
+	if len(c.tails) != 0 {
+		t.Fatalf("disabled cache tails %d shards", len(c.tails))
+	}
+}
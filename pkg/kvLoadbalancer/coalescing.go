@@ -0,0 +1,109 @@
+package kvLoadbalancer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+)
+
+// flightKey tells identical reads apart.
+type flightKey struct {
+	keyspace    string
+	key         string
+	consistency apiTypes.ReadConsistency
+	noCache     bool
+}
+
+// flight is a read to the nodes shared by every identical read that comes
+// in while it runs.
+type flight struct {
+	done   chan struct{}
+	value  string
+	err    error
+	ticket readTicket
+}
+
+// flightGroup coalesces concurrent identical reads into a single request to
+// the nodes. A read only joins a flight its key was not written since, as seen
+// by the load balancer, so it never gets an answer older than its own request
+// would have. Writes to other keys do not keep reads apart.
+type flightGroup struct {
+	enabled bool
+
+	mu      sync.Mutex
+	flights map[flightKey]*flight
+
+	reads     atomic.Int64
+	fetches   atomic.Int64
+	collapsed atomic.Int64
+}
+
+func newFlightGroup(cfg config.CoalescingConfig) *flightGroup {
+	return &flightGroup{enabled: cfg.Enabled, flights: make(map[flightKey]*flight)}
+}
+
+// do returns the result of fetch for key, shared with the identical reads in
+// flight. fetch runs detached from the callers so one of them giving up does
+// not fail the others, each caller still stops waiting when its ctx ends.
+func (g *flightGroup) do(ctx context.Context, key flightKey, ticket readTicket, fetch func(ctx context.Context) (string, error)) (string, error) {
+	g.reads.Add(1)
+	if !g.enabled {
+		g.fetches.Add(1)
+		return fetch(ctx)
+	}
+
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if ok && !f.ticket.overtakenBy(ticket) {
+		g.mu.Unlock()
+		g.collapsed.Add(1)
+		return f.wait(ctx)
+	}
+	// A flight overtaken by a write keeps running for its own readers, the
+	// reads coming in from now on join this one
+	f = &flight{done: make(chan struct{}), ticket: ticket}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	g.fetches.Add(1)
+	go func() {
+		f.value, f.err = fetch(context.WithoutCancel(ctx))
+		g.mu.Lock()
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	return f.wait(ctx)
+}
+
+func (f *flight) wait(ctx context.Context) (string, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return "", contextError(ctx, nil)
+	}
+}
+
+func (g *flightGroup) stats() apiTypes.CoalescingStats {
+	g.mu.Lock()
+	inFlight := len(g.flights)
+	g.mu.Unlock()
+
+	stats := apiTypes.CoalescingStats{
+		Enabled:   g.enabled,
+		Reads:     g.reads.Load(),
+		Fetches:   g.fetches.Load(),
+		Collapsed: g.collapsed.Load(),
+		InFlight:  inFlight,
+	}
+	if stats.Reads > 0 {
+		stats.CollapsedRatio = float64(stats.Collapsed) / float64(stats.Reads)
+	}
+	return stats
+}
@@ -0,0 +1,148 @@
+package kvLoadbalancer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+)
+
+// waitFor polls cond until it holds or a second passed.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlightGroupCoalescesIdenticalReads(t *testing.T) {
+	g := newFlightGroup(config.CoalescingConfig{Enabled: true})
+	c := newTestCache()
+	key := flightKey{keyspace: "default", key: "a"}
+	release := make(chan struct{})
+	fetch := func(context.Context) (string, error) {
+		<-release
+		return "v", nil
+	}
+
+	const readers = 8
+	var wg sync.WaitGroup
+	values := make([]string, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = g.do(context.Background(), key, c.begin("default", "a"), fetch)
+		}(i)
+	}
+	waitFor(t, func() bool { return g.collapsed.Load() == readers-1 })
+	close(release)
+	wg.Wait()
+
+	if fetches := g.fetches.Load(); fetches != 1 {
+		t.Fatalf("%d fetches for identical reads, want 1", fetches)
+	}
+	for i, value := range values {
+		if value != "v" {
+			t.Fatalf("reader %d got %q", i, value)
+		}
+	}
+	if inFlight := g.stats().InFlight; inFlight != 0 {
+		t.Fatalf("%d flights left after all of them landed", inFlight)
+	}
+}
+
+func TestFlightGroupStartsANewFlightAfterAWrite(t *testing.T) {
+	g := newFlightGroup(config.CoalescingConfig{Enabled: true})
+	c := newTestCache()
+	key := flightKey{keyspace: "default", key: "a"}
+	releaseOld, releaseNew := make(chan struct{}), make(chan struct{})
+
+	old := make(chan string, 1)
+	ticket := c.begin("default", "a")
+	go func() {
+		value, _ := g.do(context.Background(), key, ticket, func(context.Context) (string, error) {
+			<-releaseOld
+			return "old", nil
+		})
+		old <- value
+	}()
+	waitFor(t, func() bool { return g.fetches.Load() == 1 })
+
+	// A write to another key does not keep reads apart
+	c.invalidateKey("default", "b")
+	joined := make(chan string, 1)
+	go func() {
+		value, _ := g.do(context.Background(), key, c.begin("default", "a"), nil)
+		joined <- value
+	}()
+	waitFor(t, func() bool { return g.collapsed.Load() == 1 })
+
+	// A write to the key does, and the new flight is the one joined from now on
+	c.invalidateKey("default", "a")
+	var wg sync.WaitGroup
+	values := make([]string, 2)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = g.do(context.Background(), key, c.begin("default", "a"), func(context.Context) (string, error) {
+				<-releaseNew
+				return "new", nil
+			})
+		}(i)
+	}
+	waitFor(t, func() bool { return g.fetches.Load() == 2 && g.collapsed.Load() == 2 })
+
+	close(releaseOld)
+	if value := <-old; value != "old" {
+		t.Fatalf("the first reader got %q", value)
+	}
+	if value := <-joined; value != "old" {
+		t.Fatalf("the reader joining before the write got %q", value)
+	}
+	close(releaseNew)
+	wg.Wait()
+	for i, value := range values {
+		if value != "new" {
+			t.Fatalf("reader %d after the write got %q, want the new flight's value", i, value)
+		}
+	}
+	if fetches := g.fetches.Load(); fetches != 2 {
+		t.Fatalf("%d fetches, want one per side of the write", fetches)
+	}
+}
+
+func TestFlightGroupWaiterGivesUpAlone(t *testing.T) {
+	g := newFlightGroup(config.CoalescingConfig{Enabled: true})
+	c := newTestCache()
+	key := flightKey{keyspace: "default", key: "a"}
+	release := make(chan struct{})
+	ticket := c.begin("default", "a")
+
+	done := make(chan string, 1)
+	go func() {
+		value, _ := g.do(context.Background(), key, ticket, func(context.Context) (string, error) {
+			<-release
+			return "v", nil
+		})
+		done <- value
+	}()
+	waitFor(t, func() bool { return g.fetches.Load() == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.do(ctx, key, ticket, nil); err == nil {
+		t.Fatal("a reader whose context ended got no error")
+	}
+	close(release)
+	if value := <-done; value != "v" {
+		t.Fatalf("the remaining reader got %q", value)
+	}
+}
@@ -0,0 +1,198 @@
+package kvLoadbalancer
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// hedgeMinSamples is how many reads are observed before the hedge delay is trusted.
+const hedgeMinSamples = 20
+
+// hedger decides when a read is duplicated to a second replica. The delay is a
+// percentile of recent read latencies, so only the slowest reads are hedged,
+// and a budget earned by every read caps the extra load.
+type hedger struct {
+	enabled    bool
+	percentile float64
+	minDelay   time.Duration
+	ratio      float64
+	maxTokens  float64
+
+	mu          sync.Mutex
+	window      []time.Duration
+	next        int
+	delay       time.Duration
+	sinceUpdate int
+	tokens      float64
+
+	reads  atomic.Int64
+	hedged atomic.Int64
+	won    atomic.Int64
+}
+
+func newHedger(cfg config.HedgingConfig) *hedger {
+	h := &hedger{
+		enabled:    cfg.Enabled,
+		percentile: cfg.Percentile,
+		minDelay:   time.Duration(cfg.MinDelayMs) * time.Millisecond,
+		ratio:      cfg.BudgetPercent / 100,
+	}
+	if h.percentile <= 0 || h.percentile >= 1 {
+		h.percentile = 0.95
+	}
+	if h.ratio <= 0 {
+		h.ratio = 0.1
+	}
+	// Lets a short burst of slow reads be hedged before the budget runs dry
+	h.maxTokens = max(1, h.ratio*100)
+	windowSize := cfg.WindowSize
+	if windowSize <= 0 {
+		windowSize = 1000
+	}
+	h.window = make([]time.Duration, 0, windowSize)
+	return h
+}
+
+// observe records the latency of a successful read.
+func (h *hedger) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.window) < cap(h.window) {
+		h.window = append(h.window, latency)
+	} else {
+		h.window[h.next] = latency
+		h.next = (h.next + 1) % cap(h.window)
+	}
+	// Sorting the window on every read would cost more than it gains
+	h.sinceUpdate++
+	if h.sinceUpdate >= max(1, len(h.window)/10) {
+		h.sinceUpdate = 0
+		sorted := make([]time.Duration, len(h.window))
+		copy(sorted, h.window)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		index := int(math.Ceil(h.percentile*float64(len(sorted)))) - 1
+		h.delay = max(sorted[max(index, 0)], h.minDelay)
+	}
+}
+
+// start counts a read towards the budget and returns how long it waits for an
+// answer before it is hedged, false when it is not hedged at all.
+func (h *hedger) start() (time.Duration, bool) {
+	h.reads.Add(1)
+	if !h.enabled {
+		return 0, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens = min(h.maxTokens, h.tokens+h.ratio)
+	if len(h.window) < hedgeMinSamples || h.delay <= 0 {
+		return 0, false
+	}
+	return h.delay, true
+}
+
+// spend takes a hedge from the budget.
+func (h *hedger) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	h.hedged.Add(1)
+	return true
+}
+
+func (h *hedger) stats() apiTypes.HedgingStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return apiTypes.HedgingStats{
+		Enabled:   h.enabled,
+		Reads:     h.reads.Load(),
+		Hedged:    h.hedged.Load(),
+		HedgesWon: h.won.Load(),
+		DelayMs:   float64(h.delay.Microseconds()) / 1000,
+		Budget:    h.tokens,
+	}
+}
+
+// readFrom sends a read to the candidates in order until one answers, moving
+// on when one fails. A read still unanswered after the hedge delay is also
+// sent to the next candidate. The first answer wins and the others are
+// cancelled, the node that answered is returned.
+func (s *LoadBalancerService) readFrom(ctx context.Context, candidates []*cluster.NodeInfo, req apiTypes.GetRequest, out *apiTypes.GetResponse) (*cluster.NodeInfo, error) {
+	delay, hedge := s.hedging.start()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		node    *cluster.NodeInfo
+		resp    apiTypes.GetResponse
+		err     error
+		latency time.Duration
+		hedge   bool
+	}
+	results := make(chan result, len(candidates))
+	next := 0
+	launch := func(hedge bool) {
+		node := candidates[next]
+		next++
+		go func() {
+			var resp apiTypes.GetResponse
+			start := time.Now()
+			err := s.callNode(ctx, node, "/get", req, &resp)
+			results <- result{node: node, resp: resp, err: err, latency: time.Since(start), hedge: hedge}
+		}()
+	}
+
+	var hedgeTimer <-chan time.Time
+	if hedge && len(candidates) > 1 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedgeTimer = timer.C
+	}
+
+	launch(false)
+	inFlight := 1
+	var err error
+	for inFlight > 0 {
+		select {
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			if next < len(candidates) && s.hedging.spend() {
+				launch(true)
+				inFlight++
+			}
+		case r := <-results:
+			inFlight--
+			if r.err == nil {
+				s.hedging.observe(r.latency)
+				if r.hedge {
+					s.hedging.won.Add(1)
+				}
+				*out = r.resp
+				return r.node, nil
+			}
+			err = r.err
+			if retry, _ := classify(r.err); !retry {
+				return nil, r.err
+			}
+			if next < len(candidates) {
+				launch(false)
+				inFlight++
+			}
+		}
+	}
+	return nil, err
+}
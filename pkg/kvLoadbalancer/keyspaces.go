@@ -0,0 +1,90 @@
+package kvLoadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/ratelimit"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/pkg/kvLoadbalancer/api"
+)
+
+// keyspaceLimits holds a token bucket per keyspace with an ops/sec quota. The
+// buckets outlive topology versions so a new version does not refill them.
+type keyspaceLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*ratelimit.Bucket
+}
+
+// update matches the buckets to the quotas of keyspaces, dropped keyspaces lose theirs.
+func (l *keyspaceLimits) update(keyspaces []apiTypes.Keyspace) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buckets := make(map[string]*ratelimit.Bucket, len(keyspaces))
+	for _, keyspace := range keyspaces {
+		rate := keyspace.Quota.MaxOpsPerSec
+		if rate <= 0 {
+			continue
+		}
+		if bucket, ok := l.buckets[keyspace.Name]; ok {
+			bucket.SetRate(rate, 0)
+			buckets[keyspace.Name] = bucket
+			continue
+		}
+		buckets[keyspace.Name] = ratelimit.NewBucket(rate, 0)
+	}
+	l.buckets = buckets
+}
+
+func (l *keyspaceLimits) bucket(keyspace string) *ratelimit.Bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buckets[keyspace]
+}
+
+// admit resolves the keyspace of a request, an empty name is the default
+// keyspace, and charges the request to its ops/sec quota.
+func (s *LoadBalancerService) admit(keyspace string) (string, error) {
+	if keyspace == "" {
+		keyspace = apiTypes.DefaultKeyspace
+	}
+	table := s.routing.Load()
+	if table == nil {
+		return "", fmt.Errorf("partition map not loaded yet")
+	}
+	if _, ok := table.keyspaces[keyspace]; !ok {
+		return "", &api.StatusError{Code: http.StatusNotFound, Message: fmt.Sprintf("keyspace %q not found", keyspace)}
+	}
+	if bucket := s.limits.bucket(keyspace); bucket != nil {
+		if allowed, retryAfter := bucket.Allow(); !allowed {
+			return "", &api.StatusError{
+				Code:       http.StatusTooManyRequests,
+				Message:    fmt.Sprintf("keyspace %q is over its ops/sec quota, retry after %s", keyspace, retryAfter),
+				RetryAfter: retryAfter,
+			}
+		}
+	}
+	return keyspace, nil
+}
+
+// nodeError relays the status and error message of a failed node response.
+func nodeError(resp *http.Response) error {
+	var body struct {
+		Error    string             `json:"error"`
+		Redirect *apiTypes.Redirect `json:"redirect"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		body.Error = fmt.Sprintf("node returned status %d", resp.StatusCode)
+	}
+	statusErr := &api.StatusError{Code: resp.StatusCode, Message: body.Error, Redirect: body.Redirect}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if seconds, err := time.ParseDuration(resp.Header.Get("Retry-After") + "s"); err == nil {
+			statusErr.RetryAfter = seconds
+		}
+	}
+	return statusErr
+}
@@ -0,0 +1,110 @@
+package kvLoadbalancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// advertisedAddress is the address the load balancer is reached at, the
+// hostname stands in for an unspecified host.
+func advertisedAddress(host string, port int) string {
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if hostname, err := os.Hostname(); err == nil {
+			host = hostname
+		}
+	}
+	return net.JoinHostPort(host, fmt.Sprint(port))
+}
+
+// Ready reports why the load balancer should not receive traffic, nil when it
+// should. It is ready once it has synced the topology, until it drains.
+func (s *LoadBalancerService) Ready() error {
+	switch s.state() {
+	case apiTypes.LoadBalancerStarting:
+		return fmt.Errorf("topology not synced yet")
+	case apiTypes.LoadBalancerDraining:
+		return fmt.Errorf("draining")
+	}
+	return nil
+}
+
+func (s *LoadBalancerService) state() apiTypes.LoadBalancerState {
+	switch {
+	case s.draining.Load():
+		return apiTypes.LoadBalancerDraining
+	case s.routing.Load() == nil:
+		return apiTypes.LoadBalancerStarting
+	}
+	return apiTypes.LoadBalancerReady
+}
+
+// Shutdown drains the load balancer. It reports not ready while still serving
+// for the drain delay so upstream health checks move traffic away, then stops
+// accepting connections, waits for the requests in flight up to the drain
+// timeout and leaves the fleet.
+func (s *LoadBalancerService) Shutdown() error {
+	delay := time.Duration(s.config.Drain.DelayMs) * time.Millisecond
+	timeout := time.Duration(s.config.Drain.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	s.draining.Store(true)
+	if err := s.sendHeartbeat(); err != nil {
+		log.WithError(err).Warn("Failed to report draining to the controller")
+	}
+	log.WithFields(log.Fields{
+		"delay":   delay,
+		"timeout": timeout,
+	}).Info("Draining load balancer")
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Requests still in flight at the drain timeout")
+	}
+
+	if err := s.postController("/internal/loadbalancers/deregister", apiTypes.LoadBalancerDeregistration{ID: s.id()}); err != nil {
+		log.WithError(err).Warn("Failed to deregister from the controller")
+	}
+	return err
+}
+
+// heartbeatLoop registers the load balancer with the controller and keeps it
+// registered until it drains, Shutdown reports the drain itself.
+func (s *LoadBalancerService) heartbeatLoop() {
+	interval := time.Duration(s.config.HeartbeatIntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; !s.draining.Load(); <-ticker.C {
+		if err := s.sendHeartbeat(); err != nil {
+			log.WithError(err).Warn("Failed to send heartbeat to the controller")
+		}
+	}
+}
+
+func (s *LoadBalancerService) sendHeartbeat() error {
+	hb := apiTypes.LoadBalancerHeartbeat{
+		ID:              s.id(),
+		Address:         s.address,
+		State:           s.state(),
+		SettingsVersion: s.settingsVersion.Load(),
+		Started:         s.started,
+	}
+	if table := s.routing.Load(); table != nil {
+		hb.TopologyVersion = table.version
+	}
+	return s.postController("/internal/loadbalancers/heartbeat", hb)
+}
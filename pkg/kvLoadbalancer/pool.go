@@ -0,0 +1,216 @@
+package kvLoadbalancer
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// latencyWeight is the weight of the newest request in a pool's latency average.
+const latencyWeight = 0.2
+
+// nodePool is the keep-alive connection pool to one node with its request stats.
+type nodePool struct {
+	nodeID    int
+	address   string
+	transport *http.Transport
+	client    *http.Client
+	breaker   *breaker
+
+	inFlight atomic.Int64
+	requests atomic.Int64
+	failures atomic.Int64
+
+	mu         sync.Mutex
+	avgLatency time.Duration
+	maxLatency time.Duration
+	updated    time.Time
+}
+
+// done records a finished request, failed tells whether the node failed it.
+func (p *nodePool) done(latency time.Duration, failed bool) {
+	p.inFlight.Add(-1)
+	p.requests.Add(1)
+	if failed {
+		p.failures.Add(1)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.avgLatency == 0 {
+		p.avgLatency = latency
+	} else {
+		p.avgLatency += time.Duration(latencyWeight * float64(latency-p.avgLatency))
+	}
+	p.maxLatency = max(p.maxLatency, latency)
+	p.updated = time.Now()
+}
+
+// latency returns the latency average, decayed by how long the node has not
+// served requests so a node that was slow once gets tried again.
+func (p *nodePool) latency(decay time.Duration) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.avgLatency == 0 || decay <= 0 {
+		return p.avgLatency
+	}
+	return time.Duration(float64(p.avgLatency) * math.Exp(-float64(time.Since(p.updated))/float64(decay)))
+}
+
+func (p *nodePool) stats() apiTypes.NodePoolStats {
+	state, _, _, _ := p.breaker.snapshot()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return apiTypes.NodePoolStats{
+		NodeID:       p.nodeID,
+		Address:      p.address,
+		MaxConns:     p.transport.MaxConnsPerHost,
+		InFlight:     p.inFlight.Load(),
+		Requests:     p.requests.Load(),
+		Failures:     p.failures.Load(),
+		AvgLatencyMs: float64(p.avgLatency.Microseconds()) / 1000,
+		MaxLatencyMs: float64(p.maxLatency.Microseconds()) / 1000,
+		Breaker:      state,
+	}
+}
+
+// nodePools keeps a connection pool and circuit breaker per node address.
+type nodePools struct {
+	cfg    config.TransportConfig
+	policy breakerPolicy
+	// onBreakerChange is called whenever a breaker changes state
+	onBreakerChange func()
+	mu              sync.Mutex
+	pools           map[string]*nodePool
+}
+
+func newNodePools(cfg config.TransportConfig, policy breakerPolicy, onBreakerChange func()) *nodePools {
+	if cfg.MaxIdleConnsPerNode <= 0 {
+		cfg.MaxIdleConnsPerNode = 16
+	}
+	if cfg.IdleConnTimeoutMs <= 0 {
+		cfg.IdleConnTimeoutMs = 90000
+	}
+	if cfg.DialTimeoutMs <= 0 {
+		cfg.DialTimeoutMs = 1000
+	}
+	if cfg.KeepAliveMs <= 0 {
+		cfg.KeepAliveMs = 30000
+	}
+	return &nodePools{
+		cfg:             cfg,
+		policy:          policy,
+		onBreakerChange: onBreakerChange,
+		pools:           make(map[string]*nodePool),
+	}
+}
+
+// nodeAddress is the host:port a node is reached at.
+func nodeAddress(node *cluster.NodeInfo) string {
+	return net.JoinHostPort(node.Address.IP.String(), fmt.Sprint(node.Address.Port))
+}
+
+// get returns the pool of node, creating it on first use.
+func (np *nodePools) get(node *cluster.NodeInfo) *nodePool {
+	address := nodeAddress(node)
+
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	if pool, ok := np.pools[address]; ok {
+		return pool
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(np.cfg.DialTimeoutMs) * time.Millisecond,
+		KeepAlive: time.Duration(np.cfg.KeepAliveMs) * time.Millisecond,
+	}
+	transport := &http.Transport{
+		DialContext: dialer.DialContext,
+		// Zero leaves the connections to a node unlimited
+		MaxConnsPerHost:     np.cfg.MaxConnsPerNode,
+		MaxIdleConnsPerHost: np.cfg.MaxIdleConnsPerNode,
+		IdleConnTimeout:     time.Duration(np.cfg.IdleConnTimeoutMs) * time.Millisecond,
+	}
+	pool := &nodePool{
+		nodeID:    node.ID,
+		address:   address,
+		transport: transport,
+		client:    &http.Client{Transport: transport},
+		breaker:   newBreaker(np.policy, np.onBreakerChange),
+	}
+	np.pools[address] = pool
+	return pool
+}
+
+// retain closes the pools of nodes that left the topology.
+func (np *nodePools) retain(shardNodes map[int]*cluster.ShardInfo) {
+	live := make(map[string]bool)
+	for _, shardInfo := range shardNodes {
+		for _, node := range shardInfo.Members() {
+			live[nodeAddress(node)] = true
+		}
+	}
+
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	for address, pool := range np.pools {
+		if !live[address] && pool.inFlight.Load() == 0 {
+			pool.transport.CloseIdleConnections()
+			delete(np.pools, address)
+		}
+	}
+}
+
+// snapshot returns every pool.
+func (np *nodePools) snapshot() []*nodePool {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	pools := make([]*nodePool, 0, len(np.pools))
+	for _, pool := range np.pools {
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+// stats returns the stats of every pool by node ID.
+func (np *nodePools) stats() []apiTypes.NodePoolStats {
+	pools := np.snapshot()
+	stats := make([]apiTypes.NodePoolStats, 0, len(pools))
+	for _, pool := range pools {
+		stats = append(stats, pool.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].NodeID < stats[j].NodeID
+	})
+	return stats
+}
+
+// breakers returns the circuit breaker of every pool by node ID.
+func (np *nodePools) breakers() []apiTypes.NodeBreaker {
+	pools := np.snapshot()
+	breakers := make([]apiTypes.NodeBreaker, 0, len(pools))
+	for _, pool := range pools {
+		state, since, errorRate, latency := pool.breaker.snapshot()
+		breakers = append(breakers, apiTypes.NodeBreaker{
+			NodeID:    pool.nodeID,
+			Address:   pool.address,
+			State:     state,
+			Since:     since,
+			ErrorRate: errorRate,
+			LatencyMs: float64(latency.Microseconds()) / 1000,
+		})
+	}
+	sort.Slice(breakers, func(i, j int) bool {
+		return breakers[i].NodeID < breakers[j].NodeID
+	})
+	return breakers
+}
@@ -0,0 +1,179 @@
+package kvLoadbalancer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"github.com/Amirali-Amirifar/kv/pkg/kvLoadbalancer/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// errNoMaster is the cause of writes to a shard the routing table knows no
+// master of, they were never sent.
+var errNoMaster = errors.New("no master node available")
+
+// op describes a client operation to the retry policy.
+type op struct {
+	name string
+	// idempotent operations are safe to repeat whatever became of the failed
+	// attempt. Writes are not: a Set that timed out may still land, repeating
+	// it after another client wrote the key would undo that write. Nodes do
+	// not deduplicate requests, so writes are only retried when the failed
+	// attempt certainly was not applied.
+	idempotent bool
+}
+
+// retryPolicy is how often and how far apart failed operations are retried.
+type retryPolicy struct {
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+}
+
+func newRetryPolicy(cfg config.RetryConfig) retryPolicy {
+	policy := retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		baseBackoff: time.Duration(cfg.BaseBackoffMs) * time.Millisecond,
+		maxBackoff:  time.Duration(cfg.MaxBackoffMs) * time.Millisecond,
+	}
+	if policy.maxAttempts <= 0 {
+		policy.maxAttempts = 3
+	}
+	if policy.baseBackoff <= 0 {
+		policy.baseBackoff = 50 * time.Millisecond
+	}
+	if policy.maxBackoff < policy.baseBackoff {
+		policy.maxBackoff = max(time.Second, policy.baseBackoff)
+	}
+	return policy
+}
+
+// backoff returns the wait before retry attempt, an exponential backoff with
+// jitter so clients failing together do not retry together.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.baseBackoff << min(attempt, 16)
+	if d <= 0 || d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// classify tells whether a failed attempt may succeed when retried and
+// whether it suggests the routing table is stale. Errors a node answered for
+// the request itself, such as a missing key or an exceeded quota, are final.
+func classify(err error) (retry bool, stale bool) {
+	if errors.Is(err, api.ErrCircuitOpen) {
+		// Other replicas may serve it, or the breaker may be half-open by the next try
+		return true, false
+	}
+	var statusErr *api.StatusError
+	if !errors.As(err, &statusErr) {
+		// The node could not be reached or the shard had no master, it may
+		// have failed over
+		return true, true
+	}
+	switch statusErr.Code {
+	case http.StatusConflict, http.StatusServiceUnavailable, http.StatusMisdirectedRequest:
+		// The node is no longer the master, is being decommissioned or no
+		// longer owns the key
+		return true, true
+	case http.StatusInsufficientStorage:
+		return false, false
+	}
+	return statusErr.Code >= 500, false
+}
+
+// mayHaveApplied tells whether a node may have applied the request of a failed
+// attempt. Rejections the node answered with were decided before writing, and
+// requests that were never sent or could not connect never reached it. A
+// timeout, a connection lost after sending or an internal error may come after
+// the write.
+func mayHaveApplied(err error) bool {
+	if errors.Is(err, api.ErrCircuitOpen) || errors.Is(err, errNoMaster) {
+		return false
+	}
+	var statusErr *api.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 && statusErr.Code != http.StatusServiceUnavailable
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return false
+	}
+	return true
+}
+
+// withRetry runs attempt until it succeeds, fails for good, runs out of
+// attempts or ctx ends. Each attempt gets the current routing of key,
+// refreshed from the controller when a failure suggests it is stale.
+func (s *LoadBalancerService) withRetry(ctx context.Context, o op, key string, attempt func(shardID int, shardInfo *cluster.ShardInfo) error) error {
+	var err error
+	for i := 0; i < s.retry.maxAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(s.retry.backoff(i - 1)):
+			case <-ctx.Done():
+				return contextError(ctx, err)
+			}
+		}
+
+		var shardID int
+		var shardInfo *cluster.ShardInfo
+		shardID, shardInfo, err = s.route(key)
+		routed := err == nil
+		if routed {
+			err = attempt(shardID, shardInfo)
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return contextError(ctx, err)
+		}
+
+		retry, stale := classify(err)
+		if !retry || (!o.idempotent && routed && mayHaveApplied(err)) {
+			return err
+		}
+		if stale {
+			s.refreshTopology()
+		}
+		log.WithError(err).WithFields(log.Fields{
+			"op":      o.name,
+			"key":     key,
+			"attempt": i + 1,
+		}).Warn("Operation failed, retrying")
+	}
+	return err
+}
+
+// refreshTopology fetches the topology now instead of waiting for the watch.
+// Concurrent callers share one refresh.
+func (s *LoadBalancerService) refreshTopology() {
+	if !s.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	defer s.refreshing.Store(false)
+	if err := s.UpdateNodeData(); err != nil {
+		log.WithError(err).Warn("Topology refresh failed")
+	}
+}
+
+// contextError reports why ctx ended an operation, last being the error of its
+// last attempt if any.
+func contextError(ctx context.Context, last error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		message := "request deadline exceeded"
+		if last != nil {
+			message += ": " + last.Error()
+		}
+		return &api.StatusError{Code: http.StatusGatewayTimeout, Message: message}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,91 @@
+package kvLoadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/pkg/kvLoadbalancer/api"
+)
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	p := newRetryPolicy(config.RetryConfig{})
+	if p.maxAttempts != 3 || p.baseBackoff != 50*time.Millisecond || p.maxBackoff != time.Second {
+		t.Fatalf("default policy %+v", p)
+	}
+	// A cap below the base is raised rather than shortening every backoff
+	p = newRetryPolicy(config.RetryConfig{BaseBackoffMs: 2000, MaxBackoffMs: 100})
+	if p.maxBackoff != 2*time.Second {
+		t.Fatalf("max backoff %v below a base of 2s", p.maxBackoff)
+	}
+}
+
+func TestBackoffGrowsWithJitterUpToTheCap(t *testing.T) {
+	p := newRetryPolicy(config.RetryConfig{BaseBackoffMs: 10, MaxBackoffMs: 100})
+	for attempt, ceiling := range []time.Duration{10, 20, 40, 80, 100, 100} {
+		ceiling *= time.Millisecond
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(attempt); d < ceiling/2 || d > ceiling {
+				t.Fatalf("backoff of attempt %d is %v, want within [%v, %v]", attempt, d, ceiling/2, ceiling)
+			}
+		}
+	}
+	// Shifting far past the cap must not overflow into a short backoff
+	if d := p.backoff(100); d < 50*time.Millisecond {
+		t.Fatalf("backoff of attempt 100 is %v", d)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		retry, stale bool
+	}{
+		{"unreachable", errors.New("connection refused"), true, true},
+		{"circuit open", fmt.Errorf("node 1: %w", api.ErrCircuitOpen), true, false},
+		{"not master", &api.StatusError{Code: http.StatusConflict}, true, true},
+		{"decommissioning", &api.StatusError{Code: http.StatusServiceUnavailable}, true, true},
+		{"moved", &api.StatusError{Code: http.StatusMisdirectedRequest}, true, true},
+		{"server error", &api.StatusError{Code: http.StatusInternalServerError}, true, false},
+		{"quota", &api.StatusError{Code: http.StatusInsufficientStorage}, false, false},
+		{"missing key", &api.StatusError{Code: http.StatusNotFound}, false, false},
+		{"bad request", &api.StatusError{Code: http.StatusBadRequest}, false, false},
+	}
+	for _, tt := range tests {
+		if retry, stale := classify(tt.err); retry != tt.retry || stale != tt.stale {
+			t.Errorf("%s: retry %v stale %v, want %v %v", tt.name, retry, stale, tt.retry, tt.stale)
+		}
+	}
+}
+
+func TestMayHaveApplied(t *testing.T) {
+	dial := &url.Error{Op: "Post", URL: "http://node", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	reset := &url.Error{Op: "Post", URL: "http://node", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no master", fmt.Errorf("%w for shard 0", errNoMaster), false},
+		{"circuit open", &api.StatusError{Code: http.StatusServiceUnavailable, Err: api.ErrCircuitOpen}, false},
+		{"connection refused", fmt.Errorf("error calling node 1: %w", dial), false},
+		{"not master", &api.StatusError{Code: http.StatusConflict}, false},
+		{"moved", &api.StatusError{Code: http.StatusMisdirectedRequest}, false},
+		{"fenced", &api.StatusError{Code: http.StatusServiceUnavailable}, false},
+		{"connection reset", fmt.Errorf("error calling node 1: %w", reset), true},
+		{"timeout", fmt.Errorf("error calling node 1: %w", context.DeadlineExceeded), true},
+		{"server error", &api.StatusError{Code: http.StatusInternalServerError}, true},
+	}
+	for _, tt := range tests {
+		if got := mayHaveApplied(tt.err); got != tt.want {
+			t.Errorf("%s: may have applied %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package kvLoadbalancer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"github.com/Amirali-Amirifar/kv/pkg/kvLoadbalancer/api"
+)
+
+// Strategies for picking the member of a shard that serves a read.
+const (
+	strategyP2C              = "p2c"
+	strategyLeastOutstanding = "least_outstanding"
+)
+
+// readRouter decides which members of a shard may serve a read and in which
+// order they are tried.
+type readRouter struct {
+	strategy       string
+	consistency    apiTypes.ReadConsistency
+	maxFollowerLag int64
+	latencyDecay   time.Duration
+}
+
+func newReadRouter(cfg config.ReadRoutingConfig) readRouter {
+	router := readRouter{
+		strategy:       cfg.Strategy,
+		consistency:    apiTypes.ReadConsistency(cfg.DefaultConsistency),
+		maxFollowerLag: cfg.MaxFollowerLag,
+		latencyDecay:   time.Duration(cfg.LatencyDecayMs) * time.Millisecond,
+	}
+	if router.strategy != strategyLeastOutstanding {
+		router.strategy = strategyP2C
+	}
+	if _, err := router.resolve(router.consistency); err != nil || router.consistency == "" {
+		// Bounded reads fail over to followers when the master is down
+		router.consistency = apiTypes.ConsistencyBounded
+	}
+	if router.latencyDecay <= 0 {
+		router.latencyDecay = 2 * time.Second
+	}
+	return router
+}
+
+// resolve returns the consistency a read asked for, the default when empty.
+func (r readRouter) resolve(consistency apiTypes.ReadConsistency) (apiTypes.ReadConsistency, error) {
+	switch consistency {
+	case "":
+		return r.consistency, nil
+	case apiTypes.ConsistencyStrong, apiTypes.ConsistencyBounded, apiTypes.ConsistencyEventual:
+		return consistency, nil
+	}
+	return "", &api.StatusError{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid consistency %q", consistency)}
+}
+
+// eligible returns the members of a shard that may serve a read at consistency.
+// The routing table only holds followers that are serving. The controller only
+// publishes the lag of a follower when it moves to another power of two bucket,
+// so bounded reads go by the ceiling of that bucket. The lag is measured at
+// heartbeats, a follower may fall further behind for up to a heartbeat interval
+// plus the time the topology takes to get here.
+func (r readRouter) eligible(shardInfo *cluster.ShardInfo, consistency apiTypes.ReadConsistency) []*cluster.NodeInfo {
+	var members []*cluster.NodeInfo
+	if shardInfo.Master != nil {
+		members = append(members, shardInfo.Master)
+	}
+	if consistency == apiTypes.ConsistencyStrong {
+		return members
+	}
+	for _, follower := range shardInfo.Followers {
+		if consistency == apiTypes.ConsistencyEventual || cluster.LagCeiling(follower.Lag) <= r.maxFollowerLag {
+			members = append(members, follower)
+		}
+	}
+	return members
+}
+
+// readCandidates returns the members of a shard a read at consistency may go
+// to, in the order to try them. The first is picked by the strategy, the others
+// are fallbacks from least to most loaded. A master that is not serving or
+// whose breaker is not closed is only tried after the followers.
+func (s *LoadBalancerService) readCandidates(shardInfo *cluster.ShardInfo, consistency apiTypes.ReadConsistency) []*cluster.NodeInfo {
+	members := s.reads.eligible(shardInfo, consistency)
+	if len(members) < 2 {
+		return members
+	}
+
+	type candidate struct {
+		node     *cluster.NodeInfo
+		inFlight int64
+		latency  time.Duration
+		cost     float64
+	}
+	candidates := make([]candidate, len(members))
+	for i, node := range members {
+		pool := s.pools.get(node)
+		c := candidate{node: node, inFlight: pool.inFlight.Load(), latency: pool.latency(s.reads.latencyDecay)}
+		// Every request in flight is expected to wait its turn
+		c.cost = float64(c.latency) * float64(c.inFlight+1)
+		if state, _, _, _ := pool.breaker.snapshot(); state != apiTypes.BreakerClosed || !serving(node) {
+			c.cost, c.inFlight = math.Inf(1), math.MaxInt64
+		}
+		candidates[i] = c
+	}
+
+	less := func(a, b candidate) bool { return a.cost < b.cost }
+	if s.reads.strategy == strategyLeastOutstanding {
+		less = func(a, b candidate) bool {
+			if a.inFlight != b.inFlight {
+				return a.inFlight < b.inFlight
+			}
+			return a.latency < b.latency
+		}
+	}
+	// Shuffle first so ties, such as nodes without requests yet, are spread
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if s.reads.strategy == strategyP2C {
+		// The better of two random members goes first, which keeps a member that
+		// just turned fast from getting all reads at once
+		if less(candidates[1], candidates[0]) {
+			candidates[0], candidates[1] = candidates[1], candidates[0]
+		}
+		rest := candidates[1:]
+		sort.SliceStable(rest, func(i, j int) bool { return less(rest[i], rest[j]) })
+	} else {
+		sort.SliceStable(candidates, func(i, j int) bool { return less(candidates[i], candidates[j]) })
+	}
+
+	nodes := make([]*cluster.NodeInfo, len(candidates))
+	for i, c := range candidates {
+		nodes[i] = c.node
+	}
+	return nodes
+}
+
+// serving reports whether a member of a shard holds an up to date copy of its
+// data. Nodes that are catching up, failed or in maintenance serve no reads.
+func serving(node *cluster.NodeInfo) bool {
+	return node.Status == cluster.NodeStatusActive || node.Status == cluster.NodeStatusSuspect
+}
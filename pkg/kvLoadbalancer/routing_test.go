@@ -0,0 +1,92 @@
+package kvLoadbalancer
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+)
+
+// testNode returns a node listening at address.
+func testNode(t *testing.T, id int, address string, status cluster.NodeStatus) cluster.NodeInfo {
+	t.Helper()
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cluster.NodeInfo{ID: id, Address: *addr, Status: status}
+}
+
+// deadAddress returns an address nothing listens on.
+func deadAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func TestReadsDefaultToBoundedConsistency(t *testing.T) {
+	for _, configured := range []string{"", "linearizable"} {
+		r := newReadRouter(config.ReadRoutingConfig{DefaultConsistency: configured})
+		if got, _ := r.resolve(""); got != apiTypes.ConsistencyBounded {
+			t.Errorf("default consistency %q with %q configured, want bounded", got, configured)
+		}
+	}
+	r := newReadRouter(config.ReadRoutingConfig{DefaultConsistency: string(apiTypes.ConsistencyStrong)})
+	if got, _ := r.resolve(""); got != apiTypes.ConsistencyStrong {
+		t.Errorf("default consistency %q with strong configured", got)
+	}
+}
+
+func TestReadsFailOverToFollowersWhenTheMasterIsDown(t *testing.T) {
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(apiTypes.GetResponse{Value: "v"})
+	}))
+	defer follower.Close()
+
+	s := NewLoadBalancerService(&config.KvLoadBalancerConfig{
+		Retry: config.RetryConfig{MaxAttempts: 1},
+	})
+	master := testNode(t, 0, deadAddress(t), cluster.NodeStatusFailed)
+	s.applyTopology(apiTypes.Topology{
+		Incarnation: "test",
+		Version:     1,
+		Partitions:  partition.Map{Version: 1, Mode: partition.ModeHash, Shards: []int{0}},
+		Keyspaces:   []apiTypes.Keyspace{{Name: apiTypes.DefaultKeyspace}},
+		Shards: []apiTypes.ShardTopology{{
+			ShardKey:  0,
+			Epoch:     1,
+			Master:    &master,
+			Followers: []cluster.NodeInfo{testNode(t, 1, follower.Listener.Addr().String(), cluster.NodeStatusActive)},
+		}},
+	})
+
+	// A failed master is tried last, the read is served by the follower
+	value, err := s.Get(context.Background(), apiTypes.GetRequest{Key: "a"})
+	if err != nil || value != "v" {
+		t.Fatalf("read with the master down got %q, %v", value, err)
+	}
+
+	// Nor does the follower come second to a master whose breaker is open
+	s.pools.get(&master).breaker = tripped(t)
+	_, shardInfo, _ := s.route("a")
+	shardInfo.Master.Status = cluster.NodeStatusActive
+	candidates := s.readCandidates(shardInfo, apiTypes.ConsistencyBounded)
+	if len(candidates) != 2 || candidates[0].ID != 1 {
+		t.Fatalf("read candidates %v, want the follower first", candidates)
+	}
+	if candidates := s.readCandidates(shardInfo, apiTypes.ConsistencyStrong); len(candidates) != 1 || candidates[0].ID != 0 {
+		t.Fatalf("strong read candidates %v, want the master only", candidates)
+	}
+}
@@ -2,156 +2,524 @@ package kvLoadbalancer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
 	log "github.com/sirupsen/logrus"
-	"hash/fnv"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/partition"
 	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
 	"github.com/Amirali-Amirifar/kv/pkg/kvLoadbalancer/api"
 )
 
+// routingTable is an immutable view of one topology version. It is replaced as
+// a whole so a request never sees a half-applied update.
+type routingTable struct {
+	// incarnation is the controller process version counts in
+	incarnation string
+	version     int64
+	shardNodes  map[int]*cluster.ShardInfo
+	partitions  partition.Map
+	locator     partition.Locator
+	keyspaces   map[string]apiTypes.KeyspaceQuota
+}
+
 type LoadBalancerService struct {
-	config     *config.KvLoadBalancerConfig
-	shardNodes map[int]*cluster.ShardInfo
-	client     *http.Client
-	mu         sync.RWMutex
+	config *config.KvLoadBalancerConfig
+	// instanceID and address identify this instance of the fleet
+	instanceID   string
+	address      string
+	started      time.Time
+	server       *api.HTTPServer
+	draining     atomic.Bool
+	routing      atomic.Pointer[routingTable]
+	client       atomic.Pointer[http.Client]
+	watchClient  *http.Client
+	watchTimeout time.Duration
+	pools        *nodePools
+	// breakerChanged asks for a breaker report to the controller
+	breakerChanged chan struct{}
+	limits         keyspaceLimits
+	retry          retryPolicy
+	reads          readRouter
+	hedging        *hedger
+	admission      *admission
+	cache          *readCache
+	flights        *flightGroup
+	// refreshing is set while a topology refresh triggered by a failure runs
+	refreshing atomic.Bool
+	// pollInterval and requestTimeout are time.Durations, both they and the
+	// client timeout can be changed by the runtime settings of settingsVersion.
+	// settingsMu serializes applying them so an older version never replaces a
+	// newer one
+	pollInterval    atomic.Int64
+	requestTimeout  atomic.Int64
+	settingsMu      sync.Mutex
+	settingsVersion atomic.Int64
 }
 
 func NewLoadBalancerService(cfg *config.KvLoadBalancerConfig) *LoadBalancerService {
+	watchTimeout := time.Duration(cfg.TopologyWatchTimeoutMs) * time.Millisecond
+	if watchTimeout <= 0 {
+		watchTimeout = 30 * time.Second
+	}
+	pollInterval := time.Duration(cfg.TopologyPollIntervalMs) * time.Millisecond
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+	requestTimeout := time.Duration(cfg.Transport.RequestTimeoutMs) * time.Millisecond
+	if requestTimeout <= 0 {
+		requestTimeout = 5 * time.Second
+	}
+
+	address := advertisedAddress(cfg.Address.Host, cfg.Address.Port)
+	instanceID := cfg.ID
+	if instanceID == "" {
+		instanceID = address
+	}
 
 	svc := &LoadBalancerService{
 		config:     cfg,
-		shardNodes: make(map[int]*cluster.ShardInfo),
-		client:     &http.Client{},
-		mu:         sync.RWMutex{},
+		instanceID: instanceID,
+		address:    address,
+		started:    time.Now(),
+		// A long-poll legitimately takes up to watchTimeout
+		watchClient:  &http.Client{Timeout: watchTimeout + 10*time.Second},
+		watchTimeout: watchTimeout,
+		retry:        newRetryPolicy(cfg.Retry),
+		reads:        newReadRouter(cfg.ReadRouting),
+		hedging:      newHedger(cfg.Hedging),
+		admission:    newAdmission(cfg.RateLimits),
+		cache:        newReadCache(cfg.Cache),
+		flights:      newFlightGroup(cfg.Coalescing),
+		// Holds one pending report, changes coming in meanwhile are part of it
+		breakerChanged: make(chan struct{}, 1),
 	}
+	svc.server = api.NewHTTPServer(svc)
+	svc.pools = newNodePools(cfg.Transport, newBreakerPolicy(cfg.Breaker), svc.breakerChange)
+	svc.client.Store(&http.Client{Timeout: requestTimeout})
+	svc.pollInterval.Store(int64(pollInterval))
+	svc.requestTimeout.Store(int64(requestTimeout))
 
 	return svc
 }
 
-func (s *LoadBalancerService) Serve() {
-	server := api.NewHTTPServer(s)
-	go s.UpdateNodeData()
-	err := server.Serve(s.config.Address.Port)
-	if err != nil {
-		panic(err)
-		return
-	}
+// httpClient returns the client for requests to the controller, nodes are
+// reached through their connection pools.
+func (s *LoadBalancerService) httpClient() *http.Client {
+	return s.client.Load()
 }
 
-func (s *LoadBalancerService) calculateShard(key string) int {
-	h := fnv.New32a()
-	_, err := h.Write([]byte(key))
-	if err != nil {
-		log.Fatalf("Error hashing key %v: %v", key, err)
-		return 0
-	}
-	return int(h.Sum32()) % len(s.shardNodes)
+// id identifies the load balancer to the controller.
+func (s *LoadBalancerService) id() string {
+	return s.instanceID
 }
 
-func (s *LoadBalancerService) Get(key string) (string, error) {
-	s.mu.RLock()
-	shardID := s.calculateShard(key)
-	shardInfo, exists := s.shardNodes[shardID]
-	s.mu.RUnlock()
+// Serve follows the topology, registers with the controller and serves
+// requests until Shutdown. It reports ready once the topology is synced.
+func (s *LoadBalancerService) Serve() error {
+	go s.watchTopology()
+	go s.reportBreakers()
+	go s.heartbeatLoop()
+	return s.server.Serve(s.config.Address.Port)
+}
 
-	if !exists || len(shardInfo.Followers) == 0 {
-		return "", fmt.Errorf("no available nodes for shard %d", shardID)
+// route finds the shard that owns key in the current routing table.
+func (s *LoadBalancerService) route(key string) (int, *cluster.ShardInfo, error) {
+	table := s.routing.Load()
+	if table == nil {
+		return 0, nil, fmt.Errorf("partition map not loaded yet")
 	}
+	shardID, ok := table.locator.Locate(key)
+	if !ok {
+		return 0, nil, fmt.Errorf("partition map %d has no shard for key %q", table.locator.Version(), key)
+	}
+	return shardID, table.shardNodes[shardID], nil
+}
 
-	// Round-robin between followers for read requests
-	node := shardInfo.Master
-	//if len(shardInfo.Followers) > 0 {
-	//	node = shardInfo.Followers[0] // TODO: Implement proper load balancing
-	//}
-	//
-	req := apiTypes.GetRequest{Key: key}
-	reqBody, err := json.Marshal(req)
+// Get reads a key. Reads that are not strongly consistent are served from the
+// cache when enabled, unless the request bypasses it, and concurrent identical
+// reads share one request to the nodes.
+func (s *LoadBalancerService) Get(ctx context.Context, get apiTypes.GetRequest) (string, error) {
+	consistency, err := s.reads.resolve(get.Consistency)
 	if err != nil {
 		return "", err
 	}
-
-	resp, err := http.Post(
-		fmt.Sprintf("http://%s:%d/get", node.Address.IP, node.Address.Port),
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
+	keyspace, err := s.admit(get.Keyspace)
 	if err != nil {
-		return "", fmt.Errorf("error getting key: %v", err)
+		return "", err
 	}
-	defer resp.Body.Close()
+	key := get.Key
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("node returned status %d", resp.StatusCode)
+	cached := s.cache.enabled && !get.NoCache && consistency != apiTypes.ConsistencyStrong
+	if cached {
+		if value, ok := s.cache.get(keyspace, key); ok {
+			return value, nil
+		}
+	}
+	// The ticket tells the values and flights a write overtook, a read that is
+	// neither cached nor shared with others needs none
+	var ticket readTicket
+	if cached || s.flights.enabled {
+		ticket = s.cache.begin(keyspace, key)
+		defer s.cache.end(keyspace, key)
 	}
 
+	flight := flightKey{keyspace: keyspace, key: key, consistency: consistency, noCache: get.NoCache}
+	return s.flights.do(ctx, flight, ticket, func(ctx context.Context) (string, error) {
+		return s.read(ctx, keyspace, key, consistency, cached, ticket)
+	})
+}
+
+// read fetches a key from the members of its shard consistency allows, and
+// caches values the master answered with when cached.
+func (s *LoadBalancerService) read(ctx context.Context, keyspace, key string, consistency apiTypes.ReadConsistency, cached bool, ticket readTicket) (string, error) {
+	req := apiTypes.GetRequest{Keyspace: keyspace, Key: key}
 	var getResp apiTypes.GetResponse
-	if err := json.NewDecoder(resp.Body).Decode(&getResp); err != nil {
+	err := s.withRetry(ctx, op{name: "get", idempotent: true}, key, func(shardID int, shardInfo *cluster.ShardInfo) error {
+		var candidates []*cluster.NodeInfo
+		if shardInfo != nil {
+			candidates = s.readCandidates(shardInfo, consistency)
+		}
+		if len(candidates) == 0 {
+			return fmt.Errorf("no available nodes for shard %d", shardID)
+		}
+		node, err := s.readFrom(ctx, candidates, req, &getResp)
+		// Followers may lag behind the write stream the cache is kept fresh by
+		if err == nil && cached && shardInfo.Master != nil && node.ID == shardInfo.Master.ID {
+			s.cache.put(keyspace, key, shardID, getResp.Value, ticket)
+		}
+		return err
+	})
+	if err != nil {
 		return "", err
 	}
 
 	return getResp.Value, nil
 }
 
-func (s *LoadBalancerService) Set(key, value string) error {
-	s.mu.RLock()
-	shardID := s.calculateShard(key)
-	shardInfo, exists := s.shardNodes[shardID]
-	s.mu.RUnlock()
+func (s *LoadBalancerService) Set(ctx context.Context, keyspace, key, value string) error {
+	keyspace, err := s.admit(keyspace)
+	if err != nil {
+		return err
+	}
+
+	req := apiTypes.SetRequest{Keyspace: keyspace, Key: key, Value: value}
+	// The write stream evicts the key too, this makes it read-your-writes here
+	defer s.cache.invalidateKey(keyspace, key)
+	return s.withRetry(ctx, op{name: "set"}, key, func(shardID int, shardInfo *cluster.ShardInfo) error {
+		if shardInfo == nil || shardInfo.Master == nil {
+			return fmt.Errorf("%w for shard %d", errNoMaster, shardID)
+		}
+		return s.callNode(ctx, shardInfo.Master, "/set", req, nil)
+	})
+}
 
-	if !exists || shardInfo.Master == nil {
-		return fmt.Errorf("no master node available for shard %d", shardID)
+func (s *LoadBalancerService) Del(ctx context.Context, keyspace, key string) error {
+	keyspace, err := s.admit(keyspace)
+	if err != nil {
+		return err
 	}
 
-	req := apiTypes.SetRequest{Key: key, Value: value}
+	req := apiTypes.DelRequest{Keyspace: keyspace, Key: key}
+	defer s.cache.invalidateKey(keyspace, key)
+	return s.withRetry(ctx, op{name: "del"}, key, func(shardID int, shardInfo *cluster.ShardInfo) error {
+		if shardInfo == nil || shardInfo.Master == nil {
+			return fmt.Errorf("%w for shard %d", errNoMaster, shardID)
+		}
+		return s.callNode(ctx, shardInfo.Master, "/del", req, nil)
+	})
+}
+
+// callNode POSTs req as JSON to path on node through its connection pool and
+// decodes the response into out unless it is nil. The request ends with ctx or
+// after the request timeout, whichever comes first. Error responses are relayed
+// as a StatusError, nodes with an open circuit breaker are not called at all.
+// An ASK redirect is followed once, a MOVED one is left to the retries.
+func (s *LoadBalancerService) callNode(ctx context.Context, node *cluster.NodeInfo, path string, req, out interface{}) error {
 	reqBody, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(
-		fmt.Sprintf("http://%s:%d/set", shardInfo.Master.Address.IP, shardInfo.Master.Address.Port),
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
+	err = s.send(ctx, node, path, reqBody, false, out)
+	var statusErr *api.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Redirect == nil || statusErr.Redirect.Kind != apiTypes.RedirectAsk {
+		return err
+	}
+	target, resolveErr := redirectNode(statusErr.Redirect)
+	if resolveErr != nil {
+		return err
+	}
+	return s.send(ctx, target, path, reqBody, true, out)
+}
+
+// redirectNode is the node a redirect points to.
+func redirectNode(redirect *apiTypes.Redirect) (*cluster.NodeInfo, error) {
+	address, err := net.ResolveTCPAddr("tcp", redirect.Address)
 	if err != nil {
+		return nil, fmt.Errorf("invalid redirect address %q: %v", redirect.Address, err)
+	}
+	return &cluster.NodeInfo{ID: redirect.NodeID, ShardKey: redirect.ShardKey, Address: *address}, nil
+}
+
+// send makes one request of callNode, asking marks it as following an ASK.
+func (s *LoadBalancerService) send(ctx context.Context, node *cluster.NodeInfo, path string, reqBody []byte, asking bool, out interface{}) (err error) {
+	pool := s.pools.get(node)
+	generation, allowed, retryAfter := pool.breaker.allow()
+	if !allowed {
+		return &api.StatusError{
+			Code:       http.StatusServiceUnavailable,
+			Message:    fmt.Sprintf("circuit breaker of node %d is open", node.ID),
+			RetryAfter: retryAfter,
+			Err:        api.ErrCircuitOpen,
+		}
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, time.Duration(s.requestTimeout.Load()))
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(reqCtx, http.MethodPost, "http://"+pool.address+path, bytes.NewBuffer(reqBody))
+	if err != nil {
+		pool.breaker.record(generation, 0, outcomeIgnored)
 		return err
 	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if table := s.routing.Load(); table != nil {
+		httpReq.Header.Set(apiTypes.TopologyIncarnationHeader, table.incarnation)
+		httpReq.Header.Set(apiTypes.TopologyVersionHeader, strconv.FormatInt(table.version, 10))
+	}
+	if asking {
+		httpReq.Header.Set(apiTypes.AskingHeader, "1")
+	}
+
+	pool.inFlight.Add(1)
+	start := time.Now()
+	defer func() {
+		latency := time.Since(start)
+		result := breakerOutcome(ctx, err)
+		pool.done(latency, result == outcomeFailure)
+		pool.breaker.record(generation, latency, result)
+	}()
+
+	resp, err := pool.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("error calling node %d: %w", node.ID, err)
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("master node returned status %d", resp.StatusCode)
+		return nodeError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// RoutingTable returns the current routing table for smart clients.
+func (s *LoadBalancerService) RoutingTable() (apiTypes.RoutingTable, error) {
+	table := s.routing.Load()
+	if table == nil {
+		return apiTypes.RoutingTable{}, fmt.Errorf("partition map not loaded yet")
+	}
+
+	routes := apiTypes.RoutingTable{
+		Incarnation: table.incarnation,
+		Version:     table.version,
+		Partitions:  table.partitions,
+		Shards:      make([]apiTypes.ShardRoute, 0, len(table.shardNodes)),
+	}
+	for shardKey, shardInfo := range table.shardNodes {
+		route := apiTypes.ShardRoute{
+			ShardKey: shardKey,
+			Epoch:    shardInfo.Epoch,
+			Replicas: make([]apiTypes.RouteNode, 0, len(shardInfo.Followers)),
+		}
+		if shardInfo.Master != nil {
+			route.Master = &apiTypes.RouteNode{ID: shardInfo.Master.ID, Address: nodeAddress(shardInfo.Master)}
+		}
+		for _, follower := range shardInfo.Followers {
+			route.Replicas = append(route.Replicas, apiTypes.RouteNode{ID: follower.ID, Address: nodeAddress(follower)})
+		}
+		routes.Shards = append(routes.Shards, route)
 	}
+	sort.Slice(routes.Shards, func(i, j int) bool {
+		return routes.Shards[i].ShardKey < routes.Shards[j].ShardKey
+	})
+	return routes, nil
+}
+
+// Coalescing returns the read coalescing counters.
+func (s *LoadBalancerService) Coalescing() apiTypes.CoalescingStats {
+	return s.flights.stats()
+}
+
+// Cache returns the read cache counters.
+func (s *LoadBalancerService) Cache() apiTypes.CacheStats {
+	return s.cache.stats()
+}
+
+// Hedging returns the hedged read counters.
+func (s *LoadBalancerService) Hedging() apiTypes.HedgingStats {
+	return s.hedging.stats()
+}
 
+// NodePools returns the connection pool stats of every node.
+func (s *LoadBalancerService) NodePools() []apiTypes.NodePoolStats {
+	return s.pools.stats()
+}
+
+// UpdateNodeData fetches the current topology from the controller once.
+func (s *LoadBalancerService) UpdateNodeData() error {
+	var topology apiTypes.Topology
+	if err := s.fetchController("/internal/topology", &topology); err != nil {
+		return fmt.Errorf("error getting topology: %v", err)
+	}
+	s.applyTopology(topology)
 	return nil
 }
 
-func (s *LoadBalancerService) Del(key string) error {
-	s.mu.RLock()
-	shardID := s.calculateShard(key)
-	shardInfo, exists := s.shardNodes[shardID]
-	s.mu.RUnlock()
+// watchTopology keeps the routing table in sync with the controller. It
+// long-polls the watch endpoint and polls instead while watching fails.
+func (s *LoadBalancerService) watchTopology() {
+	for {
+		var incarnation string
+		var version int64
+		if table := s.routing.Load(); table != nil {
+			incarnation, version = table.incarnation, table.version
+		}
 
-	if !exists || shardInfo.Master == nil {
-		return fmt.Errorf("no master node available for shard %d", shardID)
+		var topology apiTypes.Topology
+		changed, err := s.watchOnce(incarnation, version, &topology)
+		if err != nil {
+			log.WithError(err).Warn("Topology watch failed, polling the controller")
+			if err := s.UpdateNodeData(); err != nil {
+				log.WithError(err).Warn("Topology poll failed")
+			}
+			time.Sleep(time.Duration(s.pollInterval.Load()))
+			continue
+		}
+		if changed {
+			s.applyTopology(topology)
+		}
 	}
+}
 
-	req := apiTypes.DelRequest{Key: key}
-	reqBody, err := json.Marshal(req)
+// watchOnce long-polls the controller for a topology newer than version, or
+// for any topology of another incarnation.
+func (s *LoadBalancerService) watchOnce(incarnation string, version int64, out *apiTypes.Topology) (bool, error) {
+	resp, err := s.watchClient.Get(fmt.Sprintf("http://%s:%d/internal/topology/watch?incarnation=%s&version=%d&timeout_ms=%d",
+		s.config.Controller.Host, s.config.Controller.Port, url.QueryEscape(incarnation), version, s.watchTimeout.Milliseconds()))
 	if err != nil {
-		return err
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, fmt.Errorf("error parsing topology: %v", err)
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("received status code %d from topology watch", resp.StatusCode)
+	}
+}
+
+// applyTopology builds a routing table from topology and swaps it in, versions
+// older than the current one are ignored. A topology from another controller
+// incarnation always replaces the table, its versions started over.
+func (s *LoadBalancerService) applyTopology(topology apiTypes.Topology) {
+	shardNodes := make(map[int]*cluster.ShardInfo, len(topology.Shards))
+	for _, shard := range topology.Shards {
+		shardInfo := &cluster.ShardInfo{
+			ShardKey: shard.ShardKey,
+			Master:   shard.Master,
+			Epoch:    shard.Epoch,
+		}
+		for i := range shard.Followers {
+			if serving(&shard.Followers[i]) {
+				shardInfo.Followers = append(shardInfo.Followers, &shard.Followers[i])
+			}
+		}
+		shardNodes[shard.ShardKey] = shardInfo
+	}
+	keyspaces := make(map[string]apiTypes.KeyspaceQuota, len(topology.Keyspaces))
+	for _, keyspace := range topology.Keyspaces {
+		keyspaces[keyspace.Name] = keyspace.Quota
+	}
+	table := &routingTable{
+		incarnation: topology.Incarnation,
+		version:     topology.Version,
+		shardNodes:  shardNodes,
+		partitions:  topology.Partitions,
+		locator:     partition.NewLocator(topology.Partitions),
+		keyspaces:   keyspaces,
+	}
+
+	var first, restarted bool
+	for {
+		current := s.routing.Load()
+		if current != nil && current.incarnation == table.incarnation && current.version >= table.version {
+			return
+		}
+		if s.routing.CompareAndSwap(current, table) {
+			first = current == nil
+			restarted = current != nil && current.incarnation != table.incarnation
+			break
+		}
+	}
+	s.limits.update(topology.Keyspaces)
+	s.pools.retain(shardNodes)
+	if restarted {
+		// Partition map versions started over as well, nothing cached can be trusted
+		log.WithField("incarnation", table.incarnation).Warn("Controller restarted, replacing the routing table")
+		s.cache.invalidate(func(*cacheEntry) bool { return true })
 	}
+	s.cache.sync(table.locator.Version(), shardNodes)
+
+	log.Printf("Applied topology version %d: %d shards, %s partition map version %d",
+		table.version, len(shardNodes), topology.Partitions.Mode, table.locator.Version())
+
+	if restarted || topology.SettingsVersion > s.settingsVersion.Load() {
+		if err := s.syncSettings(); err != nil {
+			log.WithError(err).Warn("Failed to sync runtime settings")
+		}
+	}
+	if first {
+		log.WithField("id", s.id()).Info("Load balancer ready")
+		go func() {
+			if err := s.sendHeartbeat(); err != nil {
+				log.WithError(err).Warn("Failed to send heartbeat to the controller")
+			}
+		}()
+	}
+}
 
-	resp, err := http.Post(
-		fmt.Sprintf("http://%s:%d/del", shardInfo.Master.Address, shardInfo.Master.Address.Port),
+// postController POSTs in as JSON to path on the controller.
+func (s *LoadBalancerService) postController(path string, in interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient().Post(
+		fmt.Sprintf("http://%s:%d%s", s.config.Controller.Host, s.config.Controller.Port, path),
 		"application/json",
-		bytes.NewBuffer(reqBody),
+		bytes.NewBuffer(body),
 	)
 	if err != nil {
 		return err
@@ -159,91 +527,33 @@ func (s *LoadBalancerService) Del(key string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("master node returned status %d", resp.StatusCode)
+		return fmt.Errorf("received status code %d from %s", resp.StatusCode, path)
 	}
-
 	return nil
 }
 
-func (s *LoadBalancerService) UpdateNodeData() {
-	type ClusterResponse struct {
-		Shards map[string][]cluster.NodeInfo `json:"shards"`
-	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Make HTTP request to get cluster data
-	resp, err := http.Get(fmt.Sprintf("http://%s:%d/admin/cluster", s.config.Controller.Host, s.config.Controller.Port))
+// fetchController GETs path from the controller and decodes the JSON body into out.
+func (s *LoadBalancerService) fetchController(path string, out interface{}) error {
+	resp, err := s.httpClient().Get(fmt.Sprintf("http://%s:%d%s", s.config.Controller.Host, s.config.Controller.Port, path))
 	if err != nil {
-		log.Printf("Error getting cluster data: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	// Check if request was successful
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error: received status code %d from cluster endpoint", resp.StatusCode)
-		return
+		return fmt.Errorf("received status code %d from %s", resp.StatusCode, path)
 	}
 
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return
+		return fmt.Errorf("error reading response body: %v", err)
 	}
 
 	// Parse JSON response
-	var clusterData ClusterResponse
-	if err := json.Unmarshal(body, &clusterData); err != nil {
-		log.Printf("Error parsing JSON response: %v", err)
-		return
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("error parsing JSON response: %v", err)
 	}
-
-	// Initialize shardNodes map if it doesn't exist
-	if s.shardNodes == nil {
-		s.shardNodes = make(map[int]*cluster.ShardInfo)
-	}
-
-	// Process each shard
-	for shardKeyStr, nodes := range clusterData.Shards {
-		if len(nodes) == 0 {
-			continue
-		}
-
-		// Convert shard key from string to int (since JSON keys are strings)
-		var shardKey int
-		if _, err := fmt.Sscanf(shardKeyStr, "%d", &shardKey); err != nil {
-			log.Printf("Error parsing shard key %s: %v", shardKeyStr, err)
-			continue
-		}
-
-		var master *cluster.NodeInfo
-		var followers []*cluster.NodeInfo
-
-		// Categorize nodes based on node_type or leader_id
-		for i := range nodes {
-			node := &nodes[i]
-			// Determine if node is master based on node_type or if it's the leader
-			if node.StoreNodeType == cluster.NodeTypeMaster || node.ID == node.LeaderID {
-				master = node
-			} else {
-				followers = append(followers, node)
-			}
-		}
-
-		// Update shard information
-		s.shardNodes[shardKey] = &cluster.ShardInfo{
-			Master:    master,
-			Followers: followers,
-		}
-
-		log.Printf("Updated shard %d: master=%v, followers=%d",
-			shardKey,
-			master != nil,
-			len(followers))
-	}
-
-	log.Printf("Successfully updated cluster data for %d shards", len(s.shardNodes))
+	return nil
 }
@@ -0,0 +1,93 @@
+package kvLoadbalancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	apiTypes "github.com/Amirali-Amirifar/kv/internal/types/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// applySettings switches the load balancer to newer runtime settings. Settings
+// that are not set fall back to the config file.
+func (s *LoadBalancerService) applySettings(applied apiTypes.AppliedSettings) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	if applied.Version <= s.settingsVersion.Load() {
+		return
+	}
+	settings := applied.Settings
+
+	level := log.InfoLevel
+	if settings.LogLevel != nil {
+		if parsed, err := log.ParseLevel(*settings.LogLevel); err == nil {
+			level = parsed
+		}
+	}
+	log.SetLevel(level)
+
+	// Requests in flight keep the timeout they started with
+	timeout := time.Duration(s.config.Transport.RequestTimeoutMs) * time.Millisecond
+	if settings.HTTPTimeoutMs != nil {
+		timeout = time.Duration(*settings.HTTPTimeoutMs) * time.Millisecond
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	s.client.Store(&http.Client{Timeout: timeout})
+	s.requestTimeout.Store(int64(timeout))
+
+	pollInterval := time.Duration(s.config.TopologyPollIntervalMs) * time.Millisecond
+	if settings.TopologyPollIntervalMs != nil {
+		pollInterval = time.Duration(*settings.TopologyPollIntervalMs) * time.Millisecond
+	}
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+	s.pollInterval.Store(int64(pollInterval))
+
+	s.settingsVersion.Store(applied.Version)
+	log.WithFields(log.Fields{
+		"version":      applied.Version,
+		"logLevel":     level,
+		"httpTimeout":  timeout,
+		"pollInterval": pollInterval,
+	}).Info("Applied runtime settings")
+}
+
+// syncSettings fetches the cluster-wide runtime settings from the controller,
+// applies them and reports the version now running.
+func (s *LoadBalancerService) syncSettings() error {
+	var applied apiTypes.AppliedSettings
+	if err := s.fetchController("/internal/settings", &applied); err != nil {
+		return fmt.Errorf("error getting settings: %v", err)
+	}
+	s.applySettings(applied)
+
+	ack := apiTypes.SettingsAck{
+		ID:      s.id(),
+		Version: s.settingsVersion.Load(),
+	}
+	body, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient().Post(
+		fmt.Sprintf("http://%s:%d/internal/settings/ack", s.config.Controller.Host, s.config.Controller.Port),
+		"application/json",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return fmt.Errorf("error reporting settings version: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received status code %d reporting settings version", resp.StatusCode)
+	}
+	return nil
+}
@@ -2,9 +2,11 @@ package api
 
 import (
 	"bytes"
+	"errors"
+	"github.com/Amirali-Amirifar/kv/internal/partition"
 	"github.com/Amirali-Amirifar/kv/internal/types/api"
-	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -14,13 +16,26 @@ import (
 )
 
 type KvService interface {
-	Get(key string) (string, error)
-	Set(key, value string) error
-	Del(key string) error
+	Get(keyspace, key string) (string, error)
+	Set(keyspace, key, value string) error
+	Del(keyspace, key string) error
 	GetLastSeq() int64
-	UpdateNodeState(state cluster.StoreNodeType, leaderID int) error
-	GetWALSince(seq int64) []kvNode.WALRecord
+	UpdateNodeState(update api.StateUpdate) error
+	Fence(fenced bool) (int64, error)
+	GetWALSince(seq int64) ([]kvNode.WALRecord, error)
+	Snapshot() kvNode.Snapshot
 	UpdateFollowerProgress(followerID int, seq int64)
+	Decommission()
+	AssignShard(assignment api.ShardAssignment) error
+	Stats() api.NodeStats
+	MedianKey(r partition.Range) (string, int)
+	ExportRange(r partition.Range) map[string]map[string]string
+	ImportRange(data map[string]map[string]string) error
+	DropRange(r partition.Range) error
+	ApplyKeyspaces(catalog api.KeyspaceCatalog)
+	ApplySettings(applied api.AppliedSettings)
+	CheckOwner(key string, asking bool, incarnation string, version int64) error
+	MigrateRange(req api.RangeMigrationRequest) error
 }
 
 type HTTPServer struct {
@@ -68,10 +83,59 @@ func (s *HTTPServer) registerRoutes() {
 	s.router.POST("/set", s.handleSet)
 	s.router.POST("/del", s.handleDel)
 	s.router.POST("/health", s.handleHealth)
+	s.router.GET("/health", s.handleHealth)
 	s.router.GET("/last-seq", s.handleLastSeq)
 	s.router.POST("/update-state", s.handleUpdateState)
+	s.router.POST("/fence", s.handleFence)
 	s.router.GET("/wal/get-since", s.handleGetWALSince)
 	s.router.POST("/wal/progress", s.handleWALProgress)
+	s.router.GET("/snapshot", s.handleSnapshot)
+	s.router.POST("/decommission", s.handleDecommission)
+	s.router.POST("/assign-shard", s.handleAssignShard)
+	s.router.GET("/stats", s.handleStats)
+	s.router.GET("/range/median", s.handleRangeMedian)
+	s.router.GET("/range/export", s.handleRangeExport)
+	s.router.POST("/range/import", s.handleRangeImport)
+	s.router.POST("/range/drop", s.handleRangeDrop)
+	s.router.POST("/range/migration", s.handleRangeMigration)
+	s.router.POST("/keyspaces", s.handleKeyspaces)
+	s.router.POST("/settings", s.handleSettings)
+}
+
+// writeError answers with the status matching err, fallback when none does.
+func writeError(c *gin.Context, err error, fallback int) {
+	status := fallback
+	var rateLimited *kvNode.RateLimitError
+	var redirect *kvNode.RedirectError
+	switch {
+	case errors.Is(err, kvNode.ErrDecommissioned), errors.Is(err, kvNode.ErrFenced), errors.Is(err, kvNode.ErrRangeFrozen):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, kvNode.ErrKeyspaceNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, kvNode.ErrQuotaExceeded):
+		status = http.StatusInsufficientStorage
+	case errors.Is(err, kvNode.ErrNotMaster):
+		status = http.StatusConflict
+	case errors.As(err, &rateLimited):
+		status = http.StatusTooManyRequests
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(rateLimited.RetryAfter.Seconds()))))
+	case errors.As(err, &redirect):
+		c.JSON(http.StatusMisdirectedRequest, gin.H{"error": err.Error(), "redirect": redirect.Redirect})
+		return
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// checkOwner answers requests for keys the node does not serve with a
+// redirect, it reports whether the request may go on.
+func (s *HTTPServer) checkOwner(c *gin.Context, key string) bool {
+	version, _ := strconv.ParseInt(c.GetHeader(api.TopologyVersionHeader), 10, 64)
+	incarnation := c.GetHeader(api.TopologyIncarnationHeader)
+	if err := s.svc.CheckOwner(key, c.GetHeader(api.AskingHeader) != "", incarnation, version); err != nil {
+		writeError(c, err, http.StatusMisdirectedRequest)
+		return false
+	}
+	return true
 }
 
 // handleGet processes GET requests
@@ -82,9 +146,12 @@ func (s *HTTPServer) handleGet(c *gin.Context) {
 		return
 	}
 
-	val, err := s.svc.Get(req.Key)
+	if !s.checkOwner(c, req.Key) {
+		return
+	}
+	val, err := s.svc.Get(req.Keyspace, req.Key)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		writeError(c, err, http.StatusNotFound)
 		return
 	}
 
@@ -101,8 +168,11 @@ func (s *HTTPServer) handleSet(c *gin.Context) {
 		return
 	}
 
-	if err := s.svc.Set(req.Key, req.Value); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !s.checkOwner(c, req.Key) {
+		return
+	}
+	if err := s.svc.Set(req.Keyspace, req.Key, req.Value); err != nil {
+		writeError(c, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -117,8 +187,11 @@ func (s *HTTPServer) handleDel(c *gin.Context) {
 		return
 	}
 
-	if err := s.svc.Del(req.Key); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !s.checkOwner(c, req.Key) {
+		return
+	}
+	if err := s.svc.Del(req.Keyspace, req.Key); err != nil {
+		writeError(c, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -135,19 +208,40 @@ func (s *HTTPServer) handleLastSeq(c *gin.Context) {
 }
 
 func (s *HTTPServer) handleUpdateState(c *gin.Context) {
-	var req UpdateStateRequest
+	var req api.StateUpdate
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := s.svc.UpdateNodeState(req.State, req.LeaderID); err != nil {
+	err := s.svc.UpdateNodeState(req)
+	if errors.Is(err, kvNode.ErrAlreadyLeader) || errors.Is(err, kvNode.ErrAlreadyFollower) {
+		// Repeated updates, e.g. retried by the controller, change nothing
+		c.JSON(http.StatusOK, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	c.Status(http.StatusOK)
 }
 
+func (s *HTTPServer) handleFence(c *gin.Context) {
+	var req api.FenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lastSeq, err := s.svc.Fence(req.Fenced)
+	if err != nil {
+		writeError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"last_seq": lastSeq})
+}
+
 func (s *HTTPServer) handleGetWALSince(c *gin.Context) {
 	seqStr := c.Query("since")
 	seq, err := strconv.ParseInt(seqStr, 10, 64)
@@ -156,10 +250,18 @@ func (s *HTTPServer) handleGetWALSince(c *gin.Context) {
 		return
 	}
 
-	wal := s.svc.GetWALSince(seq)
+	wal, err := s.svc.GetWALSince(seq)
+	if errors.Is(err, kvNode.ErrWALTruncated) {
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, wal)
 }
 
+func (s *HTTPServer) handleSnapshot(c *gin.Context) {
+	c.JSON(http.StatusOK, s.svc.Snapshot())
+}
+
 func (s *HTTPServer) handleWALProgress(c *gin.Context) {
 	var req WALProgressRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -170,3 +272,110 @@ func (s *HTTPServer) handleWALProgress(c *gin.Context) {
 	s.svc.UpdateFollowerProgress(req.FollowerID, req.Seq)
 	c.Status(http.StatusOK)
 }
+
+func (s *HTTPServer) handleDecommission(c *gin.Context) {
+	s.svc.Decommission()
+	c.Status(http.StatusOK)
+}
+
+func (s *HTTPServer) handleAssignShard(c *gin.Context) {
+	var req api.ShardAssignment
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.svc.AssignShard(req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (s *HTTPServer) handleStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.svc.Stats())
+}
+
+// queryRange reads the [start, end) key range from the query string.
+func queryRange(c *gin.Context) partition.Range {
+	return partition.Range{
+		Start: c.Query("start"),
+		End:   c.Query("end"),
+	}
+}
+
+func (s *HTTPServer) handleRangeMedian(c *gin.Context) {
+	key, count := s.svc.MedianKey(queryRange(c))
+	c.JSON(http.StatusOK, api.RangeMedianResponse{Key: key, Count: count})
+}
+
+func (s *HTTPServer) handleRangeExport(c *gin.Context) {
+	c.JSON(http.StatusOK, api.RangeExportResponse{Data: s.svc.ExportRange(queryRange(c))})
+}
+
+func (s *HTTPServer) handleRangeImport(c *gin.Context) {
+	var req api.RangeExportResponse
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.svc.ImportRange(req.Data); err != nil {
+		writeError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (s *HTTPServer) handleRangeDrop(c *gin.Context) {
+	var req api.RangeDropRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.svc.DropRange(partition.Range{Start: req.Start, End: req.End}); err != nil {
+		writeError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// handleRangeMigration marks a range as handed over to another shard.
+func (s *HTTPServer) handleRangeMigration(c *gin.Context) {
+	var req api.RangeMigrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.svc.MigrateRange(req); err != nil {
+		writeError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// handleKeyspaces installs the keyspace catalog pushed by the controller.
+func (s *HTTPServer) handleKeyspaces(c *gin.Context) {
+	var req api.KeyspaceCatalog
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.svc.ApplyKeyspaces(req)
+	c.Status(http.StatusOK)
+}
+
+// handleSettings applies the runtime settings pushed by the controller.
+func (s *HTTPServer) handleSettings(c *gin.Context) {
+	var req api.AppliedSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.svc.ApplySettings(req)
+	c.Status(http.StatusOK)
+}
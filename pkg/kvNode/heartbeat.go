@@ -0,0 +1,110 @@
+package kvNode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+// heartbeatLoop pushes a heartbeat to the controller every interval until the
+// node is decommissioned. The controller's failure detector relies on them.
+func (k *Service) heartbeatLoop() {
+	interval := k.currentHeartbeatInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if k.currentState().Decommissioned {
+			return
+		}
+		// The interval may be changed by the runtime settings
+		if next := k.currentHeartbeatInterval(); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+		if err := k.sendHeartbeat(); err != nil {
+			logrus.WithError(err).Warn("Failed to send heartbeat")
+		}
+	}
+}
+
+// currentHeartbeatInterval returns the heartbeat interval in effect, one second when unset.
+func (k *Service) currentHeartbeatInterval() time.Duration {
+	if interval := time.Duration(k.heartbeatInterval.Load()); interval > 0 {
+		return interval
+	}
+	return time.Second
+}
+
+func (k *Service) sendHeartbeat() error {
+	k.mu.RLock()
+	req := api.HeartbeatRequest{
+		NodeID: k.state.NodeID,
+		Role:   cluster.NodeTypeFollower,
+		Epoch:  k.state.Epoch,
+	}
+	switch {
+	case k.state.ShardKey < 0:
+		req.Role = cluster.NodeTypeUnknown
+	case k.state.IsMaster:
+		req.Role = cluster.NodeTypeMaster
+	}
+	k.mu.RUnlock()
+	req.LastSeq = k.GetLastSeq()
+	req.Load = k.Stats()
+	req.SettingsVersion = k.settingsVersion.Load()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %v", err)
+	}
+
+	resp, err := k.httpClient().Post(
+		fmt.Sprintf("http://%s:%d/internal/nodes/heartbeat", k.config.Controller.Host, k.config.Controller.Port),
+		"application/json",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("controller returned status %d", resp.StatusCode)
+	}
+
+	var hb api.HeartbeatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&hb); err != nil {
+		return fmt.Errorf("failed to decode heartbeat response: %v", err)
+	}
+
+	k.keyspaces.setRemoteUsage(hb.RemoteUsage)
+	if hb.SettingsVersion > k.settingsVersion.Load() {
+		if err := k.syncSettings(); err != nil {
+			logrus.WithError(err).Warn("Failed to sync runtime settings")
+		}
+	}
+	if hb.KeyspacesVersion > k.keyspaces.Version() {
+		if err := k.syncKeyspaces(); err != nil {
+			logrus.WithError(err).Warn("Failed to sync the keyspace catalog")
+		}
+	}
+
+	if hb.Rejoin != nil {
+		return k.Rejoin(*hb.Rejoin)
+	}
+
+	k.mu.Lock()
+	if hb.Epoch > k.state.Epoch {
+		k.state.Epoch = hb.Epoch
+	}
+	k.mu.Unlock()
+
+	return nil
+}
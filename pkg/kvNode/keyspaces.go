@@ -0,0 +1,195 @@
+package kvNode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/ratelimit"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrKeyspaceNotFound is returned for requests naming a keyspace the controller does not know.
+var ErrKeyspaceNotFound = errors.New("keyspace not found")
+
+// ErrQuotaExceeded is returned for writes that would take a keyspace past its key or byte quota.
+var ErrQuotaExceeded = errors.New("keyspace quota exceeded")
+
+// RateLimitError is returned for requests beyond the ops/sec quota of a keyspace.
+type RateLimitError struct {
+	Keyspace   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("keyspace %q is over its ops/sec quota, retry after %s", e.Keyspace, e.RetryAfter)
+}
+
+// keyspaceCatalog is the node's copy of the controller's keyspaces and their
+// quotas. The default keyspace is known before the first sync.
+type keyspaceCatalog struct {
+	mu      sync.RWMutex
+	version int64
+	quotas  map[string]api.KeyspaceQuota
+	limits  map[string]*ratelimit.Bucket
+	// remote is what each keyspace stores in the other shards
+	remote map[string]api.KeyspaceUsage
+}
+
+func newKeyspaceCatalog() *keyspaceCatalog {
+	return &keyspaceCatalog{
+		quotas: map[string]api.KeyspaceQuota{api.DefaultKeyspace: {}},
+		limits: make(map[string]*ratelimit.Bucket),
+		remote: make(map[string]api.KeyspaceUsage),
+	}
+}
+
+// Version returns the version of the catalog held.
+func (c *keyspaceCatalog) Version() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
+
+// apply replaces the catalog with a newer one and returns the keyspaces that
+// were dropped from it.
+func (c *keyspaceCatalog) apply(catalog api.KeyspaceCatalog) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if catalog.Version <= c.version {
+		return nil, false
+	}
+	quotas := make(map[string]api.KeyspaceQuota, len(catalog.Keyspaces))
+	for _, keyspace := range catalog.Keyspaces {
+		quotas[keyspace.Name] = keyspace.Quota
+		if limit, ok := c.limits[keyspace.Name]; ok {
+			limit.SetRate(keyspace.Quota.MaxOpsPerSec, 0)
+		} else {
+			c.limits[keyspace.Name] = ratelimit.NewBucket(keyspace.Quota.MaxOpsPerSec, 0)
+		}
+	}
+
+	var dropped []string
+	for name := range c.quotas {
+		if _, ok := quotas[name]; !ok {
+			dropped = append(dropped, name)
+			delete(c.limits, name)
+		}
+	}
+	c.version = catalog.Version
+	c.quotas = quotas
+	return dropped, true
+}
+
+// setRemoteUsage records the usage of the other shards reported by the controller.
+func (c *keyspaceCatalog) setRemoteUsage(remote map[string]api.KeyspaceUsage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if remote == nil {
+		remote = make(map[string]api.KeyspaceUsage)
+	}
+	c.remote = remote
+}
+
+// admit resolves the keyspace of a request, an empty name is the default
+// keyspace, and charges the request to its ops/sec quota.
+func (c *keyspaceCatalog) admit(keyspace string) (string, error) {
+	if keyspace == "" {
+		keyspace = api.DefaultKeyspace
+	}
+	c.mu.RLock()
+	_, ok := c.quotas[keyspace]
+	limit := c.limits[keyspace]
+	c.mu.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrKeyspaceNotFound, keyspace)
+	}
+	if limit != nil {
+		if allowed, retryAfter := limit.Allow(); !allowed {
+			return "", &RateLimitError{Keyspace: keyspace, RetryAfter: retryAfter}
+		}
+	}
+	return keyspace, nil
+}
+
+// checkQuota fails when writing value to key would take the keyspace past its
+// key or byte quota. Usage in other shards is as of the last heartbeat, so the
+// cluster may briefly overshoot a quota under concurrent writes.
+func (c *keyspaceCatalog) checkQuota(store *Storage, keyspace, key, value string) error {
+	c.mu.RLock()
+	quota := c.quotas[keyspace]
+	remote := c.remote[keyspace]
+	c.mu.RUnlock()
+
+	if quota.MaxKeys <= 0 && quota.MaxBytes <= 0 {
+		return nil
+	}
+	usage := store.Usage(keyspace)
+	keys := remote.Keys + usage.Keys
+	bytes := remote.Bytes + usage.Bytes
+	old, exists := store.Lookup(keyspace, key)
+	delta := int64(len(key) + len(value))
+	if exists {
+		delta -= int64(len(key) + len(old))
+	}
+
+	if !exists && quota.MaxKeys > 0 && keys+1 > quota.MaxKeys {
+		return fmt.Errorf("%w: keyspace %q already holds %d of %d keys", ErrQuotaExceeded, keyspace, keys, quota.MaxKeys)
+	}
+	if delta > 0 && quota.MaxBytes > 0 && bytes+delta > quota.MaxBytes {
+		return fmt.Errorf("%w: keyspace %q would hold %d of %d bytes", ErrQuotaExceeded, keyspace, bytes+delta, quota.MaxBytes)
+	}
+	return nil
+}
+
+// ApplyKeyspaces installs a newer keyspace catalog. The data of dropped
+// keyspaces is deleted, a master logs the drop so its followers delete it at
+// the same point of the WAL.
+func (k *Service) ApplyKeyspaces(catalog api.KeyspaceCatalog) {
+	dropped, changed := k.keyspaces.apply(catalog)
+	if !changed {
+		return
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+	for _, keyspace := range dropped {
+		k.store.DropKeyspace(keyspace)
+		if k.state.IsMaster && k.wal != nil {
+			k.wal.Append("DROP_KEYSPACE", keyspace, "", "")
+		}
+		logrus.WithField("keyspace", keyspace).Info("Dropped keyspace")
+	}
+	logrus.WithFields(logrus.Fields{
+		"version":   catalog.Version,
+		"keyspaces": len(catalog.Keyspaces),
+	}).Info("Applied keyspace catalog")
+}
+
+// syncKeyspaces fetches the keyspace catalog from the controller.
+func (k *Service) syncKeyspaces() error {
+	resp, err := k.httpClient().Get(fmt.Sprintf("http://%s:%d/internal/keyspaces", k.config.Controller.Host, k.config.Controller.Port))
+	if err != nil {
+		return fmt.Errorf("failed to fetch keyspaces: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch keyspaces: status %d", resp.StatusCode)
+	}
+
+	var catalog api.KeyspaceCatalog
+	if err := json.NewDecoder(resp.Body).Decode(&catalog); err != nil {
+		return fmt.Errorf("failed to decode keyspaces: %v", err)
+	}
+	k.ApplyKeyspaces(catalog)
+	return nil
+}
@@ -6,64 +6,133 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Amirali-Amirifar/kv/internal/config"
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrDecommissioned is returned for client operations once the node has been decommissioned.
+var ErrDecommissioned = errors.New("node is decommissioned")
+
+// ErrWALTruncated is returned when a follower asks for WAL records that have already been trimmed.
+var ErrWALTruncated = errors.New("requested WAL records were already trimmed")
+
+// ErrNotMaster is returned for operations only the master of a shard may perform.
+var ErrNotMaster = errors.New("node is not the master of its shard")
+
+// ErrFenced is returned for writes while the node hands its leadership over.
+var ErrFenced = errors.New("node is handing over leadership")
+
+// ErrAlreadyLeader and ErrAlreadyFollower are returned for state updates to
+// the role the node already has, only their epoch is taken.
+var (
+	ErrAlreadyLeader   = errors.New("already a leader")
+	ErrAlreadyFollower = errors.New("already a follower")
+)
+
 type Service struct {
 	config *config.KvNodeConfig
 	state  NodeState
 	store  *Storage
 	wal    *WAL
 	mu     sync.RWMutex
-	client *http.Client
+	// writeMu orders the writes of a master, which share the read lock, so
+	// they change the store in the order of the WAL its followers replay
+	writeMu sync.Mutex
+	// client is replaced as a whole when the runtime settings change its timeout
+	client atomic.Pointer[http.Client]
+	ops    *rateMeter
+	keys   *keySketch
+	// keyspaces is the catalog of keyspaces and quotas synced from the controller
+	keyspaces *keyspaceCatalog
+	// settingsVersion is the version of the runtime settings applied,
+	// heartbeatInterval the interval they set. settingsMu serializes applying
+	// them so an older version never replaces a newer one
+	settingsMu        sync.Mutex
+	settingsVersion   atomic.Int64
+	heartbeatInterval atomic.Int64
+	// view is the latest topology, it tells which keys the node redirects.
+	// migrations are the ranges being handed over to other shards
+	view       atomic.Pointer[clusterView]
+	migrations []migration
+	// imports are the ranges being handed over to this node's shard
+	imports []partition.Range
 }
 
 func NewKvNodeService(cfg *config.KvNodeConfig) *Service {
 	timeout := time.Duration(cfg.HTTPTimeout) * time.Millisecond
-	client := &http.Client{Timeout: timeout}
-
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
 	svc := &Service{
 		config: cfg,
 		state: NodeState{
 			IsMaster: false,
 			ShardKey: 0,
 		},
-		store:  NewNodeStore(),
-		mu:     sync.RWMutex{},
-		client: client,
+		store: NewNodeStore(),
+		mu:    sync.RWMutex{},
+		ops:   newRateMeter(),
+		keys:  newKeySketch(cfg.HotKeys.Capacity, cfg.HotKeys.SampleRate),
+
+		keyspaces: newKeyspaceCatalog(),
 	}
 
 	if svc.state.IsMaster {
-		svc.wal = NewWAL(svc.state.ShardKey)
+		svc.wal = NewWAL(svc.state.ShardKey, 0)
 	}
+	svc.client.Store(&http.Client{Timeout: timeout})
+	svc.heartbeatInterval.Store(int64(time.Duration(cfg.HeartbeatIntervalMs) * time.Millisecond))
 
 	return svc
 }
 
+// httpClient returns the client for requests to the controller and other nodes.
+func (k *Service) httpClient() *http.Client {
+	return k.client.Load()
+}
+
 func (k *Service) Start() error {
 	// Register with controller
 	if err := k.RegisterWithController(); err != nil {
 		return err
 	}
+	if err := k.syncKeyspaces(); err != nil {
+		logrus.WithError(err).Warn("Failed to fetch the keyspace catalog, only the default keyspace is served")
+	}
+	if err := k.syncSettings(); err != nil {
+		logrus.WithError(err).Warn("Failed to fetch runtime settings, running with the config file")
+	}
 	// Start WAL
 	go k.syncWALPeriodically()
+	go k.watchTopology()
+	go k.heartbeatLoop()
 	return nil
 }
 
 func (k *Service) RegisterWithController() error {
 	// Register with controller
 	registerReq := struct {
-		Ip   string `json:"ip"`
-		Port int    `json:"port"`
+		Ip       string           `json:"ip"`
+		Port     int              `json:"port"`
+		Topology cluster.Topology `json:"topology"`
 	}{
 		Ip:   k.config.Address.Host,
 		Port: k.config.Address.Port,
+		Topology: cluster.Topology{
+			Zone: k.config.Topology.Zone,
+			Rack: k.config.Topology.Rack,
+			Host: k.config.Topology.Host,
+		},
 	}
 
 	body, err := json.Marshal(registerReq)
@@ -71,7 +140,7 @@ func (k *Service) RegisterWithController() error {
 		return fmt.Errorf("failed to marshal register request: %v", err)
 	}
 
-	resp, err := k.client.Post(
+	resp, err := k.httpClient().Post(
 		fmt.Sprintf("http://%s:%d/internal/nodes/register", k.config.Controller.Host, k.config.Controller.Port),
 		"application/json",
 		bytes.NewBuffer(body),
@@ -91,6 +160,7 @@ func (k *Service) RegisterWithController() error {
 		Status        cluster.NodeStatus    `json:"status"`
 		StoreNodeType cluster.StoreNodeType `json:"store_node_type"`
 		LeaderID      int                   `json:"leader_id"`
+		Epoch         int64                 `json:"epoch"`
 		LeaderAddress struct {
 			IP   string `json:"ip"`
 			Port int    `json:"port"`
@@ -102,13 +172,19 @@ func (k *Service) RegisterWithController() error {
 	}
 
 	// Update node state
+	k.mu.Lock()
+	defer k.mu.Unlock()
 	k.state.NodeID = nodeInfo.ID
 	k.state.ShardKey = nodeInfo.ShardKey
 	k.state.LeaderID = nodeInfo.LeaderID
+	k.state.Epoch = nodeInfo.Epoch
 
 	// Update node type
 	if nodeInfo.StoreNodeType == cluster.NodeTypeMaster {
 		k.state.IsMaster = true
+		if k.wal == nil {
+			k.wal = NewWAL(k.state.ShardKey, k.state.LastWALSeq)
+		}
 	} else {
 		k.state.IsMaster = false
 		k.state.MasterAddress = nodeInfo.LeaderAddress.IP
@@ -118,35 +194,107 @@ func (k *Service) RegisterWithController() error {
 	return nil
 }
 
-func (k *Service) Get(key string) (string, error) {
-	value, ok := k.store.Get(key)
+func (k *Service) Get(keyspace, key string) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.state.Decommissioned {
+		return "", ErrDecommissioned
+	}
+	keyspace, err := k.keyspaces.admit(keyspace)
+	if err != nil {
+		return "", err
+	}
+	k.ops.Mark()
+	k.keys.Mark(key)
+	value, ok := k.store.Get(keyspace, key)
 	if !ok {
 		return "", errors.New("not found")
 	}
 	return value, nil
 }
 
-func (k *Service) Set(key, value string) error {
-	k.store.Set(key, value)
+func (k *Service) Set(keyspace, key, value string) error {
+	// Fence waits for the writes holding the read lock, none is in flight
+	// once it returns
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.state.Decommissioned {
+		return ErrDecommissioned
+	}
+	if !k.state.IsMaster {
+		return k.masterRedirect()
+	}
+	if k.state.Fenced {
+		return ErrFenced
+	}
+	if err := k.checkMigration(key); err != nil {
+		return err
+	}
+	keyspace, err := k.keyspaces.admit(keyspace)
+	if err != nil {
+		return err
+	}
+	// Concurrent writes could each fit the quota and exceed it together
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+	if err := k.keyspaces.checkQuota(k.store, keyspace, key, value); err != nil {
+		return err
+	}
+	k.ops.Mark()
+	k.keys.Mark(key)
+	return k.set(keyspace, key, value)
+}
+
+// set writes a key through the WAL without checking quotas. Must be called
+// with writeMu held.
+func (k *Service) set(keyspace, key, value string) error {
+	if k.state.IsMaster && k.wal == nil {
+		return fmt.Errorf("WAL is nil.")
+	}
+	k.store.Set(keyspace, key, value)
 	if k.state.IsMaster {
-		if k.wal != nil {
-			k.wal.Append("SET", key, value)
-		} else {
-			// todo WTH?
-			return nil
-		}
+		k.wal.Append("SET", keyspace, key, value)
 	}
 	return nil
 }
 
-func (k *Service) Del(key string) error {
-	k.store.Delete(key)
+func (k *Service) Del(keyspace, key string) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.state.Decommissioned {
+		return ErrDecommissioned
+	}
+	if !k.state.IsMaster {
+		return k.masterRedirect()
+	}
+	if k.state.Fenced {
+		return ErrFenced
+	}
+	if err := k.checkMigration(key); err != nil {
+		return err
+	}
+	keyspace, err := k.keyspaces.admit(keyspace)
+	if err != nil {
+		return err
+	}
+	k.ops.Mark()
+	k.keys.Mark(key)
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+	return k.del(keyspace, key)
+}
+
+// del deletes a key through the WAL. Must be called with writeMu held.
+func (k *Service) del(keyspace, key string) error {
+	if k.state.IsMaster && k.wal == nil {
+		return fmt.Errorf("WAL is nil.")
+	}
+	k.store.Delete(keyspace, key)
 	if k.state.IsMaster {
-		if k.wal != nil {
-			k.wal.Append("DELETE", key, "")
-		} else {
-			return fmt.Errorf("WAL is nil.")
-		}
+		k.wal.Append("DELETE", keyspace, key, "")
 	}
 	return nil
 }
@@ -156,58 +304,102 @@ func (k *Service) GetLastSeq() int64 {
 	defer k.mu.RUnlock()
 
 	if k.wal == nil {
-		return 0
+		return k.state.LastWALSeq
 	}
 	return k.wal.GetLastSeq()
 }
 
-func (k *Service) UpdateNodeState(state cluster.StoreNodeType, leaderID int) error {
+// Decommission stops the node from serving client requests and replicating.
+func (k *Service) Decommission() {
 	k.mu.Lock()
 	defer k.mu.Unlock()
 
-	if state == cluster.NodeTypeMaster {
+	k.state.Decommissioned = true
+	logrus.WithFields(logrus.Fields{
+		"nodeID":   k.state.NodeID,
+		"shardKey": k.state.ShardKey,
+	}).Info("Node decommissioned")
+}
+
+// Fence stops the master from accepting writes while the controller moves
+// its leadership to another node, or lets it accept them again when the move
+// is called off. It returns the last WAL sequence, no write is in flight once
+// it returns so the sequence is final while the node stays fenced.
+func (k *Service) Fence(fenced bool) (int64, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if fenced && !k.state.IsMaster {
+		return 0, ErrNotMaster
+	}
+	k.state.Fenced = fenced
+
+	lastSeq := k.state.LastWALSeq
+	if k.wal != nil {
+		lastSeq = k.wal.GetLastSeq()
+	}
+	logrus.WithFields(logrus.Fields{
+		"fenced":  fenced,
+		"lastSeq": lastSeq,
+	}).Info("Node write fence changed")
+	return lastSeq, nil
+}
+
+// forkFrom keeps the local data up to forkSeq, the last record this node
+// shares with the current master. A node holding records past it has writes
+// the master never saw and starts over from the master's data. Must be called
+// with the lock held.
+func (k *Service) forkFrom(lastSeq, forkSeq int64) {
+	k.state.LastWALSeq = lastSeq
+	if lastSeq > forkSeq {
+		logrus.WithFields(logrus.Fields{
+			"lastSeq": lastSeq,
+			"forkSeq": forkSeq,
+		}).Warn("Discarding WAL records never acknowledged by the current master")
+		k.store.Restore(nil)
+		k.state.LastWALSeq = 0
+	}
+}
+
+func (k *Service) UpdateNodeState(update api.StateUpdate) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if update.Epoch < k.state.Epoch {
+		return fmt.Errorf("stale epoch %d, current epoch is %d", update.Epoch, k.state.Epoch)
+	}
+	k.state.Epoch = update.Epoch
+	k.state.Fenced = false
+
+	if update.State == cluster.NodeTypeMaster {
 		if k.state.IsMaster {
-			return errors.New("already a leader")
+			return ErrAlreadyLeader
 		}
 		if k.wal == nil {
-			k.wal = NewWAL(k.state.ShardKey)
+			k.wal = NewWAL(k.state.ShardKey, k.state.LastWALSeq)
 		}
 		k.state.IsMaster = true
+		k.state.LeaderID = k.state.NodeID
 
 		logrus.WithFields(logrus.Fields{
 			"shardKey": k.state.ShardKey,
 		}).Info("Node became leader")
-	} else if state == cluster.NodeTypeFollower {
-		if !k.state.IsMaster {
-			return errors.New("already a follower")
-		}
-
-		k.state.IsMaster = false
-		k.state.LeaderID = leaderID
-		resp, err := k.client.Get(fmt.Sprintf("http://%s:%d/node/%d", k.config.Controller.Host, k.config.Controller.Port, leaderID))
-		if err != nil {
-			return fmt.Errorf("failed to get master node info: %v", err)
+	} else if update.State == cluster.NodeTypeFollower {
+		if !k.state.IsMaster && k.state.LeaderID == update.LeaderID {
+			return ErrAlreadyFollower
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed to get master node info: status %d", resp.StatusCode)
-		}
-
-		var nodeInfo struct {
-			Address struct {
-				IP   string `json:"ip"`
-				Port int    `json:"port"`
-			} `json:"address"`
+		lastSeq := k.state.LastWALSeq
+		if k.wal != nil {
+			lastSeq = k.wal.GetLastSeq()
+			k.wal = nil
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&nodeInfo); err != nil {
-			return fmt.Errorf("failed to decode master node info: %v", err)
+		k.state.IsMaster = false
+		k.forkFrom(lastSeq, update.ForkSeq)
+		if err := k.followLeader(update.LeaderID, update.LeaderAddress); err != nil {
+			return err
 		}
 
-		// Update master address and port
-		k.state.MasterAddress = nodeInfo.Address.IP
-		k.state.MasterPort = nodeInfo.Address.Port
-
 		logrus.WithFields(logrus.Fields{
 			"shardKey": k.state.ShardKey,
 			"leaderID": k.state.LeaderID,
@@ -218,25 +410,273 @@ func (k *Service) UpdateNodeState(state cluster.StoreNodeType, leaderID int) err
 	return nil
 }
 
-func (k *Service) GetWALSince(seq int64) []WALRecord {
+// Rejoin brings the node back into its shard after the controller declared it
+// failed. Records past the fork sequence were never acknowledged by the
+// current master, a node holding them starts over from the master's data.
+func (k *Service) Rejoin(info api.RejoinInfo) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	lastSeq := k.state.LastWALSeq
+	if k.wal != nil {
+		lastSeq = k.wal.GetLastSeq()
+	}
+	k.state.Epoch = info.Epoch
+	k.state.Fenced = false
+
+	switch info.Role {
+	case cluster.NodeTypeMaster:
+		if k.wal == nil {
+			k.wal = NewWAL(k.state.ShardKey, lastSeq)
+		}
+		k.state.IsMaster = true
+		k.state.LeaderID = k.state.NodeID
+		logrus.WithField("epoch", info.Epoch).Info("Node rejoined as leader")
+		return nil
+	case cluster.NodeTypeFollower:
+	default:
+		// Returned to the spare pool, nothing to keep
+		k.wal = nil
+		k.store.Restore(nil)
+		k.state.IsMaster = false
+		k.state.LastWALSeq = 0
+		k.state.ShardKey = -1
+		k.state.LeaderID = -1
+		k.state.MasterAddress = ""
+		k.state.MasterPort = 0
+		logrus.Info("Node rejoined the spare pool")
+		return nil
+	}
+
+	k.wal = nil
+	k.state.IsMaster = false
+	k.forkFrom(lastSeq, info.ForkSeq)
+	if err := k.followLeader(info.LeaderID, info.LeaderAddress); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"shardKey": k.state.ShardKey,
+		"leaderID": k.state.LeaderID,
+		"epoch":    info.Epoch,
+	}).Info("Node rejoined as follower")
+	return nil
+}
+
+// followLeader replicates from the leader at address from now on. The
+// controller sends the address along with the role, so the node never calls
+// back into it while it waits on the answer. Must be called with the lock held.
+func (k *Service) followLeader(leaderID int, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid leader address %q: %v", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid leader port %q: %v", portStr, err)
+	}
+
+	k.state.LeaderID = leaderID
+	k.state.MasterAddress = host
+	k.state.MasterPort = port
+	return nil
+}
+
+// AssignShard moves the node to another shard, dropping all local data. A
+// negative shardKey returns the node to the spare pool.
+func (k *Service) AssignShard(assignment api.ShardAssignment) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	shardKey := assignment.ShardKey
+
+	k.store.Restore(nil)
+	k.wal = nil
+	k.migrations = nil
+	k.imports = nil
+	k.state.Fenced = false
+	k.state.ShardKey = shardKey
+	k.state.IsMaster = false
+	k.state.LastWALSeq = 0
+	k.state.LeaderID = -1
+	k.state.MasterAddress = ""
+	k.state.MasterPort = 0
+	k.state.Epoch = 0
+
+	switch {
+	case shardKey < 0:
+		logrus.Info("Node returned to the spare pool")
+		return nil
+	case assignment.State == cluster.NodeTypeMaster:
+		k.wal = NewWAL(shardKey, 0)
+		k.state.IsMaster = true
+		k.state.LeaderID = k.state.NodeID
+	default:
+		if err := k.followLeader(assignment.LeaderID, assignment.LeaderAddress); err != nil {
+			return err
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"shardKey": shardKey,
+		"state":    assignment.State,
+		"leaderID": k.state.LeaderID,
+	}).Info("Node assigned to shard")
+	return nil
+}
+
+// Stats reports the size and request rate of the local copy of the shard.
+func (k *Service) Stats() api.NodeStats {
+	keys, bytes := k.store.Size()
+	return api.NodeStats{
+		Keys:      keys,
+		Bytes:     bytes,
+		OpsPerSec: k.ops.Rate(),
+		HotKeys:   k.keys.Top(hotKeysReported),
+		Keyspaces: k.store.Keyspaces(),
+	}
+}
+
+// MedianKey returns the median key of r and how many keys r holds.
+func (k *Service) MedianKey(r partition.Range) (string, int) {
+	return k.store.MedianKey(r)
+}
+
+// ExportRange returns every key-value pair inside r by keyspace.
+func (k *Service) ExportRange(r partition.Range) map[string]map[string]string {
+	return k.store.ExportRange(r)
+}
+
+// ImportRange writes the pairs through the WAL so followers receive them too.
+// The keys only move between shards, so quotas are not checked.
+func (k *Service) ImportRange(data map[string]map[string]string) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if !k.state.IsMaster {
+		return ErrNotMaster
+	}
+	if k.state.Fenced {
+		return ErrFenced
+	}
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+	for keyspace, keys := range data {
+		for key, value := range keys {
+			if err := k.set(keyspace, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DropRange deletes every key inside r through the WAL.
+func (k *Service) DropRange(r partition.Range) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if !k.state.IsMaster {
+		return ErrNotMaster
+	}
+	if k.state.Fenced {
+		return ErrFenced
+	}
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+	for keyspace, keys := range k.store.ExportRange(r) {
+		for key := range keys {
+			if err := k.del(keyspace, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (k *Service) GetWALSince(seq int64) ([]WALRecord, error) {
 	k.mu.RLock()
 	defer k.mu.RUnlock()
 
 	if k.wal == nil {
-		return nil
+		return nil, nil
+	}
+	if !k.wal.Covers(seq) {
+		return nil, ErrWALTruncated
+	}
+	return k.wal.GetSince(seq), nil
+}
+
+// Snapshot returns the current store content together with the WAL sequence it reflects.
+func (k *Service) Snapshot() Snapshot {
+	// Read the sequence first, records appended while copying are replayed on top.
+	seq := k.GetLastSeq()
+	return Snapshot{
+		Seq:  seq,
+		Data: k.store.Snapshot(),
+	}
+}
+
+// restoreSnapshot replaces the local store with a snapshot of master, unless
+// the node stopped following it meanwhile.
+func (k *Service) restoreSnapshot(master string) {
+	resp, err := k.httpClient().Get(fmt.Sprintf("http://%s/snapshot", master))
+	if err != nil {
+		logrus.WithError(err).WithField("master", master).Error("Failed to fetch snapshot from master")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logrus.WithFields(logrus.Fields{
+			"status": resp.StatusCode,
+			"master": master,
+		}).Error("Failed to fetch snapshot from master")
+		return
 	}
-	return k.wal.GetSince(seq)
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		logrus.WithError(err).WithField("master", master).Error("Failed to decode snapshot")
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.follows(master) {
+		logrus.WithField("master", master).Warn("Dropping snapshot from a previous master")
+		return
+	}
+	k.store.Restore(snapshot.Data)
+	k.state.LastWALSeq = snapshot.Seq
+
+	logrus.WithFields(logrus.Fields{
+		"master": master,
+		"seq":    snapshot.Seq,
+		"keys":   len(snapshot.Data),
+	}).Info("Restored snapshot from master")
 }
 
 func (k *Service) ApplyWALRecord(record WALRecord) error {
 	k.mu.Lock()
 	defer k.mu.Unlock()
+	return k.applyWALRecord(record)
+}
 
+// applyWALRecord must be called with the lock held.
+func (k *Service) applyWALRecord(record WALRecord) error {
+	// Records written before keyspaces existed belong to the default keyspace
+	keyspace := record.Keyspace
+	if keyspace == "" {
+		keyspace = api.DefaultKeyspace
+	}
 	switch record.Operation {
 	case "SET":
-		k.store.Set(record.Key, record.Value)
+		k.store.Set(keyspace, record.Key, record.Value)
 	case "DELETE":
-		k.store.Delete(record.Key)
+		k.store.Delete(keyspace, record.Key)
+	case "DROP_KEYSPACE":
+		k.store.DropKeyspace(keyspace)
 	default:
 		return errors.New("unknown operation in WAL record")
 	}
@@ -249,93 +689,161 @@ func (k *Service) syncWALPeriodically() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		if k.state.IsMaster {
-			if k.wal != nil {
-				minSeq := k.wal.GetMinFollowerSeq()
+		k.ops.tick()
+		k.keys.tick()
+
+		k.mu.RLock()
+		state, wal := k.state, k.wal
+		k.mu.RUnlock()
+		if state.Decommissioned {
+			return
+		}
+		if state.IsMaster {
+			if wal != nil {
+				minSeq := wal.GetMinFollowerSeq()
 				if minSeq > 0 {
-					k.wal.ClearUntil(minSeq)
+					wal.ClearUntil(minSeq)
 				}
 			}
-			return
-		}
-
-		// Get WAL entries from master
-		resp, err := k.client.Get(fmt.Sprintf("http://%s:%d/wal/get-since/?since=%d", k.state.MasterAddress, k.state.MasterPort, k.state.LastWALSeq))
-		if err != nil {
-			logrus.WithError(err).WithFields(logrus.Fields{
-				"master": fmt.Sprintf("%s:%d", k.state.MasterAddress, k.state.MasterPort),
-				"seq":    k.state.LastWALSeq,
-			}).Error("Failed to fetch WAL entries from master")
 			continue
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			logrus.WithFields(logrus.Fields{
-				"status": resp.StatusCode,
-				"master": fmt.Sprintf("%s:%d", k.state.MasterAddress, k.state.MasterPort),
-				"seq":    k.state.LastWALSeq,
-			}).Error("Failed to fetch WAL entries from master")
+		if state.ShardKey < 0 || state.MasterAddress == "" {
+			// Spare nodes have nothing to replicate
 			continue
 		}
+		k.pullWAL(state)
+	}
+}
 
-		var records []WALRecord
-		if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
-			logrus.WithError(err).WithFields(logrus.Fields{
-				"master": fmt.Sprintf("%s:%d", k.state.MasterAddress, k.state.MasterPort),
-				"seq":    k.state.LastWALSeq,
-			}).Error("Failed to decode WAL entries")
-			continue
-		}
+// currentState returns a copy of the node's state.
+func (k *Service) currentState() NodeState {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.state
+}
 
-		if len(records) == 0 {
-			continue
-		}
+// errMasterChanged is returned for WAL records pulled from a master the node
+// no longer follows.
+var errMasterChanged = errors.New("node no longer follows the master")
 
-		sort.Slice(records, func(i, j int) bool {
-			return records[i].Seq < records[j].Seq
-		})
+// follows reports whether the node still replicates from master. Must be
+// called with the lock held.
+func (k *Service) follows(master string) bool {
+	return !k.state.IsMaster && fmt.Sprintf("%s:%d", k.state.MasterAddress, k.state.MasterPort) == master
+}
 
-		for _, record := range records {
-			if record.Seq <= k.state.LastWALSeq {
-				continue
-			}
+// applyFrom applies a record pulled from master, it reports false for records
+// applied before.
+func (k *Service) applyFrom(master string, record WALRecord) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
 
-			if err := k.ApplyWALRecord(record); err != nil {
-				logrus.WithError(err).WithFields(logrus.Fields{
-					"seq":       record.Seq,
-					"operation": record.Operation,
-					"key":       record.Key,
-				}).Error("Failed to apply WAL record")
-				break
-			}
+	if !k.follows(master) {
+		return false, errMasterChanged
+	}
+	if record.Seq <= k.state.LastWALSeq {
+		return false, nil
+	}
+	if err := k.applyWALRecord(record); err != nil {
+		return false, err
+	}
+	k.state.LastWALSeq = record.Seq
+	return true, nil
+}
 
-			k.state.LastWALSeq = record.Seq
-
-			// Notify master about our progress
-			progressResp, err := k.client.Post(
-				fmt.Sprintf("http://%s:%d/wal/progress", k.state.MasterAddress, k.state.MasterPort),
-				"application/json",
-				bytes.NewBufferString(fmt.Sprintf(`{"follower_id": %d, "seq": %d}`, k.state.NodeID, record.Seq)),
-			)
-			if err != nil {
-				logrus.WithError(err).WithFields(logrus.Fields{
-					"seq": record.Seq,
-				}).Error("Failed to notify master about WAL progress")
-				continue
-			}
-			progressResp.Body.Close()
+// pullWAL fetches and applies the records the master has appended since our
+// last applied sequence. state is the node's state when the pull started.
+func (k *Service) pullWAL(state NodeState) {
+	master := fmt.Sprintf("%s:%d", state.MasterAddress, state.MasterPort)
 
-			logrus.WithFields(logrus.Fields{
+	// Get WAL entries from master
+	resp, err := k.httpClient().Get(fmt.Sprintf("http://%s/wal/get-since/?since=%d", master, state.LastWALSeq))
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"master": master,
+			"seq":    state.LastWALSeq,
+		}).Error("Failed to fetch WAL entries from master")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		// The master has already trimmed the records we need, start over from its snapshot.
+		k.restoreSnapshot(master)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		logrus.WithFields(logrus.Fields{
+			"status": resp.StatusCode,
+			"master": master,
+			"seq":    state.LastWALSeq,
+		}).Error("Failed to fetch WAL entries from master")
+		return
+	}
+
+	var records []WALRecord
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"master": master,
+			"seq":    state.LastWALSeq,
+		}).Error("Failed to decode WAL entries")
+		return
+	}
+
+	if len(records) == 0 {
+		return
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Seq < records[j].Seq
+	})
+
+	for _, record := range records {
+		applied, err := k.applyFrom(master, record)
+		if errors.Is(err, errMasterChanged) {
+			// Leadership changed while the request was in flight, these records
+			// come from a master that is no longer ours
+			logrus.WithField("master", master).Warn("Dropping WAL records from a previous master")
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
 				"seq":       record.Seq,
 				"operation": record.Operation,
 				"key":       record.Key,
-			}).Debug("Applied WAL record")
+			}).Error("Failed to apply WAL record")
+			break
+		}
+		if !applied {
+			continue
+		}
+
+		// Notify master about our progress
+		progressResp, err := k.httpClient().Post(
+			fmt.Sprintf("http://%s/wal/progress", master),
+			"application/json",
+			bytes.NewBufferString(fmt.Sprintf(`{"follower_id": %d, "seq": %d}`, state.NodeID, record.Seq)),
+		)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"seq": record.Seq,
+			}).Error("Failed to notify master about WAL progress")
+			continue
 		}
+		progressResp.Body.Close()
+
+		logrus.WithFields(logrus.Fields{
+			"seq":       record.Seq,
+			"operation": record.Operation,
+			"key":       record.Key,
+		}).Debug("Applied WAL record")
 	}
 }
 
 func (k *Service) UpdateFollowerProgress(followerID int, seq int64) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
 	if k.wal != nil {
 		k.wal.UpdateFollowerProgress(followerID, seq)
 	}
@@ -0,0 +1,344 @@
+package kvNode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/Amirali-Amirifar/kv/internal/types/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+// topologyWatchTimeout is how long one long-poll for a newer topology lasts.
+const topologyWatchTimeout = 30 * time.Second
+
+// RedirectError is returned for keys another node serves.
+type RedirectError struct {
+	Redirect api.Redirect
+}
+
+func (e *RedirectError) Error() string {
+	// Reads like a Redis Cluster redirect, e.g. MOVED 3 10.0.0.5:8083
+	return fmt.Sprintf("%s %d %s", e.Redirect.Kind, e.Redirect.ShardKey, e.Redirect.Address)
+}
+
+// clusterView is the part of the topology a node needs to tell the keys it
+// owns from those it redirects.
+type clusterView struct {
+	incarnation string
+	version     int64
+	locator     partition.Locator
+	masters     map[int]*cluster.NodeInfo
+}
+
+// ErrRangeFrozen is returned for writes to a range while it is copied to another shard.
+var ErrRangeFrozen = errors.New("key range is being copied to another shard")
+
+// migration is a range of the node's shard being handed over to another
+// shard. A frozen range is still being copied, its writes are rejected.
+type migration struct {
+	r      partition.Range
+	target api.Redirect
+	frozen bool
+}
+
+func nodeAddress(node *cluster.NodeInfo) string {
+	return net.JoinHostPort(node.Address.IP.String(), fmt.Sprint(node.Address.Port))
+}
+
+// CheckOwner returns a RedirectError when key is served by another shard.
+// asking is set on requests following an ASK redirect, they are served when
+// the key's range is being imported here and routed like any other otherwise.
+// incarnation and version name the topology the client routed by, zero when
+// unknown. A node that knows no topology, or an older one of the same
+// incarnation than the client, serves every key. Versions of different
+// incarnations do not compare, the node then routes by its own view.
+func (k *Service) CheckOwner(key string, asking bool, incarnation string, version int64) error {
+	k.mu.RLock()
+	shardKey := k.state.ShardKey
+	if asking {
+		for _, r := range k.imports {
+			if r.Contains(key) {
+				k.mu.RUnlock()
+				return nil
+			}
+		}
+	}
+	for _, m := range k.migrations {
+		// Reads of a frozen range are still served here, writes are
+		// rejected by checkMigration
+		if m.r.Contains(key) && !m.frozen {
+			k.mu.RUnlock()
+			return &RedirectError{Redirect: m.target}
+		}
+	}
+	k.mu.RUnlock()
+
+	view := k.view.Load()
+	if view == nil || (view.incarnation == incarnation && view.version < version) {
+		return nil
+	}
+	owner, ok := view.locator.Locate(key)
+	if !ok || owner == shardKey {
+		return nil
+	}
+	redirect := api.Redirect{
+		Kind:            api.RedirectMoved,
+		ShardKey:        owner,
+		NodeID:          -1,
+		TopologyVersion: view.version,
+	}
+	if master := view.masters[owner]; master != nil {
+		redirect.NodeID = master.ID
+		redirect.Address = nodeAddress(master)
+	}
+	return &RedirectError{Redirect: redirect}
+}
+
+// masterRedirect sends a write that reached a follower to the master of its
+// shard with MOVED, the node does not know one when it is a spare or waits for
+// its role. Must be called with the lock held.
+func (k *Service) masterRedirect() error {
+	if k.state.ShardKey < 0 || k.state.MasterAddress == "" {
+		return ErrNotMaster
+	}
+	redirect := api.Redirect{
+		Kind:     api.RedirectMoved,
+		ShardKey: k.state.ShardKey,
+		NodeID:   k.state.LeaderID,
+		Address:  net.JoinHostPort(k.state.MasterAddress, strconv.Itoa(k.state.MasterPort)),
+	}
+	if view := k.view.Load(); view != nil {
+		redirect.TopologyVersion = view.version
+	}
+	return &RedirectError{Redirect: redirect}
+}
+
+// checkMigration rejects a write to a key whose range is being handed over.
+// It runs under the lock the write holds, so no write checked before a
+// migration was marked lands after it. Must be called with the lock held.
+func (k *Service) checkMigration(key string) error {
+	for _, m := range k.migrations {
+		if !m.r.Contains(key) {
+			continue
+		}
+		if m.frozen {
+			return ErrRangeFrozen
+		}
+		return &RedirectError{Redirect: m.target}
+	}
+	return nil
+}
+
+// MigrateRange freezes the writes to a range while it is copied to another
+// shard, then sends clients asking for its keys to that shard's master with
+// ASK until the topology assigns the range to it. On the receiving master it
+// records the range as imported, so the requests following those ASKs are
+// served. Cancelling the hand-over ends any of them.
+func (k *Service) MigrateRange(req api.RangeMigrationRequest) error {
+	r := partition.Range{Start: req.Start, End: req.End}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if !req.Cancel && !k.state.IsMaster {
+		return ErrNotMaster
+	}
+	if req.Import {
+		imports := make([]partition.Range, 0, len(k.imports)+1)
+		for _, imported := range k.imports {
+			if imported != r {
+				imports = append(imports, imported)
+			}
+		}
+		if !req.Cancel {
+			imports = append(imports, r)
+		}
+		k.imports = imports
+
+		logrus.WithFields(logrus.Fields{
+			"start":  r.Start,
+			"end":    r.End,
+			"from":   req.ShardKey,
+			"cancel": req.Cancel,
+		}).Info("Range import updated")
+		return nil
+	}
+	migrations := make([]migration, 0, len(k.migrations)+1)
+	for _, m := range k.migrations {
+		if m.r != r {
+			migrations = append(migrations, m)
+		}
+	}
+	view := k.view.Load()
+	owned := false
+	if view != nil && !req.Freeze {
+		// The topology already sends the keys there with MOVED
+		owner, ok := view.locator.Locate(r.Start)
+		owned = ok && owner == req.ShardKey
+	}
+	if !req.Cancel && !owned {
+		var version int64
+		if view != nil {
+			version = view.version
+		}
+		migrations = append(migrations, migration{
+			r: r,
+			target: api.Redirect{
+				Kind:            api.RedirectAsk,
+				ShardKey:        req.ShardKey,
+				NodeID:          req.NodeID,
+				Address:         req.Address,
+				TopologyVersion: version,
+			},
+			frozen: req.Freeze,
+		})
+	}
+	k.migrations = migrations
+
+	logrus.WithFields(logrus.Fields{
+		"start":  r.Start,
+		"end":    r.End,
+		"shard":  req.ShardKey,
+		"freeze": req.Freeze,
+		"cancel": req.Cancel,
+	}).Info("Range migration updated")
+	return nil
+}
+
+// watchTopology keeps the node's view of the topology in sync with the
+// controller, long-polling like the load balancers do.
+func (k *Service) watchTopology() {
+	// A long-poll legitimately takes up to topologyWatchTimeout
+	client := &http.Client{Timeout: topologyWatchTimeout + 10*time.Second}
+	for !k.currentState().Decommissioned {
+		var incarnation string
+		var version int64
+		if view := k.view.Load(); view != nil {
+			incarnation, version = view.incarnation, view.version
+		}
+		if err := k.watchTopologyOnce(client, incarnation, version); err != nil {
+			logrus.WithError(err).Warn("Topology watch failed")
+			time.Sleep(k.currentHeartbeatInterval())
+		}
+	}
+}
+
+// watchTopologyOnce long-polls the controller for a topology newer than
+// version, or for any topology of another incarnation.
+func (k *Service) watchTopologyOnce(client *http.Client, incarnation string, version int64) error {
+	resp, err := client.Get(fmt.Sprintf("http://%s:%d/internal/topology/watch?incarnation=%s&version=%d&timeout_ms=%d",
+		k.config.Controller.Host, k.config.Controller.Port, url.QueryEscape(incarnation), version, topologyWatchTimeout.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("received status code %d from topology watch", resp.StatusCode)
+	}
+
+	var topology api.Topology
+	if err := json.NewDecoder(resp.Body).Decode(&topology); err != nil {
+		return fmt.Errorf("failed to decode topology: %v", err)
+	}
+	k.applyTopology(topology)
+	return nil
+}
+
+// applyTopology replaces the node's view with a newer topology, or with any
+// topology of another controller incarnation, and ends the migrations and
+// imports the topology now records.
+func (k *Service) applyTopology(topology api.Topology) {
+	view := &clusterView{
+		incarnation: topology.Incarnation,
+		version:     topology.Version,
+		locator:     partition.NewLocator(topology.Partitions),
+		masters:     make(map[int]*cluster.NodeInfo, len(topology.Shards)),
+	}
+	for _, shard := range topology.Shards {
+		view.masters[shard.ShardKey] = shard.Master
+	}
+	for {
+		current := k.view.Load()
+		if current != nil && current.incarnation == view.incarnation && current.version >= view.version {
+			return
+		}
+		if k.view.CompareAndSwap(current, view) {
+			break
+		}
+	}
+
+	k.mu.Lock()
+	migrations := k.migrations[:0]
+	for _, m := range k.migrations {
+		// Clients are sent there with MOVED from now on
+		if owner, ok := view.locator.Locate(m.r.Start); ok && owner == m.target.ShardKey {
+			logrus.WithFields(logrus.Fields{
+				"start": m.r.Start,
+				"end":   m.r.End,
+				"shard": m.target.ShardKey,
+			}).Info("Range migration completed")
+			continue
+		}
+		migrations = append(migrations, m)
+	}
+	k.migrations = migrations
+	imports := k.imports[:0]
+	for _, r := range k.imports {
+		if owner, ok := view.locator.Locate(r.Start); ok && owner == k.state.ShardKey {
+			continue
+		}
+		imports = append(imports, r)
+	}
+	k.imports = imports
+	state, wal := k.state, k.wal
+	k.mu.Unlock()
+
+	if state.IsMaster && wal != nil {
+		k.forgetDepartedFollowers(wal, state, topology)
+	}
+
+	logrus.WithField("version", view.version).Debug("Applied topology")
+}
+
+// forgetDepartedFollowers stops waiting for the progress of followers that
+// left the shard or failed, they would keep the master from ever trimming its
+// WAL. A failed follower that comes back restores a snapshot if it fell behind
+// the trimmed log.
+func (k *Service) forgetDepartedFollowers(wal *WAL, state NodeState, topology api.Topology) {
+	for _, shard := range topology.Shards {
+		if shard.ShardKey != state.ShardKey {
+			continue
+		}
+		if shard.Master == nil || shard.Master.ID != state.NodeID {
+			// The topology is older than our promotion, or we were demoted
+			return
+		}
+		members := make(map[int]bool, len(shard.Followers))
+		for _, follower := range shard.Followers {
+			members[follower.ID] = follower.Status != cluster.NodeStatusFailed
+		}
+		for _, id := range wal.Followers() {
+			if !members[id] {
+				wal.RemoveFollower(id)
+				logrus.WithFields(logrus.Fields{
+					"shard":    state.ShardKey,
+					"follower": id,
+				}).Info("Follower is gone, no longer retaining WAL records for it")
+			}
+		}
+		return
+	}
+}
@@ -0,0 +1,83 @@
+package kvNode
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+	"github.com/sirupsen/logrus"
+)
+
+// ApplySettings switches the node to newer runtime settings. Settings that are
+// not set fall back to the node's config file.
+func (k *Service) ApplySettings(applied api.AppliedSettings) {
+	k.settingsMu.Lock()
+	defer k.settingsMu.Unlock()
+
+	if applied.Version <= k.settingsVersion.Load() {
+		return
+	}
+	s := applied.Settings
+
+	level := logrus.InfoLevel
+	if s.LogLevel != nil {
+		if parsed, err := logrus.ParseLevel(*s.LogLevel); err == nil {
+			level = parsed
+		}
+	}
+	logrus.SetLevel(level)
+
+	timeoutMs := k.config.HTTPTimeout
+	if s.HTTPTimeoutMs != nil {
+		timeoutMs = *s.HTTPTimeoutMs
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	// Requests in flight keep the timeout they started with
+	k.client.Store(&http.Client{Timeout: timeout})
+
+	intervalMs := k.config.HeartbeatIntervalMs
+	if s.HeartbeatIntervalMs != nil {
+		intervalMs = *s.HeartbeatIntervalMs
+	}
+	k.heartbeatInterval.Store(int64(time.Duration(intervalMs) * time.Millisecond))
+
+	sampleRate := k.config.HotKeys.SampleRate
+	if s.HotKeySampleRate != nil {
+		sampleRate = *s.HotKeySampleRate
+	}
+	k.keys.setSampleRate(sampleRate)
+
+	k.settingsVersion.Store(applied.Version)
+	logrus.WithFields(logrus.Fields{
+		"version":           applied.Version,
+		"logLevel":          level,
+		"httpTimeout":       timeout,
+		"heartbeatInterval": time.Duration(k.heartbeatInterval.Load()),
+		"hotKeySampleRate":  sampleRate,
+	}).Info("Applied runtime settings")
+}
+
+// syncSettings fetches the runtime settings in effect on this node from the controller.
+func (k *Service) syncSettings() error {
+	resp, err := k.httpClient().Get(fmt.Sprintf("http://%s:%d/internal/settings?node=%d", k.config.Controller.Host, k.config.Controller.Port, k.currentState().NodeID))
+	if err != nil {
+		return fmt.Errorf("failed to fetch settings: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch settings: status %d", resp.StatusCode)
+	}
+
+	var applied api.AppliedSettings
+	if err := json.NewDecoder(resp.Body).Decode(&applied); err != nil {
+		return fmt.Errorf("failed to decode settings: %v", err)
+	}
+	k.ApplySettings(applied)
+	return nil
+}
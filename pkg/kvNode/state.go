@@ -9,4 +9,11 @@ type NodeState struct {
 	MasterAddress string
 	MasterPort    int
 	NodeID        int
+	// Epoch is the leadership epoch of the shard, state updates from older epochs are ignored.
+	Epoch int64
+	// Decommissioned is set once the controller has retired this node, it no longer serves clients.
+	Decommissioned bool
+	// Fenced is set while the controller moves leadership off this node, it
+	// rejects writes until it learns its new role.
+	Fenced bool
 }
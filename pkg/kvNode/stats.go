@@ -0,0 +1,170 @@
+package kvNode
+
+import (
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
+)
+
+// rateMeter tracks a smoothed events-per-second rate. Mark records events and
+// tick folds them into the average, it is expected to be called about once a second.
+type rateMeter struct {
+	mu    sync.Mutex
+	count int64
+	last  time.Time
+	rate  float64
+}
+
+// rateSmoothing is the weight of the newest sample in the moving average.
+const rateSmoothing = 0.3
+
+func newRateMeter() *rateMeter {
+	return &rateMeter{last: time.Now()}
+}
+
+func (m *rateMeter) Mark() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count++
+}
+
+func (m *rateMeter) tick() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(m.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	sample := float64(m.count) / elapsed
+	m.rate = rateSmoothing*sample + (1-rateSmoothing)*m.rate
+	m.count = 0
+	m.last = now
+}
+
+func (m *rateMeter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rate
+}
+
+// keyDecay is the share of a hot-key count kept on every tick, a key requested
+// r times a second settles at a count of r / (1 - keyDecay).
+const keyDecay = 0.5
+
+// hotKeysReported is the number of hot keys a node reports to the controller.
+const hotKeysReported = 10
+
+// keyCounter is a Space-Saving counter. err bounds how much of count was
+// inherited from the key it evicted.
+type keyCounter struct {
+	count float64
+	err   float64
+}
+
+// keySketch finds the most requested keys in bounded space with the
+// Space-Saving algorithm. Only a sample of the requests is counted, and counts
+// decay on every tick so they follow the current rate rather than all-time totals.
+type keySketch struct {
+	mu         sync.Mutex
+	capacity   int
+	sampleRate float64
+	counters   map[string]*keyCounter
+}
+
+func newKeySketch(capacity int, sampleRate float64) *keySketch {
+	if capacity <= 0 {
+		capacity = 64
+	}
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+	return &keySketch{
+		capacity:   capacity,
+		sampleRate: sampleRate,
+		counters:   make(map[string]*keyCounter, capacity),
+	}
+}
+
+// setSampleRate changes the share of requests counted, out of range rates count all.
+func (s *keySketch) setSampleRate(sampleRate float64) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sampleRate = sampleRate
+}
+
+func (s *keySketch) Mark(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sampleRate < 1 && rand.Float64() >= s.sampleRate {
+		return
+	}
+	// Every sampled request stands for 1/sampleRate requests
+	weight := 1 / s.sampleRate
+
+	if c, ok := s.counters[key]; ok {
+		c.count += weight
+		return
+	}
+	if len(s.counters) < s.capacity {
+		s.counters[key] = &keyCounter{count: weight}
+		return
+	}
+
+	// Replace the smallest counter, the new key may have been counted there
+	var minKey string
+	var minCounter *keyCounter
+	for k, c := range s.counters {
+		if minCounter == nil || c.count < minCounter.count {
+			minKey, minCounter = k, c
+		}
+	}
+	delete(s.counters, minKey)
+	s.counters[key] = &keyCounter{count: minCounter.count + weight, err: minCounter.count}
+}
+
+func (s *keySketch) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, c := range s.counters {
+		c.count *= keyDecay
+		c.err *= keyDecay
+		if c.count < 0.01 {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// Top returns the n keys with the highest estimated request rate.
+func (s *keySketch) Top(n int) []api.KeyLoad {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]api.KeyLoad, 0, len(s.counters))
+	for key, c := range s.counters {
+		keys = append(keys, api.KeyLoad{
+			Key:       key,
+			OpsPerSec: c.count * (1 - keyDecay),
+			Error:     c.err * (1 - keyDecay),
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].OpsPerSec != keys[j].OpsPerSec {
+			return keys[i].OpsPerSec > keys[j].OpsPerSec
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
@@ -1,8 +1,11 @@
 package kvNode
 
 import (
+	"sort"
 	"sync"
 
+	"github.com/Amirali-Amirifar/kv/internal/partition"
+	"github.com/Amirali-Amirifar/kv/internal/types/api"
 	"github.com/sirupsen/logrus"
 )
 
@@ -10,35 +13,176 @@ var log = logrus.WithFields(logrus.Fields{
 	"Service": "Storage",
 })
 
+// Storage keeps the keys of every keyspace in a map of their own.
 type Storage struct {
-	data map[string]string
-	mu   *sync.RWMutex
+	data  map[string]map[string]string
+	bytes map[string]int64
+	mu    *sync.RWMutex
 }
 
 func NewNodeStore() *Storage {
 	return &Storage{
-		data: make(map[string]string),
-		mu:   &sync.RWMutex{},
+		data:  make(map[string]map[string]string),
+		bytes: make(map[string]int64),
+		mu:    &sync.RWMutex{},
 	}
 }
 
-func (s *Storage) Set(key, value string) {
+func (s *Storage) Set(keyspace, key, value string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[key] = value
+	s.set(keyspace, key, value)
 	log.Printf("%+v\n", s.data)
 }
-func (s *Storage) Get(key string) (string, bool) {
+
+// set must be called with the lock held.
+func (s *Storage) set(keyspace, key, value string) {
+	keys, ok := s.data[keyspace]
+	if !ok {
+		keys = make(map[string]string)
+		s.data[keyspace] = keys
+	}
+	if old, ok := keys[key]; ok {
+		s.bytes[keyspace] -= int64(len(key) + len(old))
+	}
+	keys[key] = value
+	s.bytes[keyspace] += int64(len(key) + len(value))
+}
+
+func (s *Storage) Get(keyspace, key string) (string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	val, ok := s.data[key]
+	val, ok := s.data[keyspace][key]
 	log.Printf("%+v\n", s.data)
 	return val, ok
 }
 
-func (s *Storage) Delete(key string) {
+func (s *Storage) Delete(keyspace, key string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.data, key)
+	keys := s.data[keyspace]
+	if old, ok := keys[key]; ok {
+		delete(keys, key)
+		s.bytes[keyspace] -= int64(len(key) + len(old))
+	}
+	if len(keys) == 0 {
+		delete(s.data, keyspace)
+		delete(s.bytes, keyspace)
+	}
 	log.Printf("%+v\n", s.data)
 }
+
+// DropKeyspace deletes every key of a keyspace.
+func (s *Storage) DropKeyspace(keyspace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, keyspace)
+	delete(s.bytes, keyspace)
+}
+
+// Snapshot returns a copy of every key-value pair in the store by keyspace.
+func (s *Storage) Snapshot() map[string]map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := make(map[string]map[string]string, len(s.data))
+	for keyspace, keys := range s.data {
+		data[keyspace] = make(map[string]string, len(keys))
+		for k, v := range keys {
+			data[keyspace][k] = v
+		}
+	}
+	return data
+}
+
+// Restore replaces the whole content of the store.
+func (s *Storage) Restore(data map[string]map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = make(map[string]map[string]string, len(data))
+	s.bytes = make(map[string]int64, len(data))
+	for keyspace, keys := range data {
+		for k, v := range keys {
+			s.set(keyspace, k, v)
+		}
+	}
+}
+
+// Size returns the number of keys and the bytes taken by keys and values.
+func (s *Storage) Size() (int, int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys int
+	var bytes int64
+	for keyspace := range s.data {
+		keys += len(s.data[keyspace])
+		bytes += s.bytes[keyspace]
+	}
+	return keys, bytes
+}
+
+// Usage returns the size of one keyspace.
+func (s *Storage) Usage(keyspace string) api.KeyspaceUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return api.KeyspaceUsage{Keys: len(s.data[keyspace]), Bytes: s.bytes[keyspace]}
+}
+
+// Keyspaces returns the size of every keyspace holding keys.
+func (s *Storage) Keyspaces() map[string]api.KeyspaceUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	usage := make(map[string]api.KeyspaceUsage, len(s.data))
+	for keyspace, keys := range s.data {
+		usage[keyspace] = api.KeyspaceUsage{Keys: len(keys), Bytes: s.bytes[keyspace]}
+	}
+	return usage
+}
+
+// Lookup returns the current value of a key for a write that is about to
+// replace it, so the change in size can be checked against a quota.
+func (s *Storage) Lookup(keyspace, key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, ok := s.data[keyspace][key]
+	return val, ok
+}
+
+// ExportRange returns a copy of every pair whose key falls inside r, by keyspace.
+func (s *Storage) ExportRange(r partition.Range) map[string]map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := make(map[string]map[string]string)
+	for keyspace, keys := range s.data {
+		for k, v := range keys {
+			if !r.Contains(k) {
+				continue
+			}
+			if data[keyspace] == nil {
+				data[keyspace] = make(map[string]string)
+			}
+			data[keyspace][k] = v
+		}
+	}
+	return data
+}
+
+// MedianKey returns the median key inside r and the number of keys in r,
+// counted over all keyspaces.
+func (s *Storage) MedianKey(r partition.Range) (string, int) {
+	s.mu.RLock()
+	keys := make([]string, 0)
+	for _, keyspace := range s.data {
+		for k := range keyspace {
+			if r.Contains(k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(keys) == 0 {
+		return "", 0
+	}
+	sort.Strings(keys)
+	return keys[len(keys)/2], len(keys)
+}
@@ -6,11 +6,19 @@ import (
 
 type WALRecord struct {
 	Operation string
+	Keyspace  string
 	Key       string
 	Value     string
 	Seq       int64
 }
 
+// Snapshot is a point-in-time copy of a master's store by keyspace. Replaying
+// the WAL records after Seq on top of Data yields the master's current state.
+type Snapshot struct {
+	Seq  int64                        `json:"seq"`
+	Data map[string]map[string]string `json:"data"`
+}
+
 type WAL struct {
 	ShardKey  int
 	Records   []WALRecord
@@ -19,22 +27,25 @@ type WAL struct {
 	followers map[int]int64 // Map of follower ID to their last applied sequence
 }
 
-func NewWAL(shardKey int) *WAL {
+// NewWAL creates an empty log whose first record will follow startSeq, so a
+// promoted follower keeps numbering where its old master left off.
+func NewWAL(shardKey int, startSeq int64) *WAL {
 	return &WAL{
 		ShardKey:  shardKey,
 		Records:   make([]WALRecord, 0),
-		seq:       0,
+		seq:       startSeq,
 		followers: make(map[int]int64),
 	}
 }
 
-func (w *WAL) Append(op, key, value string) {
+func (w *WAL) Append(op, keyspace, key, value string) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	w.seq++
 	record := WALRecord{
 		Operation: op,
+		Keyspace:  keyspace,
 		Key:       key,
 		Value:     value,
 		Seq:       w.seq,
@@ -68,6 +79,17 @@ func (w *WAL) GetSince(seq int64) []WALRecord {
 	return w.Records[start:]
 }
 
+// Covers reports whether every record after seq is still retained in the log.
+func (w *WAL) Covers(seq int64) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if seq >= w.seq {
+		return true
+	}
+	return len(w.Records) > 0 && w.Records[0].Seq <= seq+1
+}
+
 func (w *WAL) ClearUntil(seq int64) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -104,6 +126,18 @@ func (w *WAL) GetMinFollowerSeq() int64 {
 	return minSeq
 }
 
+// Followers returns the IDs of the followers whose progress is tracked.
+func (w *WAL) Followers() []int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	ids := make([]int, 0, len(w.followers))
+	for id := range w.followers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 func (w *WAL) RemoveFollower(followerID int) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
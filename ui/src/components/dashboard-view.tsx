@@ -6,6 +6,7 @@ import {ClusterOverview} from "@/components/cluster-overview"
 import {NodesList} from "@/components/nodes-list"
 import {PartitionManager} from "@/components/partition-manager"
 import {NodeMetrics} from "@/components/node-metrics"
+import {LoadBalancersList} from "@/components/loadbalancers-list"
 import {AddNodeDialog} from "@/components/add-node-dialog"
 import {Button} from "@/components/ui/button"
 import {PlusCircle} from "lucide-react"
@@ -37,6 +38,8 @@ export default function DashboardView() {
                     </div>
                 </div>
 
+                <LoadBalancersList/>
+
                 {selectedNodeId && (<div className="mt-6">
                         <NodeMetrics nodeId={selectedNodeId}/>
                     </div>)}
@@ -0,0 +1,84 @@
+"use client"
+
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from "@/components/ui/card"
+import { Table, TableBody, TableCell, TableHead, TableHeader, TableRow } from "@/components/ui/table"
+import { Badge } from "@/components/ui/badge"
+import { useGetLoadBalancers } from "@/lib/api/api";
+
+export function LoadBalancersList() {
+    const { data } = useGetLoadBalancers()
+    const loadBalancers = data ?? []
+
+    const getStateBadge = (lb: ApiTypes.LoadBalancer) => {
+        if (lb.stale) {
+            return (
+                <Badge variant="outline" className="bg-red-50 text-red-700 border-red-200">
+                    Stale
+                </Badge>
+            )
+        }
+        switch (lb.state) {
+            case "ready":
+                return (
+                    <Badge variant="outline" className="bg-green-50 text-green-700 border-green-200">
+                        Ready
+                    </Badge>
+                )
+            case "draining":
+                return (
+                    <Badge variant="outline" className="bg-yellow-50 text-yellow-700 border-yellow-200">
+                        Draining
+                    </Badge>
+                )
+            default:
+                return (
+                    <Badge variant="outline" className="bg-gray-50 text-gray-700 border-gray-200">
+                        Starting
+                    </Badge>
+                )
+        }
+    }
+
+    return (
+        <Card>
+            <CardHeader>
+                <CardTitle>Load Balancers</CardTitle>
+                <CardDescription>Routers registered with the controller</CardDescription>
+            </CardHeader>
+            <CardContent>
+                <Table>
+                    <TableHeader>
+                        <TableRow>
+                            <TableHead>State</TableHead>
+                            <TableHead>ID</TableHead>
+                            <TableHead>Address</TableHead>
+                            <TableHead>Topology</TableHead>
+                            <TableHead>Settings</TableHead>
+                            <TableHead>Last Heartbeat</TableHead>
+                        </TableRow>
+                    </TableHeader>
+                    <TableBody>
+                        {loadBalancers.length === 0 ? (
+                            <TableRow>
+                                <TableCell colSpan={6} className="text-center text-muted-foreground">
+                                    No load balancers registered
+                                </TableCell>
+                            </TableRow>
+                        ) : (
+                            loadBalancers.map((lb) => (
+                                <TableRow key={lb.id}>
+                                    <TableCell>{getStateBadge(lb)}</TableCell>
+                                    <TableCell className="font-medium">{lb.id}</TableCell>
+                                    <TableCell>{lb.address}</TableCell>
+                                    <TableCell>v{lb.topology_version}</TableCell>
+                                    <TableCell>v{lb.settings_version}</TableCell>
+                                    <TableCell>{new Date(lb.last_heartbeat).toLocaleTimeString()}</TableCell>
+                                </TableRow>
+                            ))
+                        )}
+                    </TableBody>
+                </Table>
+            </CardContent>
+        </Card>
+    )
+}
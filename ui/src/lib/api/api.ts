@@ -131,6 +131,149 @@ export const movePartition = async (partitionId: string, data: ApiTypes.MovePart
     return response.json();
 };
 
+const eventQuery = (filter: ApiTypes.EventFilter = {}): string => {
+    const params = new URLSearchParams();
+    Object.entries(filter).forEach(([key, value]) => {
+        if (value === undefined) return;
+        params.set(key, Array.isArray(value) ? value.join(",") : String(value));
+    });
+    return params.toString();
+};
+
+export const getKeyspaces = async (): Promise<ApiTypes.Keyspace[]> => {
+    const response = await fetch(`${API_BASE_URL}/admin/keyspaces`);
+
+    if (!response.ok) {
+        throw new Error('Failed to get keyspaces');
+    }
+
+    return (await response.json()).keyspaces;
+};
+
+export const createKeyspace = async (data: ApiTypes.CreateKeyspaceRequest): Promise<ApiTypes.Keyspace> => {
+    const response = await fetch(`${API_BASE_URL}/admin/keyspaces`, {
+        method: 'POST', headers: {
+            'Content-Type': 'application/json',
+        }, body: JSON.stringify(data),
+    });
+
+    if (!response.ok) {
+        throw new Error('Failed to create keyspace');
+    }
+
+    return response.json();
+};
+
+export const dropKeyspace = async (name: string): Promise<void> => {
+    const response = await fetch(`${API_BASE_URL}/admin/keyspaces/${encodeURIComponent(name)}`, {
+        method: 'DELETE',
+    });
+
+    if (!response.ok) {
+        throw new Error('Failed to drop keyspace');
+    }
+};
+
+export const getHotspots = async (limit = 10): Promise<ApiTypes.HotspotReport> => {
+    const response = await fetch(`${API_BASE_URL}/admin/hotspots?limit=${limit}`);
+
+    if (!response.ok) {
+        throw new Error('Failed to get hotspots');
+    }
+
+    return response.json();
+};
+
+export const getSettings = async (): Promise<ApiTypes.SettingsDocument> => {
+    const response = await fetch(`${API_BASE_URL}/admin/settings`);
+
+    if (!response.ok) {
+        throw new Error('Failed to get settings');
+    }
+
+    return response.json();
+};
+
+/**
+ * Replace the cluster-wide runtime settings, or the overrides of one node when nodeId is given
+ */
+export const setSettings = async (settings: ApiTypes.RuntimeSettings, nodeId?: number): Promise<ApiTypes.SettingsDocument> => {
+    const path = nodeId === undefined ? '/admin/settings' : `/admin/settings/nodes/${nodeId}`;
+    const response = await fetch(`${API_BASE_URL}${path}`, {
+        method: 'PUT', headers: {
+            'Content-Type': 'application/json',
+        }, body: JSON.stringify(settings),
+    });
+
+    if (!response.ok) {
+        throw new Error('Failed to set settings');
+    }
+
+    return response.json();
+};
+
+export const clearNodeSettings = async (nodeId: number): Promise<ApiTypes.SettingsDocument> => {
+    const response = await fetch(`${API_BASE_URL}/admin/settings/nodes/${nodeId}`, {
+        method: 'DELETE',
+    });
+
+    if (!response.ok) {
+        throw new Error('Failed to clear node settings');
+    }
+
+    return response.json();
+};
+
+export const getSettingsStatus = async (): Promise<ApiTypes.SettingsStatus> => {
+    const response = await fetch(`${API_BASE_URL}/admin/settings/status`);
+
+    if (!response.ok) {
+        throw new Error('Failed to get settings status');
+    }
+
+    return response.json();
+};
+
+/**
+ * List the load balancers registered with the controller
+ */
+export const getLoadBalancers = async (): Promise<ApiTypes.LoadBalancer[]> => {
+    const response = await fetch(`${API_BASE_URL}/admin/loadbalancers`);
+
+    if (!response.ok) {
+        throw new Error('Failed to get load balancers');
+    }
+
+    return (await response.json()).loadbalancers;
+};
+
+export const useGetLoadBalancers = () => {
+    return useSWR("loadbalancers", () => getLoadBalancers(), {refreshInterval: 5000})
+}
+
+/**
+ * Get the retained cluster events, oldest first
+ */
+export const getEvents = async (filter?: ApiTypes.EventFilter): Promise<ApiTypes.ClusterEvent[]> => {
+    const response = await fetch(`${API_BASE_URL}/admin/events?${eventQuery(filter)}`);
+
+    if (!response.ok) {
+        throw new Error('Failed to get events');
+    }
+
+    return (await response.json()).events;
+};
+
+/**
+ * Stream cluster events as they happen. The browser reconnects on its own and
+ * resumes after the last event it received. Returns a function that closes the stream.
+ */
+export const subscribeEvents = (onEvent: (event: ApiTypes.ClusterEvent) => void, filter?: ApiTypes.EventFilter): (() => void) => {
+    const source = new EventSource(`${API_BASE_URL}/admin/events/stream?${eventQuery(filter)}`);
+    source.addEventListener("event", (e) => onEvent(JSON.parse((e as MessageEvent).data)));
+    return () => source.close();
+};
+
 export interface Address {
     ip: string
     port: number
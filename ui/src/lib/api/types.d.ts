@@ -2,8 +2,39 @@
 
  declare namespace ApiTypes {
     // Health check
+    export type HealthStatus = "green" | "yellow" | "red"
+
+    export interface ShardHealth {
+        shard_key: number
+        status: HealthStatus
+        epoch: number
+        has_master: boolean
+        master_id: number // -1 without a master
+        in_sync: number
+        replicas: number
+        max_lag: number
+        issues?: string[]
+    }
+
+    export interface NodeHealth {
+        id: number
+        shard_key: number
+        status: string
+        role: string
+        last_seq: number
+        lag: number
+        last_heartbeat: string | null
+        phi: number
+        // load balancers whose circuit breaker to the node is not closed
+        open_breakers?: string[]
+    }
+
     export interface HealthCheckResponse {
-        // Will contain health status information
+        status: HealthStatus
+        time: string
+        shards: ShardHealth[]
+        nodes: NodeHealth[]
+        warnings: string[]
     }
 
     // Node management
@@ -51,4 +82,139 @@
     export interface MovePartitionResponse {
         // Result of partition move
     }
-}
\ No newline at end of file
+
+    // Cluster event journal
+    export type ClusterEventType =
+        | "node_registered"
+        | "node_suspected"
+        | "node_failed"
+        | "node_recovered"
+        | "node_active"
+        | "node_added"
+        | "node_decommissioned"
+        | "maintenance_started"
+        | "maintenance_ended"
+        | "leader_changed"
+        | "replicas_changed"
+        | "shard_split"
+        | "shards_merged"
+        | "keyspace_created"
+        | "keyspace_dropped"
+
+    export interface ClusterEvent {
+        id: number
+        time: string
+        type: ClusterEventType
+        node_id: number // -1 when not about a single node
+        shard_key: number // -1 when not about a single shard
+        actor: string
+        reason?: string
+        details?: Record<string, string>
+    }
+
+    export interface EventFilter {
+        type?: ClusterEventType[]
+        node?: number
+        shard?: number
+        after_id?: number
+        since?: string
+        limit?: number
+    }
+
+    // Hot shards and keys
+    export interface KeyLoad {
+        key: string
+        ops_per_sec: number
+        error: number
+    }
+
+    export interface ShardLoad {
+        shard_key: number
+        master_id: number
+        ops_per_sec: number
+        keys: number
+        hot: boolean
+        top_keys: KeyLoad[]
+        recommendation?: string
+        split_key?: string
+    }
+
+    export interface HotKey extends KeyLoad {
+        shard_key: number
+    }
+
+    export interface HotspotReport {
+        average_ops_per_sec: number
+        load_factor: number
+        shards: ShardLoad[]
+        keys: HotKey[]
+    }
+
+    // Keyspaces, zero quota limits are unlimited
+    export interface KeyspaceQuota {
+        max_keys: number
+        max_bytes: number
+        max_ops_per_sec: number
+    }
+
+    export interface KeyspaceUsage {
+        keys: number
+        bytes: number
+    }
+
+    export interface Keyspace {
+        name: string
+        quota: KeyspaceQuota
+        created: string
+        usage?: KeyspaceUsage
+    }
+
+    export interface CreateKeyspaceRequest {
+        name: string
+        quota?: Partial<KeyspaceQuota>
+    }
+
+    // Runtime settings, unset fields keep each component's config file value
+    export interface RuntimeSettings {
+        log_level?: string
+        http_timeout_ms?: number
+        heartbeat_interval_ms?: number
+        hot_key_sample_rate?: number
+        topology_poll_interval_ms?: number
+    }
+
+    export interface SettingsDocument {
+        version: number
+        updated: string
+        settings: RuntimeSettings
+        node_overrides: Record<string, RuntimeSettings>
+    }
+
+    export interface ComponentSettings {
+        component: 'node' | 'loadbalancer'
+        id: string
+        version: number
+        current: boolean
+        reported: string
+    }
+
+    export interface SettingsStatus {
+        version: number
+        components: ComponentSettings[]
+    }
+
+    // Load balancer fleet
+    export type LoadBalancerState = "starting" | "ready" | "draining"
+
+    export interface LoadBalancer {
+        id: string
+        address: string
+        state: LoadBalancerState
+        topology_version: number
+        settings_version: number
+        started: string
+        last_heartbeat: string
+        // missed its recent heartbeats
+        stale: boolean
+    }
+}